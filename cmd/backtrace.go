@@ -0,0 +1,100 @@
+// Package cmd provides functionality for command line operations.
+package cmd
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// WithBacktrace is a function that returns an OptFunc which enables the backtrace mode.
+// Debug entries below the logging level of a scoped logger, created by WithCtx or WithFields, are kept in a
+// ring buffer of the given size. They are logged, along with the error trace, when that logger later logs at
+// error level or above, and are discarded with the logger otherwise.
+func WithBacktrace(size int) OptFunc {
+	return func(l *CMD) (err error) {
+		if size <= 0 {
+			return fmt.Errorf("invalid backtrace size: %d", size)
+		}
+
+		l.btSize = size
+		return
+	}
+}
+
+// bufferedEntry is a struct that holds a debug entry waiting in a backtrace.
+type bufferedEntry struct {
+	time    time.Time
+	message string
+	fields  logrus.Fields
+}
+
+// backtrace is a struct that holds a bounded ring buffer of debug entries.
+type backtrace struct {
+	mu      sync.Mutex
+	entries []bufferedEntry
+	next    int
+	full    bool
+}
+
+// newBacktrace is a function that creates a backtrace holding at most size entries.
+func newBacktrace(size int) *backtrace {
+	return &backtrace{entries: make([]bufferedEntry, size)}
+}
+
+// add is a method that buffers an entry, overwriting the oldest one when the buffer is full.
+func (b *backtrace) add(message string, fields logrus.Fields) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.entries[b.next] = bufferedEntry{time: time.Now(), message: message, fields: fields}
+	b.next = (b.next + 1) % len(b.entries)
+	if b.next == 0 {
+		b.full = true
+	}
+}
+
+// drain is a method that returns the buffered entries from oldest to newest and empties the buffer.
+func (b *backtrace) drain() (entries []bufferedEntry) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.full {
+		entries = append(entries, b.entries[b.next:]...)
+	}
+	entries = append(entries, b.entries[:b.next]...)
+
+	clear(b.entries)
+	b.next = 0
+	b.full = false
+	return
+}
+
+// scoped is a method that gives a derived logger its own backtrace unless it already shares its parent's.
+func (l *CMD) scoped() {
+	if l.btSize > 0 && l.bt == nil {
+		l.bt = newBacktrace(l.btSize)
+	}
+}
+
+// flushBacktrace is a method that logs the buffered debug entries ahead of the error entry.
+// Every flushed entry is linked to the error message and carries the error trace when there is one.
+func (l CMD) flushBacktrace(message string, entry *logrus.Entry) {
+	if l.bt == nil {
+		return
+	}
+
+	for _, be := range l.bt.drain() {
+		fields := logrus.Fields{"backtrace_of": message}
+		for k, v := range be.fields {
+			fields[k] = v
+		}
+		if trace, ok := entry.Data["trace"]; ok {
+			fields["trace"] = trace
+		}
+
+		l.lg.WithFields(fields).WithTime(be.time).Debug(be.message)
+	}
+}
@@ -6,8 +6,10 @@ import (
 	"fmt"
 	"github.com/dyaksa/telemetry-log/err"
 	"github.com/dyaksa/telemetry-log/telemetry/log"
+	"os"
 	"path"
 	"runtime"
+	"strconv"
 	"strings"
 	"time"
 
@@ -29,22 +31,44 @@ const (
 	LevelFatal
 )
 
-// WithLogLevel is a function that returns an OptFunc which sets the logging level of a CMD instance.
-func WithLogLevel(s string) OptFunc {
+// String is a method that returns the name of the level.
+func (lvl Level) String() string {
+	switch lvl {
+	case LevelDebug:
+		return "debug"
+	case LevelInfo:
+		return "info"
+	case LevelWarn:
+		return "warn"
+	case LevelError:
+		return "error"
+	case LevelFatal:
+		return "fatal"
+	}
+	return strconv.Itoa(int(lvl))
+}
+
+// ParseLevel is a function that returns the Level named by s, or -1 if s is not a known level.
+func ParseLevel(s string) Level {
 	var l Level = -1
 	switch strings.ToLower(s) {
 	case "debug":
 		l = 0
 	case "info":
 		l = 1
-	case "warn":
+	case "warn", "warning":
 		l = 2
 	case "error":
 		l = 3
 	case "fatal":
 		l = 4
 	}
-	return WithLevel(l)
+	return l
+}
+
+// WithLogLevel is a function that returns an OptFunc which sets the logging level of a CMD instance.
+func WithLogLevel(s string) OptFunc {
+	return WithLevel(ParseLevel(s))
 }
 
 // WithLevel is a function that returns an OptFunc which sets the logging level of a CMD instance.
@@ -75,12 +99,89 @@ func WithHook(hook logrus.Hook) OptFunc {
 	}
 }
 
+// WithExitHandler is a function that returns an OptFunc which registers a handler run before Fatal terminates.
+// Handlers run after the terminating entry has been logged, so they can flush it to its sinks.
+func WithExitHandler(fn func()) OptFunc {
+	return func(l *CMD) (err error) {
+		l.exit.handlers = append(l.exit.handlers, fn)
+		return
+	}
+}
+
+// WithFlushHandler is a function that returns an OptFunc which registers a handler run before Panic propagates.
+// A panic can be recovered, so these handlers should only flush the sinks, not shut them down as exit handlers do.
+func WithFlushHandler(fn func()) OptFunc {
+	return func(l *CMD) (err error) {
+		l.exit.flushers = append(l.exit.flushers, fn)
+		return
+	}
+}
+
+// WithExitFunc is a function that returns an OptFunc which sets the function Fatal calls to terminate the process.
+// It defaults to os.Exit and is mostly useful to make the exit behaviour injectable in tests.
+func WithExitFunc(fn func(int)) OptFunc {
+	return func(l *CMD) (err error) {
+		if fn == nil {
+			return errors.New("exit func must not be nil")
+		}
+
+		l.exit.fn = fn
+		return
+	}
+}
+
+// exitHandlers is a struct that holds the handlers run before the process terminates or a panic propagates.
+type exitHandlers struct {
+	handlers []func()
+	flushers []func()
+	fn       func(int)
+}
+
+// flush is a method that runs every registered flush handler in registration order.
+func (e *exitHandlers) flush() {
+	for _, h := range e.flushers {
+		h()
+	}
+}
+
+// run is a method that runs every registered handler in registration order.
+func (e *exitHandlers) run() {
+	for _, h := range e.handlers {
+		h()
+	}
+}
+
+// exit is a method that runs the registered handlers and then terminates the process with the given code.
+func (e *exitHandlers) exit(code int) {
+	e.run()
+	e.fn(code)
+}
+
+// Redactor is an interface that defines a method for removing sensitive data from the fields of an entry.
+type Redactor interface {
+	Redact(fields map[string]interface{}) // Redact modifies fields in place.
+}
+
+// WithRedactor is a function that returns an OptFunc which redacts the fields of every entry before it is formatted or hooked.
+func WithRedactor(r Redactor) OptFunc {
+	return func(l *CMD) (err error) {
+		l.redactor = r
+		return
+	}
+}
+
 // CMD is a struct that holds the necessary information for command line operations.
 type CMD struct {
 	lg       *logrus.Logger
 	lvl      Level
 	ctxFunc  []log.LogContextFunc
 	errTrace *err.ErrorTracer
+	diag     *diagnostics
+	exit     *exitHandlers
+	drops    *dropSummary
+	btSize   int
+	bt       *backtrace
+	redactor Redactor
 }
 
 // New is a function that creates a new CMD instance.
@@ -88,8 +189,10 @@ type CMD struct {
 func New(opts ...OptFunc) (l log.Logger, err error) {
 	logr := logrus.New()
 	lg := &CMD{
-		lg:  logr,
-		lvl: LevelInfo,
+		lg:    logr,
+		lvl:   LevelInfo,
+		exit:  &exitHandlers{fn: os.Exit},
+		drops: newDropSummary(logr),
 	}
 
 	for _, opt := range opts {
@@ -97,6 +200,15 @@ func New(opts ...OptFunc) (l log.Logger, err error) {
 			return
 		}
 	}
+	lg.drops.lvl = lg.lvl
+
+	// The debug entries of a backtrace are logged once an error occurs, so logrus must let them through; otherwise
+	// it keeps its own level and drops debug entries as it always did.
+	if lg.btSize > 0 {
+		logr.SetLevel(logrus.DebugLevel)
+	}
+
+	logr.ExitFunc = lg.exit.exit
 
 	l = lg
 	return
@@ -106,6 +218,9 @@ func New(opts ...OptFunc) (l log.Logger, err error) {
 func (l *CMD) logWithFields(fn ...log.LogContextFunc) (entry *logrus.Entry) {
 	ctx := newLoggerContext(append(l.ctxFunc, fn...)...)
 	mergedFields := mergeFields(ctx.fields)
+	if l.redactor != nil {
+		l.redactor.Redact(mergedFields)
+	}
 	entry = l.lg.WithFields(mergedFields)
 	return
 }
@@ -136,6 +251,14 @@ func addTraceInfo() log.LogContextFunc {
 // Debug is a method that logs a debug message.
 func (l CMD) Debug(message string, fn ...log.LogContextFunc) {
 	if l.lvl > LevelDebug {
+		if l.bt != nil {
+			fn = append(fn, addTraceInfo())
+			l.bt.add(message, l.logWithFields(fn...).Data)
+		}
+		return
+	}
+
+	if !l.sampled(LevelDebug, message) {
 		return
 	}
 
@@ -146,7 +269,7 @@ func (l CMD) Debug(message string, fn ...log.LogContextFunc) {
 
 // Info is a method that logs an informational message.
 func (l CMD) Info(message string, fn ...log.LogContextFunc) {
-	if l.lvl > LevelInfo {
+	if l.lvl > LevelInfo || !l.sampled(LevelInfo, message) {
 		return
 	}
 
@@ -157,7 +280,7 @@ func (l CMD) Info(message string, fn ...log.LogContextFunc) {
 
 // Warn is a method that logs a warning message.
 func (l CMD) Warn(message string, fn ...log.LogContextFunc) {
-	if l.lvl > LevelWarn {
+	if l.lvl > LevelWarn || !l.sampled(LevelWarn, message) {
 		return
 	}
 
@@ -168,13 +291,16 @@ func (l CMD) Warn(message string, fn ...log.LogContextFunc) {
 
 // Error is a method that logs an error message.
 func (l CMD) Error(message string, fn ...log.LogContextFunc) {
-	if l.lvl > LevelError {
+	if l.lvl > LevelError || !l.sampled(LevelError, message) {
 		return
 	}
 
 	fn = append(fn, addTraceInfo())
 
-	l.logWithFields(fn...).Error(message)
+	entry := l.logWithFields(fn...)
+	l.flushBacktrace(message, entry)
+
+	entry.Error(message)
 }
 
 // Fatal is a method that logs a fatal error message.
@@ -185,13 +311,37 @@ func (l CMD) Fatal(message string, fn ...log.LogContextFunc) {
 
 	fn = append(fn, addTraceInfo())
 
-	l.logWithFields(fn...).Fatal(message)
+	entry := l.logWithFields(fn...)
+	l.flushBacktrace(message, entry)
+
+	if l.diag != nil {
+		l.diag.dump(message, entry.Data)
+	}
+
+	entry.Fatal(message)
+}
+
+// Panic is a method that logs a message at panic level and then panics.
+// The registered flush handlers run before the panic propagates to the caller, who may still recover and go on logging.
+func (l CMD) Panic(message string, fn ...log.LogContextFunc) {
+	if l.lvl > LevelFatal {
+		return
+	}
+
+	fn = append(fn, addTraceInfo())
+
+	entry := l.logWithFields(fn...)
+	l.flushBacktrace(message, entry)
+
+	defer l.exit.flush()
+	entry.Panic(message)
 }
 
 // WithCtx is a method that returns a new Logger with the specified context.
 func (l CMD) WithCtx(fn log.LogContextFunc) log.Logger {
 	newLogger := l
 	newLogger.ctxFunc = append(newLogger.ctxFunc, fn)
+	newLogger.scoped()
 	return &newLogger
 }
 
@@ -211,6 +361,7 @@ func (l CMD) WithFields(fields map[string]interface{}) log.Logger {
 	for key, field := range fields {
 		newLogger.ctxFunc = append(newLogger.ctxFunc, log.Any(key, field))
 	}
+	newLogger.scoped()
 	return &newLogger
 }
 
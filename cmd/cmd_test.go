@@ -0,0 +1,252 @@
+package cmd_test
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dyaksa/telemetry-log/cmd"
+	errtrace "github.com/dyaksa/telemetry-log/err"
+	"github.com/dyaksa/telemetry-log/telemetry/log"
+	"github.com/sirupsen/logrus"
+)
+
+type countHook struct {
+	mu      sync.Mutex
+	entries []*logrus.Entry
+}
+
+func (h *countHook) Levels() []logrus.Level { return logrus.AllLevels }
+
+func (h *countHook) Fire(e *logrus.Entry) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.entries = append(h.entries, e)
+	return nil
+}
+
+func (h *countHook) count() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.entries)
+}
+
+func TestSampling(t *testing.T) {
+	hook := &countHook{}
+	l, err := cmd.New(cmd.WithHook(hook), cmd.WithSampling(cmd.LevelWarn, 3, 10, time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 100; i++ {
+		l.Warn("dependency down")
+	}
+
+	// 3 first entries, then entries 13, 23, ..., 93.
+	if got, want := hook.count(), 3+9; got != want {
+		t.Fatalf("got %d entries, want %d", got, want)
+	}
+}
+
+func TestRateLimitedHook(t *testing.T) {
+	hook := &countHook{}
+	l, err := cmd.New(cmd.WithRateLimitedHook("count", hook, 0.001, 5))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 20; i++ {
+		l.Error("insert failed")
+	}
+
+	if got := hook.count(); got != 5 {
+		t.Fatalf("got %d entries, want 5", got)
+	}
+}
+
+func TestDropSummary(t *testing.T) {
+	hook := &countHook{}
+	l, err := cmd.New(cmd.WithRateLimitedHook("count", hook, 0.001, 1), cmd.WithDropSummary(10*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l.Error("insert failed")
+	l.Error("insert failed")
+	l.Error("insert failed")
+
+	// The summary is logged without waiting for another entry, and the exhausted limiter lets it through.
+	for deadline := time.Now().Add(time.Second); hook.count() < 2 && time.Now().Before(deadline); {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	hook.mu.Lock()
+	defer hook.mu.Unlock()
+	if len(hook.entries) != 2 || hook.entries[1].Message != "log entries dropped" || hook.entries[1].Data["dropped"] != uint64(2) {
+		t.Fatalf("unexpected entries: %+v", hook.entries)
+	}
+}
+
+func TestBacktrace(t *testing.T) {
+	hook := &countHook{}
+	l, err := cmd.New(cmd.WithHook(hook), cmd.WithLogLevel("info"), cmd.WithBacktrace(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l.Debug("dropped at root")
+
+	scoped := l.WithFields(map[string]interface{}{"request_id": "42"})
+	scoped.Debug("step 1")
+	scoped.Debug("step 2")
+	scoped.Debug("step 3")
+	if got := hook.count(); got != 0 {
+		t.Fatalf("got %d entries before the error, want 0", got)
+	}
+
+	scoped.Error("request failed")
+
+	if got := hook.count(); got != 3 {
+		t.Fatalf("got %d entries, want 3", got)
+	}
+	if msg := hook.entries[0].Message; msg != "step 2" {
+		t.Fatalf("got first backtrace entry %q, want %q", msg, "step 2")
+	}
+	if of := hook.entries[1].Data["backtrace_of"]; of != "request failed" {
+		t.Fatalf("got backtrace_of %v, want %q", of, "request failed")
+	}
+}
+
+func TestDebugVolumeWithoutBacktrace(t *testing.T) {
+	hook := &countHook{}
+	l, err := cmd.New(cmd.WithHook(hook), cmd.WithLogLevel("debug"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l.Debug("cache miss")
+	l.Info("request served")
+	if got := hook.count(); got != 1 {
+		t.Fatalf("got %d entries, want the debug entry left to the logrus level as before backtraces", got)
+	}
+}
+
+func TestConsoleFormatter(t *testing.T) {
+	e := &logrus.Entry{
+		Time:    time.Date(2024, 6, 14, 10, 0, 0, 0, time.UTC),
+		Level:   logrus.ErrorLevel,
+		Message: "payment failed",
+		Data: logrus.Fields{
+			"file": "pay.go", "line": 42, "func": "main.pay",
+			"service": "billing", "reason": "card declined", "code": 502,
+			"trace": []errtrace.Stack{{Name: "main.pay", File: "pay.go", Line: "42"}, {Name: "main.main", File: "main.go", Line: "9"}},
+		},
+	}
+
+	b, err := (&cmd.ConsoleFormatter{}).Format(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "10:00:00.000 ERROR payment failed  pay.go:42  code=502 reason=\"card declined\" service=billing\n" +
+		"    at main.pay pay.go:42\n" +
+		"    at main.main main.go:9\n"
+	if string(b) != want {
+		t.Fatalf("got\n%q\nwant\n%q", b, want)
+	}
+
+	if b, _ = (&cmd.ConsoleFormatter{Color: true}).Format(e); !strings.Contains(string(b), "\x1b[31mERROR\x1b[0m") {
+		t.Fatalf("level is not colored: %q", b)
+	}
+
+	e = &logrus.Entry{
+		Time:    e.Time,
+		Level:   logrus.InfoLevel,
+		Message: "user logged in\n10:00:01.000 ERROR \x1b[2Jforged",
+		Data:    logrus.Fields{"tags": []string{"a\x1b[31m"}},
+	}
+	if b, _ = (&cmd.ConsoleFormatter{}).Format(e); strings.Count(string(b), "\n") != 1 || strings.Contains(string(b), "\x1b") {
+		t.Fatalf("control characters written unescaped: %q", b)
+	}
+	if !strings.Contains(string(b), `"user logged in\n10:00:01.000 ERROR \x1b[2Jforged"`) {
+		t.Fatalf("message not quoted: %q", b)
+	}
+}
+
+func TestLogfmt(t *testing.T) {
+	type request struct {
+		Route  string `json:"route"`
+		Status int    `json:"status"`
+	}
+	e := &logrus.Entry{
+		Time:    time.Date(2024, 6, 14, 10, 0, 0, 0, time.UTC),
+		Level:   logrus.ErrorLevel,
+		Message: "payment \"failed\"",
+		Data: logrus.Fields{
+			"file": "pay.go", "line": 42, "func": "main.pay",
+			"amount": 1250000, "note": "a=b\nc", "empty": "",
+			"http":  map[string]interface{}{"request": request{Route: "/pay", Status: 502}, "method": "POST"},
+			"trace": []errtrace.Stack{{Name: "main.pay", File: "pay.go", Line: "42"}, {Name: "main.main", File: "main.go", Line: "9"}},
+		},
+	}
+
+	b, err := (&cmd.Logfmt{}).Format(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `time=2024-06-14T10:00:00Z level=error msg="payment \"failed\"" caller=pay.go:42 ` +
+		`amount=1250000 empty="" func=main.pay http.method=POST http.request.route=/pay http.request.status=502 ` +
+		`note="a=b\nc" trace=main.pay@pay.go:42,main.main@main.go:9` + "\n"
+	if string(b) != want {
+		t.Fatalf("got\n%s\nwant\n%s", b, want)
+	}
+	e.Data = logrus.Fields{
+		"level": "debug", "msg": "override", "a.b": 1,
+		"a": map[string]interface{}{"b": 2},
+	}
+	if b, err = (&cmd.Logfmt{}).Format(e); err != nil {
+		t.Fatal(err)
+	}
+
+	want = `time=2024-06-14T10:00:00Z level=error msg="payment \"failed\"" ` +
+		`a.b=2 fields.a.b=1 fields.level=debug fields.msg=override` + "\n"
+	if string(b) != want {
+		t.Fatalf("got\n%s\nwant\n%s", b, want)
+	}
+}
+
+type diagnosticsWriter struct{ docs []*cmd.Diagnostics }
+
+func (w *diagnosticsWriter) WriteDiagnostics(d *cmd.Diagnostics) error {
+	w.docs = append(w.docs, d)
+	return nil
+}
+
+func TestCollectDiagnostics(t *testing.T) {
+	fields := map[string]interface{}{"code": 502, "body": strings.Repeat("x", cmd.DiagnosticsMaxFieldSize+10)}
+
+	d := cmd.CollectDiagnostics("bye", fields, 512)
+
+	if d.Goroutines < 1 || len(d.Stacks) != 512 || !d.Truncated {
+		t.Fatalf("stacks are not bounded: %d goroutines, %d bytes, truncated=%v", d.Goroutines, len(d.Stacks), d.Truncated)
+	}
+	if d.Fields["code"] != 502 || len(d.Fields["body"].(string)) != cmd.DiagnosticsMaxFieldSize || !d.FieldsCut {
+		t.Fatalf("fields are not bounded: %d bytes, cut=%v", len(d.Fields["body"].(string)), d.FieldsCut)
+	}
+}
+
+func TestFatalDumpsDiagnostics(t *testing.T) {
+	w := &diagnosticsWriter{}
+	l, err := cmd.New(cmd.WithDiagnostics(0, w), cmd.WithExitFunc(func(int) {}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l.Fatal("out of memory", log.Any("order_id", 42))
+
+	if len(w.docs) != 1 || w.docs[0].Message != "out of memory" || w.docs[0].Fields["order_id"] != 42 {
+		t.Fatalf("unexpected diagnostics: %+v", w.docs)
+	}
+}
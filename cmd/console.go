@@ -0,0 +1,221 @@
+// Package cmd provides functionality for command line operations.
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/dyaksa/telemetry-log/err"
+	"github.com/sirupsen/logrus"
+)
+
+// These constants are the ANSI escape sequences used by the ConsoleFormatter.
+const (
+	ansiReset   = "\x1b[0m"
+	ansiFaint   = "\x1b[2m"
+	ansiRed     = "\x1b[31m"
+	ansiYellow  = "\x1b[33m"
+	ansiCyan    = "\x1b[36m"
+	ansiGray    = "\x1b[90m"
+	ansiMagenta = "\x1b[1;35m"
+)
+
+// DefaultConsoleTimestampFormat is the timestamp layout of the ConsoleFormatter, fixed-width so that lines align.
+const DefaultConsoleTimestampFormat = "15:04:05.000"
+
+// PrettyFormatter is a function that returns an OptFunc which sets a ConsoleFormatter, readable by developers.
+// Colors are used when the output is a terminal, unless the NO_COLOR environment variable is set.
+func PrettyFormatter() OptFunc {
+	return func(l *CMD) (err error) {
+		l.lg.SetFormatter(&ConsoleFormatter{Color: colorSupported(l.lg.Out)})
+		return
+	}
+}
+
+// ConsoleFormatter is a struct that formats entries on one line for a console: the time, the level, the message,
+// the caller as file:line and the fields sorted by name. An error trace follows as an indented block. Messages and
+// values holding control characters are quoted, so an entry cannot break the line or send escape sequences.
+type ConsoleFormatter struct {
+	Color           bool   // Color enables ANSI colors.
+	TimestampFormat string // TimestampFormat is the layout of the time, DefaultConsoleTimestampFormat when empty.
+}
+
+// Format is a method that renders an entry as a line, followed by its trace when there is one.
+func (f *ConsoleFormatter) Format(e *logrus.Entry) ([]byte, error) {
+	layout := f.TimestampFormat
+	if layout == "" {
+		layout = DefaultConsoleTimestampFormat
+	}
+
+	b := &bytes.Buffer{}
+	f.paint(b, ansiFaint, e.Time.Format(layout))
+	b.WriteByte(' ')
+	f.paint(b, levelColor(e.Level), levelLabel(e.Level))
+	b.WriteByte(' ')
+	b.WriteString(formatMessage(e.Message))
+
+	if caller := callerOf(e.Data); caller != "" {
+		b.WriteString("  ")
+		f.paint(b, ansiFaint, caller)
+	}
+
+	keys := make([]string, 0, len(e.Data))
+	for k := range e.Data {
+		switch k {
+		case "file", "line", "func", "trace":
+			continue
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for i, k := range keys {
+		if i == 0 {
+			b.WriteString(" ")
+		}
+		b.WriteByte(' ')
+		f.paint(b, levelColor(e.Level), formatMessage(k))
+		b.WriteByte('=')
+		b.WriteString(formatValue(e.Data[k]))
+	}
+	b.WriteByte('\n')
+
+	f.writeTrace(b, e.Data["trace"])
+	return b.Bytes(), nil
+}
+
+// writeTrace is a method that writes a trace as an indented block, one frame per line.
+func (f *ConsoleFormatter) writeTrace(b *bytes.Buffer, trace interface{}) {
+	switch frames := trace.(type) {
+	case nil:
+	case []err.Stack:
+		for _, s := range frames {
+			b.WriteString("    at ")
+			b.WriteString(s.Name)
+			b.WriteByte(' ')
+			f.paint(b, ansiFaint, s.File+":"+s.Line)
+			b.WriteByte('\n')
+		}
+	case []interface{}:
+		for _, s := range frames {
+			fmt.Fprintf(b, "    at %v\n", s)
+		}
+	default:
+		fmt.Fprintf(b, "    %v\n", trace)
+	}
+}
+
+// paint is a method that writes s in the given color when colors are enabled.
+func (f *ConsoleFormatter) paint(b *bytes.Buffer, color, s string) {
+	if !f.Color {
+		b.WriteString(s)
+		return
+	}
+
+	b.WriteString(color)
+	b.WriteString(s)
+	b.WriteString(ansiReset)
+}
+
+// callerOf is a function that returns the caller of an entry as file:line, empty when unknown.
+func callerOf(data logrus.Fields) string {
+	file, ok := data["file"]
+	if !ok {
+		return ""
+	}
+
+	if line, ok := data["line"]; ok {
+		return fmt.Sprintf("%v:%v", file, line)
+	}
+
+	return fmt.Sprint(file)
+}
+
+// levelLabel is a function that returns the name of a level, upper case and padded to a fixed width.
+func levelLabel(lvl logrus.Level) string {
+	s := "WARN"
+	if lvl != logrus.WarnLevel {
+		s = strings.ToUpper(lvl.String())
+	}
+
+	return fmt.Sprintf("%-5s", s)
+}
+
+// levelColor is a function that returns the color of a level.
+func levelColor(lvl logrus.Level) string {
+	switch lvl {
+	case logrus.TraceLevel, logrus.DebugLevel:
+		return ansiGray
+	case logrus.InfoLevel:
+		return ansiCyan
+	case logrus.WarnLevel:
+		return ansiYellow
+	case logrus.ErrorLevel:
+		return ansiRed
+	}
+
+	return ansiMagenta
+}
+
+// formatValue is a function that returns a field value as text, quoted when it holds spaces or special characters.
+func formatValue(v interface{}) string {
+	var s string
+	switch val := v.(type) {
+	case string:
+		s = val
+	case error:
+		s = val.Error()
+	case fmt.Stringer:
+		s = val.String()
+	default:
+		return formatMessage(fmt.Sprint(v))
+	}
+
+	if s == "" || strings.ContainsAny(s, " =\"\t\r\n") || !strconv.CanBackquote(s) {
+		return strconv.Quote(s)
+	}
+
+	return s
+}
+
+// formatMessage is a function that returns a message as is, or quoted as a Go string when it holds newlines,
+// escape sequences or other non-printable characters that would break the line or drive the terminal.
+func formatMessage(s string) string {
+	if !utf8.ValidString(s) {
+		return strconv.Quote(s)
+	}
+
+	for _, r := range s {
+		if r != ' ' && !unicode.IsPrint(r) {
+			return strconv.Quote(s)
+		}
+	}
+
+	return s
+}
+
+// colorSupported is a function that reports whether w is a terminal that should be colored.
+// NO_COLOR disables colors and FORCE_COLOR enables them whatever the output.
+func colorSupported(w io.Writer) bool {
+	if _, ok := os.LookupEnv("NO_COLOR"); ok {
+		return false
+	}
+	if _, ok := os.LookupEnv("FORCE_COLOR"); ok {
+		return true
+	}
+
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+
+	st, statErr := f.Stat()
+	return statErr == nil && st.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,196 @@
+// Package cmd provides functionality for command line operations.
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultDiagnosticsSize is the default upper bound, in bytes, of the goroutine stacks captured in a Diagnostics.
+const DefaultDiagnosticsSize = 256 << 10
+
+// These constants bound the fields captured in a Diagnostics, so that a huge field cannot make the dump unwritable.
+const (
+	DiagnosticsMaxFields    = 64      // DiagnosticsMaxFields is the number of fields kept, by key order.
+	DiagnosticsMaxFieldSize = 4 << 10 // DiagnosticsMaxFieldSize is the size, in bytes, a field value is cut to.
+)
+
+// processStart is the time the process loaded this package, used to compute the uptime.
+var processStart = time.Now()
+
+// DiagnosticsWriter is an interface that defines a method for storing a Diagnostics document.
+type DiagnosticsWriter interface {
+	WriteDiagnostics(d *Diagnostics) error // WriteDiagnostics stores the document synchronously.
+}
+
+// MemStats is a struct that holds a subset of runtime.MemStats relevant to a crash report.
+type MemStats struct {
+	Alloc        uint64 `bson:"alloc" json:"alloc"`
+	TotalAlloc   uint64 `bson:"total_alloc" json:"total_alloc"`
+	Sys          uint64 `bson:"sys" json:"sys"`
+	HeapAlloc    uint64 `bson:"heap_alloc" json:"heap_alloc"`
+	HeapInuse    uint64 `bson:"heap_inuse" json:"heap_inuse"`
+	HeapObjects  uint64 `bson:"heap_objects" json:"heap_objects"`
+	StackInuse   uint64 `bson:"stack_inuse" json:"stack_inuse"`
+	Mallocs      uint64 `bson:"mallocs" json:"mallocs"`
+	Frees        uint64 `bson:"frees" json:"frees"`
+	NumGC        uint32 `bson:"num_gc" json:"num_gc"`
+	PauseTotalNs uint64 `bson:"pause_total_ns" json:"pause_total_ns"`
+}
+
+// BuildInfo is a struct that holds the build information of the running binary.
+type BuildInfo struct {
+	GoVersion string            `bson:"go_version" json:"go_version"`
+	Path      string            `bson:"path,omitempty" json:"path,omitempty"`
+	Version   string            `bson:"version,omitempty" json:"version,omitempty"`
+	Settings  map[string]string `bson:"settings,omitempty" json:"settings,omitempty"`
+}
+
+// Diagnostics is a struct that holds a snapshot of the process state taken right before a fatal exit.
+type Diagnostics struct {
+	Message    string                 `bson:"message" json:"message"`
+	Time       time.Time              `bson:"time" json:"time"`
+	Uptime     string                 `bson:"uptime" json:"uptime"`
+	Goroutines int                    `bson:"goroutines" json:"goroutines"`
+	Stacks     string                 `bson:"stacks" json:"stacks"`
+	Truncated  bool                   `bson:"truncated" json:"truncated"`
+	FieldsCut  bool                   `bson:"fields_truncated,omitempty" json:"fields_truncated,omitempty"`
+	MemStats   MemStats               `bson:"mem_stats" json:"mem_stats"`
+	BuildInfo  BuildInfo              `bson:"build_info" json:"build_info"`
+	Fields     map[string]interface{} `bson:"fields,omitempty" json:"fields,omitempty"`
+}
+
+// CollectDiagnostics is a function that captures the stacks of all goroutines, memory statistics, build information and uptime.
+// The captured stacks are bounded to maxSize bytes; Truncated reports whether they were cut.
+// The fields are bounded by DiagnosticsMaxFields and DiagnosticsMaxFieldSize; FieldsCut reports whether they were cut.
+func CollectDiagnostics(message string, fields map[string]interface{}, maxSize int) *Diagnostics {
+	if maxSize <= 0 {
+		maxSize = DefaultDiagnosticsSize
+	}
+
+	buf := make([]byte, maxSize)
+	n := runtime.Stack(buf, true)
+
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+
+	fields, fieldsCut := boundFields(fields)
+
+	d := &Diagnostics{
+		Message:    message,
+		Time:       time.Now(),
+		Uptime:     time.Since(processStart).String(),
+		Goroutines: runtime.NumGoroutine(),
+		Stacks:     string(buf[:n]),
+		Truncated:  n == len(buf),
+		FieldsCut:  fieldsCut,
+		MemStats: MemStats{
+			Alloc:        ms.Alloc,
+			TotalAlloc:   ms.TotalAlloc,
+			Sys:          ms.Sys,
+			HeapAlloc:    ms.HeapAlloc,
+			HeapInuse:    ms.HeapInuse,
+			HeapObjects:  ms.HeapObjects,
+			StackInuse:   ms.StackInuse,
+			Mallocs:      ms.Mallocs,
+			Frees:        ms.Frees,
+			NumGC:        ms.NumGC,
+			PauseTotalNs: ms.PauseTotalNs,
+		},
+		BuildInfo: BuildInfo{GoVersion: runtime.Version()},
+		Fields:    fields,
+	}
+
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		d.BuildInfo.Path = bi.Path
+		d.BuildInfo.Version = bi.Main.Version
+		d.BuildInfo.Settings = make(map[string]string, len(bi.Settings))
+		for _, s := range bi.Settings {
+			d.BuildInfo.Settings[s.Key] = s.Value
+		}
+	}
+
+	return d
+}
+
+// boundFields is a function that returns a copy of fields holding at most DiagnosticsMaxFields of them, with values
+// other than numbers, booleans and times written as text cut to DiagnosticsMaxFieldSize bytes.
+func boundFields(fields map[string]interface{}) (map[string]interface{}, bool) {
+	if len(fields) == 0 {
+		return nil, false
+	}
+
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	cut := false
+	if len(keys) > DiagnosticsMaxFields {
+		keys, cut = keys[:DiagnosticsMaxFields], true
+	}
+
+	bounded := make(map[string]interface{}, len(keys))
+	for _, k := range keys {
+		var s string
+		switch v := fields[k].(type) {
+		case nil, bool, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64, time.Time:
+			bounded[k] = v
+			continue
+		case string:
+			s = v
+		case error:
+			s = v.Error()
+		default:
+			s = fmt.Sprintf("%+v", v)
+		}
+
+		if len(s) > DiagnosticsMaxFieldSize {
+			s, cut = strings.ToValidUTF8(s[:DiagnosticsMaxFieldSize], ""), true
+		}
+		bounded[k] = s
+	}
+
+	return bounded, cut
+}
+
+// diagnostics is a struct that holds the configuration of the fatal diagnostics dump.
+type diagnostics struct {
+	maxSize int
+	out     io.Writer
+	writers []DiagnosticsWriter
+}
+
+// WithDiagnostics is a function that returns an OptFunc which enables a diagnostics dump before Fatal exits the process.
+// The dump is written to stderr and to each of the provided writers, with goroutine stacks bounded to maxSize bytes.
+func WithDiagnostics(maxSize int, writers ...DiagnosticsWriter) OptFunc {
+	return func(l *CMD) (err error) {
+		l.diag = &diagnostics{maxSize: maxSize, out: os.Stderr, writers: writers}
+		return
+	}
+}
+
+// dump is a method that collects a Diagnostics and writes it synchronously to every destination.
+func (d *diagnostics) dump(message string, fields map[string]interface{}) {
+	doc := CollectDiagnostics(message, fields, d.maxSize)
+
+	if b, err := json.Marshal(doc); err == nil {
+		_, _ = fmt.Fprintf(d.out, "%s\n", b)
+	} else {
+		_, _ = fmt.Fprintf(d.out, "%s\n%s\n", message, doc.Stacks)
+	}
+
+	for _, w := range d.writers {
+		if err := w.WriteDiagnostics(doc); err != nil {
+			_, _ = fmt.Fprintf(d.out, "fail to write diagnostics: %v\n", err)
+		}
+	}
+}
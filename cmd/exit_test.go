@@ -0,0 +1,59 @@
+package cmd_test
+
+import (
+	"testing"
+
+	"github.com/dyaksa/telemetry-log/cmd"
+	"github.com/dyaksa/telemetry-log/telemetry/log"
+)
+
+func TestFatalRunsExitHandlers(t *testing.T) {
+	var code int
+	var flushed bool
+
+	l, err := cmd.New(
+		cmd.WithExitHandler(func() { flushed = true }),
+		cmd.WithExitFunc(func(c int) { code = c }),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l.Fatal("bye")
+
+	if !flushed || code != 1 {
+		t.Fatalf("got flushed=%v code=%d, want flushed=true code=1", flushed, code)
+	}
+}
+
+func TestPanicOnlyFlushes(t *testing.T) {
+	var flushed, closed bool
+	hook := &countHook{}
+
+	l, err := cmd.New(
+		cmd.WithHook(hook),
+		cmd.WithFlushHandler(func() { flushed = true }),
+		cmd.WithExitHandler(func() { closed = true }),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("Panic did not panic")
+			}
+		}()
+		l.(log.PanicLogger).Panic("bailing out")
+	}()
+
+	if !flushed || closed {
+		t.Fatalf("got flushed=%v closed=%v, want flushed=true closed=false", flushed, closed)
+	}
+
+	l.Error("still logging")
+	if got := hook.count(); got != 2 {
+		t.Fatalf("got %d entries, want 2", got)
+	}
+}
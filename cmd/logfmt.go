@@ -0,0 +1,233 @@
+// Package cmd provides functionality for command line operations.
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/dyaksa/telemetry-log/err"
+	"github.com/sirupsen/logrus"
+)
+
+// LogfmtFormatter is a function that returns an OptFunc which sets the logfmt formatter for a CMD instance,
+// as expected by Loki and other logfmt pipelines.
+func LogfmtFormatter() OptFunc {
+	return func(l *CMD) (err error) {
+		l.lg.SetFormatter(&Logfmt{})
+		return
+	}
+}
+
+// Logfmt is a struct that formats entries as logfmt key=value pairs on one line.
+// The time, level, msg and caller keys come first, then the fields sorted by key. Nested maps and structs are
+// flattened with dotted keys, and the error trace is written as func@file:line frames separated by commas.
+// A field whose key is taken, by one of the first four keys or by another field once flattened, is written with
+// the "fields." prefix as logrus does, so that no key appears twice on a line.
+type Logfmt struct {
+	TimestampFormat string // TimestampFormat is the layout of the time, time.RFC3339Nano when empty.
+}
+
+// Format is a method that renders an entry as a logfmt line.
+func (f *Logfmt) Format(e *logrus.Entry) ([]byte, error) {
+	layout := f.TimestampFormat
+	if layout == "" {
+		layout = time.RFC3339Nano
+	}
+
+	b := &bytes.Buffer{}
+	writePair(b, "time", e.Time.Format(layout))
+	writePair(b, "level", e.Level.String())
+	writePair(b, "msg", e.Message)
+	if caller := callerOf(e.Data); caller != "" {
+		writePair(b, "caller", caller)
+	}
+
+	// The keys written first are held in the map while the fields are added, so that clashing fields are renamed.
+	fields := map[string]string{}
+	for _, k := range logfmtReserved {
+		fields[k] = ""
+	}
+
+	for _, k := range sortedKeys(e.Data) {
+		switch k {
+		case "file", "line":
+			continue
+		case "trace":
+			put(fields, k, compactTrace(e.Data[k]))
+			continue
+		}
+		flatten(fields, k, e.Data[k])
+	}
+
+	for _, k := range logfmtReserved {
+		delete(fields, k)
+	}
+
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		writePair(b, k, fields[k])
+	}
+
+	b.WriteByte('\n')
+	return b.Bytes(), nil
+}
+
+// logfmtReserved are the keys written before the fields.
+var logfmtReserved = []string{"time", "level", "msg", "caller"}
+
+// flatten is a function that adds a field to out, nested maps and structs giving one key per leaf, joined with dots.
+// Nested keys are added in order, so the key a clash is resolved to does not depend on the map iteration.
+func flatten(out map[string]string, key string, v interface{}) {
+	switch val := v.(type) {
+	case nil:
+		put(out, key, "")
+		return
+	case string:
+		put(out, key, val)
+		return
+	case error:
+		put(out, key, val.Error())
+		return
+	case time.Time:
+		put(out, key, val.Format(time.RFC3339Nano))
+		return
+	case fmt.Stringer:
+		put(out, key, val.String())
+		return
+	case map[string]interface{}:
+		if len(val) == 0 {
+			put(out, key, "{}")
+		}
+		for _, k := range sortedKeys(val) {
+			flatten(out, key+"."+k, val[k])
+		}
+		return
+	}
+
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Pointer && !rv.IsNil() {
+		rv = rv.Elem()
+	}
+
+	switch rv.Kind() {
+	case reflect.Map, reflect.Struct:
+		// Other maps and structs are flattened through their JSON form, which honours their tags.
+		if b, jsonErr := json.Marshal(v); jsonErr == nil {
+			var generic interface{}
+			dec := json.NewDecoder(bytes.NewReader(b))
+			dec.UseNumber()
+			if dec.Decode(&generic) == nil {
+				if m, ok := generic.(map[string]interface{}); ok {
+					flatten(out, key, m)
+					return
+				}
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		if b, jsonErr := json.Marshal(v); jsonErr == nil {
+			put(out, key, string(b))
+			return
+		}
+	}
+
+	put(out, key, fmt.Sprint(v))
+}
+
+// put is a function that adds a value to out under key, or under the "fields." prefixed key when key is taken,
+// numbered when that one is taken too.
+func put(out map[string]string, key, value string) {
+	if _, taken := out[key]; !taken {
+		out[key] = value
+		return
+	}
+
+	prefixed := "fields." + key
+	for i := 2; ; i++ {
+		if _, taken := out[prefixed]; !taken {
+			out[prefixed] = value
+			return
+		}
+		prefixed = "fields." + key + "." + strconv.Itoa(i)
+	}
+}
+
+// sortedKeys is a function that returns the keys of m in order.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// compactTrace is a function that returns a trace as func@file:line frames separated by commas.
+func compactTrace(trace interface{}) string {
+	frames, ok := trace.([]err.Stack)
+	if !ok {
+		out := map[string]string{}
+		flatten(out, "trace", trace)
+		return out["trace"]
+	}
+
+	parts := make([]string, len(frames))
+	for i, s := range frames {
+		parts[i] = s.Name + "@" + s.File + ":" + s.Line
+	}
+
+	return strings.Join(parts, ",")
+}
+
+// writePair is a function that writes a key=value pair, preceded by a space unless it is the first one.
+func writePair(b *bytes.Buffer, key, value string) {
+	if b.Len() > 0 {
+		b.WriteByte(' ')
+	}
+
+	b.WriteString(logfmtKey(key))
+	b.WriteByte('=')
+	b.WriteString(logfmtValue(value))
+}
+
+// logfmtKey is a function that replaces the characters a logfmt key cannot hold with underscores.
+func logfmtKey(k string) string {
+	if k == "" {
+		return "_"
+	}
+
+	return strings.Map(func(r rune) rune {
+		if r <= ' ' || r == '=' || r == '"' || r == unicode.ReplacementChar || !unicode.IsPrint(r) {
+			return '_'
+		}
+		return r
+	}, k)
+}
+
+// logfmtValue is a function that quotes a value when it is empty or holds spaces, quotes, equal signs or
+// non-printable characters, escaping them as Go strings do.
+func logfmtValue(v string) string {
+	if v == "" {
+		return `""`
+	}
+
+	for _, r := range v {
+		if r <= ' ' || r == '=' || r == '"' || r == '\\' || !unicode.IsPrint(r) {
+			return strconv.Quote(v)
+		}
+	}
+
+	return v
+}
@@ -0,0 +1,305 @@
+// Package cmd provides functionality for command line operations.
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultDropSummaryInterval is the default interval between two summaries of dropped entries.
+const DefaultDropSummaryInterval = time.Minute
+
+// MaxSamplingKeys is the number of distinct message and caller keys a sampled level counts within an interval.
+// Beyond it, entries with new keys share a single counter, so that dynamic messages cannot grow the sampler unbounded.
+const MaxSamplingKeys = 10000
+
+// overflowKey is the key shared by the entries sampled once MaxSamplingKeys is reached.
+const overflowKey = "\x00overflow"
+
+// summaryKey is the context key marking the drop summary entry, which is exempt from rate limiting.
+type summaryKey struct{}
+
+// WithSampling is a function that returns an OptFunc which samples the entries logged at the given level.
+// Within every interval the first entries with the same message and caller are logged, then only every
+// thereafter-th one; the others are dropped and reported by the drop summary.
+func WithSampling(lvl Level, first, thereafter int, interval time.Duration) OptFunc {
+	return func(l *CMD) (err error) {
+		if lvl < LevelDebug || lvl > LevelError {
+			return fmt.Errorf("invalid sampling level: %d", lvl)
+		}
+
+		if first < 0 || thereafter < 0 || interval <= 0 {
+			return errors.New("invalid sampling settings")
+		}
+
+		l.drops.samplers[lvl] = &sampler{
+			first:      uint64(first),
+			thereafter: uint64(thereafter),
+			interval:   interval,
+			counts:     map[string]uint64{},
+		}
+		return
+	}
+}
+
+// WithRateLimitedHook is a function that returns an OptFunc which adds a hook guarded by a token-bucket limiter.
+// The hook receives at most perSecond entries per second with bursts of up to burst entries; the others
+// are dropped and reported by the drop summary under the given name.
+func WithRateLimitedHook(name string, hook logrus.Hook, perSecond float64, burst int) OptFunc {
+	return func(l *CMD) (err error) {
+		if perSecond <= 0 || burst <= 0 {
+			return errors.New("invalid rate limit settings")
+		}
+
+		rl := NewRateLimitHook(name, hook, perSecond, burst)
+		rl.drops = l.drops
+		l.drops.limiters = append(l.drops.limiters, rl)
+		l.lg.AddHook(rl)
+		return
+	}
+}
+
+// WithDropSummary is a function that returns an OptFunc which sets the interval between two summaries of dropped entries.
+func WithDropSummary(interval time.Duration) OptFunc {
+	return func(l *CMD) (err error) {
+		if interval <= 0 {
+			return fmt.Errorf("invalid drop summary interval: %s", interval)
+		}
+
+		l.drops.interval = interval
+		return
+	}
+}
+
+// WithDropObserver is a function that returns an OptFunc which sets a function called for every dropped entry,
+// with the reason, "sampled" or "rate_limited", and the level or the hook name it was dropped for.
+func WithDropObserver(fn func(reason, source string)) OptFunc {
+	return func(l *CMD) (err error) {
+		l.drops.observer = fn
+		return
+	}
+}
+
+// sampler is a struct that holds the per-key counters of a sampled level.
+type sampler struct {
+	first      uint64
+	thereafter uint64
+	interval   time.Duration
+
+	mu      sync.Mutex
+	resetAt time.Time
+	counts  map[string]uint64
+	dropped uint64
+}
+
+// sample is a method that reports whether the entry with the given key must be logged.
+func (s *sampler) sample(key string, now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now.After(s.resetAt) {
+		s.counts = map[string]uint64{}
+		s.resetAt = now.Add(s.interval)
+	}
+
+	if _, ok := s.counts[key]; !ok && len(s.counts) >= MaxSamplingKeys {
+		key = overflowKey
+	}
+
+	s.counts[key]++
+	n := s.counts[key]
+
+	if n <= s.first || (s.thereafter > 0 && (n-s.first)%s.thereafter == 0) {
+		return true
+	}
+
+	s.dropped++
+	return false
+}
+
+// takeDropped is a method that returns the number of dropped entries and resets it.
+func (s *sampler) takeDropped() (n uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n, s.dropped = s.dropped, 0
+	return
+}
+
+// RateLimitHook is a struct that wraps a logrus.Hook with a token-bucket limiter.
+type RateLimitHook struct {
+	name string
+	hook logrus.Hook
+
+	mu      sync.Mutex
+	rate    float64
+	burst   float64
+	tokens  float64
+	last    time.Time
+	dropped atomic.Uint64
+	drops   *dropSummary
+}
+
+// NewRateLimitHook is a function that creates a new RateLimitHook letting perSecond entries per second through to hook.
+func NewRateLimitHook(name string, hook logrus.Hook, perSecond float64, burst int) *RateLimitHook {
+	return &RateLimitHook{
+		name:   name,
+		hook:   hook,
+		rate:   perSecond,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// Name is a method that returns the name of the limited sink.
+func (r *RateLimitHook) Name() string {
+	return r.name
+}
+
+// Unwrap is a method that returns the limited hook.
+func (r *RateLimitHook) Unwrap() logrus.Hook {
+	return r.hook
+}
+
+// Dropped is a method that returns the number of entries dropped since the last call and resets it.
+func (r *RateLimitHook) Dropped() uint64 {
+	return r.dropped.Swap(0)
+}
+
+// Levels is a method that returns the levels of the limited hook.
+func (r *RateLimitHook) Levels() []logrus.Level {
+	return r.hook.Levels()
+}
+
+// Fire is a method that forwards the entry to the limited hook when a token is available.
+// The drop summary is always forwarded, as it is the only trace of the entries dropped.
+func (r *RateLimitHook) Fire(e *logrus.Entry) error {
+	if e.Context != nil && e.Context.Value(summaryKey{}) != nil {
+		return r.hook.Fire(e)
+	}
+
+	if !r.allow(time.Now()) {
+		r.dropped.Add(1)
+		if r.drops != nil {
+			r.drops.drop("rate_limited", r.name)
+		}
+		return nil
+	}
+
+	return r.hook.Fire(e)
+}
+
+// allow is a method that takes a token from the bucket, refilling it for the time elapsed since the last call.
+func (r *RateLimitHook) allow(now time.Time) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.tokens += now.Sub(r.last).Seconds() * r.rate
+	if r.tokens > r.burst {
+		r.tokens = r.burst
+	}
+	r.last = now
+
+	if r.tokens < 1 {
+		return false
+	}
+
+	r.tokens--
+	return true
+}
+
+// dropSummary is a struct that holds the sources of dropped entries and reports them periodically.
+type dropSummary struct {
+	interval time.Duration
+	samplers map[Level]*sampler
+	limiters []*RateLimitHook
+	observer func(reason, source string)
+	lg       *logrus.Logger
+	lvl      Level
+	pending  atomic.Bool
+}
+
+// newDropSummary is a function that creates a dropSummary with the default interval, logging to lg.
+func newDropSummary(lg *logrus.Logger) *dropSummary {
+	return &dropSummary{interval: DefaultDropSummaryInterval, samplers: map[Level]*sampler{}, lg: lg}
+}
+
+// enabled is a method that reports whether any source of dropped entries is configured.
+func (d *dropSummary) enabled() bool {
+	return len(d.samplers) > 0 || len(d.limiters) > 0
+}
+
+// drop is a method that reports a dropped entry to the observer, if any, and schedules a summary when none is.
+func (d *dropSummary) drop(reason, source string) {
+	if d.observer != nil {
+		d.observer(reason, source)
+	}
+
+	if d.pending.CompareAndSwap(false, true) {
+		time.AfterFunc(d.interval, d.report)
+	}
+}
+
+// report is a method that logs a summary of the entries dropped since the last one, at warn level.
+// It is scheduled by the first drop after a summary, so nothing runs while no entry is dropped.
+func (d *dropSummary) report() {
+	d.pending.Store(false)
+
+	fields := logrus.Fields{}
+	var total uint64
+
+	for lvl, s := range d.samplers {
+		if n := s.takeDropped(); n > 0 {
+			fields["sampled_"+lvl.String()] = n
+			total += n
+		}
+	}
+
+	for _, r := range d.limiters {
+		if n := r.Dropped(); n > 0 {
+			fields["rate_limited_"+r.name] = n
+			total += n
+		}
+	}
+
+	if total == 0 || d.lvl > LevelWarn {
+		return
+	}
+
+	fields["dropped"] = total
+	d.lg.WithContext(context.WithValue(context.Background(), summaryKey{}, true)).WithFields(fields).Warn("log entries dropped")
+}
+
+// sampled is a method that reports whether an entry logged at lvl must be kept.
+// Entries are keyed by message and caller.
+func (l CMD) sampled(lvl Level, message string) bool {
+	if !l.drops.enabled() {
+		return true
+	}
+
+	s, ok := l.drops.samplers[lvl]
+	if !ok {
+		return true
+	}
+
+	key := message
+	if _, file, line, ok := runtime.Caller(2); ok {
+		key += "@" + file + ":" + strconv.Itoa(line)
+	}
+
+	if !s.sample(key, time.Now()) {
+		l.drops.drop("sampled", lvl.String())
+		return false
+	}
+
+	return true
+}
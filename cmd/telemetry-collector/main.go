@@ -0,0 +1,190 @@
+// Command telemetry-collector receives log entries over HTTP and a socket and stores them in MongoDB,
+// so that services can ship their logs without holding the Mongo credentials.
+//
+// Usage:
+//
+//	telemetry-collector [flags]
+//
+// Connection settings, database and collection names are read from the same TELEMETRY_* environment variables,
+// or .env file, as telemetry.New. The flags default to the TELEMETRY_COLLECTOR_* variables listed in -h.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/dyaksa/telemetry-log/cmd"
+	"github.com/dyaksa/telemetry-log/telemetry"
+	"github.com/dyaksa/telemetry-log/telemetry/collector"
+	"github.com/dyaksa/telemetry-log/telemetry/log"
+)
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := run(ctx, os.Args[1:]); err != nil {
+		if !errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintf(os.Stderr, "telemetry-collector: %v\n", err)
+		}
+		os.Exit(1)
+	}
+}
+
+// run is a function that starts the collector and serves until ctx is done.
+func run(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("telemetry-collector", flag.ContinueOnError)
+	httpAddr := fs.String("http", env("TELEMETRY_COLLECTOR_HTTP", ":4380"), "HTTP listen address, empty to disable (TELEMETRY_COLLECTOR_HTTP)")
+	socket := fs.String("socket", env("TELEMETRY_COLLECTOR_SOCKET", "unix:///tmp/telemetry-collector.sock"), "socket listen address, tcp://host:port or unix:///path, empty to disable (TELEMETRY_COLLECTOR_SOCKET)")
+	keys := fs.String("api-keys", env("TELEMETRY_COLLECTOR_API_KEYS", ""), "comma-separated API keys, each optionally key:service (TELEMETRY_COLLECTOR_API_KEYS)")
+	queue := fs.Int("queue", envInt("TELEMETRY_COLLECTOR_QUEUE", collector.DefaultQueueSize), "requests and frames waiting to be written (TELEMETRY_COLLECTOR_QUEUE)")
+	batch := fs.Int("batch", envInt("TELEMETRY_COLLECTOR_BATCH", collector.DefaultBatchSize), "entries written at once (TELEMETRY_COLLECTOR_BATCH)")
+	interval := fs.Duration("flush-interval", collector.DefaultFlushInterval, "interval after which a smaller batch is written")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *httpAddr == "" && *socket == "" {
+		return errors.New("neither -http nor -socket is set")
+	}
+
+	logger, err := cmd.New(cmd.WithLogLevel(env("TELEMETRY_LOG_LEVEL", "info")))
+	if err != nil {
+		return fmt.Errorf("fail to init logger: %w", err)
+	}
+
+	li, err := telemetry.Connect(telemetry.WithMetrics(nil))
+	if err != nil {
+		return fmt.Errorf("fail to connect: %w", err)
+	}
+	defer func() { _ = li.Mongo().Close(context.Background()) }()
+
+	opts := []collector.OptFunc{
+		collector.WithQueueSize(*queue),
+		collector.WithBatchSize(*batch, *interval),
+		collector.WithReadyCheck(li.Mongo().Ping),
+		collector.WithLogger(logger),
+		collector.WithMetrics(nil),
+	}
+	for _, k := range strings.Split(*keys, ",") {
+		if k = strings.TrimSpace(k); k != "" {
+			key, service, _ := strings.Cut(k, ":")
+			opts = append(opts, collector.WithAPIKey(key, service))
+		}
+	}
+	if *keys == "" {
+		logger.Warn("no api key is configured, every client is accepted")
+	}
+
+	s, err := collector.New(li.MongoHook(), opts...)
+	if err != nil {
+		return fmt.Errorf("fail to init collector: %w", err)
+	}
+
+	runCtx, stopRun := context.WithCancel(context.Background())
+	ran := make(chan struct{})
+	go func() {
+		defer close(ran)
+		s.Run(runCtx)
+	}()
+
+	errc := make(chan error, 2)
+	listeners := 0
+
+	var srv *http.Server
+	if *httpAddr != "" {
+		srv = &http.Server{Addr: *httpAddr, Handler: s.Handler(), ReadHeaderTimeout: 10 * time.Second}
+		listeners++
+		go func() {
+			if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+				errc <- fmt.Errorf("fail to serve http: %w", err)
+				return
+			}
+			errc <- nil
+		}()
+		logger.Info("listening for http", log.String("addr", *httpAddr))
+	}
+
+	sockCtx, stopSock := context.WithCancel(context.Background())
+	defer stopSock()
+	if *socket != "" {
+		l, err := listen(*socket)
+		if err != nil {
+			stopRun()
+			return err
+		}
+		listeners++
+		go func() { errc <- s.Serve(sockCtx, l) }()
+		logger.Info("listening for socket clients", log.String("addr", *socket))
+	}
+
+	select {
+	case <-ctx.Done():
+	case err = <-errc:
+		listeners--
+	}
+
+	shutdown, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	if srv != nil {
+		_ = srv.Shutdown(shutdown)
+	}
+	stopSock()
+	for ; listeners > 0; listeners-- {
+		err = errors.Join(err, <-errc)
+	}
+
+	stopRun()
+	<-ran
+	logger.Info("collector stopped")
+
+	return err
+}
+
+// listen is a function that opens the socket listener of an address, tcp://host:port or unix:///path.
+func listen(addr string) (net.Listener, error) {
+	network, address, ok := strings.Cut(addr, "://")
+	if !ok || (network != "tcp" && network != "unix") {
+		return nil, fmt.Errorf("invalid socket address %q, expected tcp://host:port or unix:///path", addr)
+	}
+
+	if network == "unix" {
+		_ = os.Remove(address)
+	}
+
+	l, err := net.Listen(network, address)
+	if err != nil {
+		return nil, fmt.Errorf("fail to listen on %s: %w", addr, err)
+	}
+
+	return l, nil
+}
+
+// env is a function that returns the value of an environment variable, or def when it is unset.
+func env(key, def string) string {
+	if v, ok := os.LookupEnv(key); ok {
+		return v
+	}
+
+	return def
+}
+
+// envInt is a function that returns the integer value of an environment variable, or def when it is unset or invalid.
+func envInt(key string, def int) int {
+	if n, err := strconv.Atoi(os.Getenv(key)); err == nil {
+		return n
+	}
+
+	return def
+}
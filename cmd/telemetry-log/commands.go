@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/dyaksa/telemetry-log/telemetry/query"
+)
+
+// runTail is a function that prints new entries as they are stored, until interrupted.
+// It follows a change stream when the server supports it and polls the collection otherwise.
+func runTail(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("tail", flag.ContinueOnError)
+	var ff filterFlags
+	ff.register(fs, "")
+	interval := fs.Duration("interval", 2*time.Second, "polling interval when change streams are unavailable")
+	resume := fs.String("resume-file", "", "file keeping the position, to resume after a restart")
+	noColor := fs.Bool("no-color", false, "disable colors")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	f, err := ff.filter(time.Now())
+	if err != nil {
+		return err
+	}
+
+	c, closeFn, err := ff.client(ctx)
+	if err != nil {
+		return err
+	}
+	defer closeFn()
+
+	wo := query.WatchOptions{PollInterval: *interval, Buffer: 64}
+	if *resume != "" {
+		wo.Tokens = &query.FileTokenStore{Path: *resume}
+	}
+
+	p := newPrinter(*noColor)
+	entries, errs := c.Watch(ctx, f, wo)
+	for e := range entries {
+		p.print(e)
+	}
+
+	err = <-errs
+	if errors.Is(err, query.ErrHistoryLost) && *resume != "" {
+		return fmt.Errorf("%w; remove %s to watch from now on", err, *resume)
+	}
+	return err
+}
+
+// runSearch is a function that prints the entries matching the flags.
+func runSearch(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("search", flag.ContinueOnError)
+	var ff filterFlags
+	ff.register(fs, "1h")
+	limit := fs.Int("limit", 100, "maximum number of entries")
+	asc := fs.Bool("asc", false, "oldest entries first")
+	asJSON := fs.Bool("json", false, "print entries as NDJSON")
+	noColor := fs.Bool("no-color", false, "disable colors")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	f, err := ff.filter(time.Now())
+	if err != nil {
+		return err
+	}
+
+	c, closeFn, err := ff.client(ctx)
+	if err != nil {
+		return err
+	}
+	defer closeFn()
+
+	order := query.Descending
+	if *asc {
+		order = query.Ascending
+	}
+
+	page, err := c.Find(ctx, f, query.FindOptions{Limit: *limit, Order: order})
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		w, _ := newEntryWriter("ndjson", os.Stdout)
+		for _, e := range page.Entries {
+			if err = w.write(e); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	p := newPrinter(*noColor)
+	for _, e := range page.Entries {
+		p.print(e)
+	}
+
+	if page.Next != "" {
+		fmt.Fprintf(os.Stderr, "more than %d entries, narrow the search or raise -limit\n", *limit)
+	}
+	return nil
+}
+
+// runExport is a function that writes every entry matching the flags to a file or stdout.
+func runExport(ctx context.Context, args []string) (err error) {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	var ff filterFlags
+	ff.register(fs, "24h")
+	format := fs.String("format", "ndjson", "output format: ndjson or csv")
+	out := fs.String("out", "", "output file, stdout when empty")
+	if err = fs.Parse(args); err != nil {
+		return err
+	}
+
+	f, err := ff.filter(time.Now())
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		var file *os.File
+		file, err = os.Create(*out)
+		if err != nil {
+			return fmt.Errorf("fail to create output file: %w", err)
+		}
+		defer func() { err = errors.Join(err, file.Close()) }()
+		w = file
+	}
+
+	ew, err := newEntryWriter(*format, w)
+	if err != nil {
+		return err
+	}
+
+	c, closeFn, err := ff.client(ctx)
+	if err != nil {
+		return err
+	}
+	defer closeFn()
+
+	var n int
+	fo := query.FindOptions{Limit: 1000, Order: query.Ascending}
+	for {
+		page, err := c.Find(ctx, f, fo)
+		if err != nil {
+			return err
+		}
+
+		for _, e := range page.Entries {
+			if err = ew.write(e); err != nil {
+				return fmt.Errorf("fail to write entry: %w", err)
+			}
+		}
+		n += len(page.Entries)
+
+		if page.Next == "" {
+			break
+		}
+		fo.Cursor = page.Next
+	}
+
+	if err = ew.flush(); err != nil {
+		return fmt.Errorf("fail to write entries: %w", err)
+	}
+
+	fmt.Fprintf(os.Stderr, "exported %d entries\n", n)
+	return nil
+}
+
+// runStats is a function that prints the number of entries by level and by func over a window.
+func runStats(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("stats", flag.ContinueOnError)
+	var ff filterFlags
+	ff.register(fs, "1h")
+	top := fs.Int("top", 10, "number of funcs listed")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	f, err := ff.filter(time.Now())
+	if err != nil {
+		return err
+	}
+
+	c, closeFn, err := ff.client(ctx)
+	if err != nil {
+		return err
+	}
+	defer closeFn()
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	defer tw.Flush()
+
+	for _, by := range []string{"level", "func"} {
+		groups, err := c.CountBy(ctx, f, by)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(tw, "%s\tcount\n", by)
+		for i, g := range groups {
+			if by == "func" && i == *top {
+				break
+			}
+			fmt.Fprintf(tw, "%v\t%d\n", g.Key, g.Count)
+		}
+		fmt.Fprintln(tw)
+	}
+
+	return nil
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dyaksa/telemetry-log/telemetry"
+	"github.com/dyaksa/telemetry-log/telemetry/query"
+)
+
+// fieldFlags is a type that collects repeated -field key=value flags.
+type fieldFlags []string
+
+// String is a method that returns the flag values joined by commas.
+func (f *fieldFlags) String() string {
+	return strings.Join(*f, ",")
+}
+
+// Set is a method that appends a key=value flag value.
+func (f *fieldFlags) Set(v string) error {
+	if !strings.Contains(v, "=") {
+		return fmt.Errorf("field %q must be key=value", v)
+	}
+
+	*f = append(*f, v)
+	return nil
+}
+
+// filterFlags is a struct that holds the flags shared by the commands selecting entries.
+type filterFlags struct {
+	since      string
+	until      string
+	levels     string
+	service    string
+	fn         string
+	file       string
+	text       string
+	requestID  string
+	traceID    string
+	fields     fieldFlags
+	trace      bool
+	collection string
+}
+
+// register is a method that declares the filter flags on fs, with since as default start of the window.
+func (ff *filterFlags) register(fs *flag.FlagSet, since string) {
+	fs.StringVar(&ff.since, "since", since, "start of the window, as a duration ago (15m) or RFC 3339 time")
+	fs.StringVar(&ff.until, "until", "", "end of the window, as a duration ago or RFC 3339 time")
+	fs.StringVar(&ff.levels, "level", "", "comma-separated levels, e.g. error,warning")
+	fs.StringVar(&ff.service, "service", "", "service name")
+	fs.StringVar(&ff.fn, "func", "", "function name, e.g. main.main")
+	fs.StringVar(&ff.file, "file", "", "file name, e.g. main.go")
+	fs.StringVar(&ff.text, "text", "", "text contained in the message")
+	fs.StringVar(&ff.requestID, "request-id", "", "request id")
+	fs.StringVar(&ff.traceID, "trace-id", "", "trace id")
+	fs.Var(&ff.fields, "field", "custom field equality key=value, repeatable")
+	fs.BoolVar(&ff.trace, "trace", false, "read only the trace collection instead of both the log and trace collections")
+	fs.StringVar(&ff.collection, "collection", "", "collection name, overriding the TELEMETRY_* settings")
+}
+
+// filter is a method that builds the query filter from the flags.
+func (ff *filterFlags) filter(now time.Time) (*query.Filter, error) {
+	f := query.NewFilter()
+
+	if ff.since != "" {
+		t, err := parseTime(ff.since, now)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -since: %w", err)
+		}
+		f.Since(t)
+	}
+
+	if ff.until != "" {
+		t, err := parseTime(ff.until, now)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -until: %w", err)
+		}
+		f.Until(t)
+	}
+
+	if ff.levels != "" {
+		f.Level(strings.Split(ff.levels, ",")...)
+	}
+	if ff.service != "" {
+		f.Service(ff.service)
+	}
+	if ff.fn != "" {
+		f.Func(ff.fn)
+	}
+	if ff.file != "" {
+		f.File(ff.file)
+	}
+	if ff.text != "" {
+		f.Text(ff.text)
+	}
+	if ff.requestID != "" {
+		f.RequestID(ff.requestID)
+	}
+	if ff.traceID != "" {
+		f.TraceID(ff.traceID)
+	}
+
+	for _, kv := range ff.fields {
+		k, v, _ := strings.Cut(kv, "=")
+		f.Field(k, parseValue(v))
+	}
+
+	return f, nil
+}
+
+// client is a method that connects to MongoDB and returns a query client on the selected collections.
+// Both the log and trace collections are read by default, since entries at error level and above are stored
+// in the trace collection.
+func (ff *filterFlags) client(ctx context.Context) (*query.Client, func(), error) {
+	li, err := telemetry.Connect()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	database, trace, log := li.ResolveNames("", time.Now())
+
+	collections := []string{log, trace}
+	if ff.trace {
+		collections = []string{trace}
+	}
+	if ff.collection != "" {
+		collections = []string{ff.collection}
+	}
+
+	c, err := query.New(li.Mongo(), query.WithDatabase(database), query.WithCollections(collections...))
+	if err != nil {
+		_ = li.Mongo().Close(ctx)
+		return nil, nil, err
+	}
+
+	return c, func() { _ = li.Mongo().Close(context.Background()) }, nil
+}
+
+// parseTime is a function that parses a duration ago or an RFC 3339 time.
+func parseTime(s string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+
+	return time.Parse(time.RFC3339, s)
+}
+
+// parseValue is a function that returns v as a number or boolean when it is one, so it matches stored fields.
+func parseValue(v string) interface{} {
+	if i, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return i
+	}
+	if f, err := strconv.ParseFloat(v, 64); err == nil {
+		return f
+	}
+	if b, err := strconv.ParseBool(v); err == nil {
+		return b
+	}
+
+	return v
+}
@@ -0,0 +1,75 @@
+// Command telemetry-log tails, searches, exports and summarizes the entries stored by the telemetry hook.
+//
+// Usage:
+//
+//	telemetry-log <command> [flags]
+//
+// The commands are tail, search, export and stats. Connection settings are read from the same
+// TELEMETRY_* environment variables, or .env file, as telemetry.New.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// command is a struct that holds a subcommand of the tool.
+type command struct {
+	name  string
+	usage string
+	run   func(ctx context.Context, args []string) error
+}
+
+// commands are the subcommands of the tool.
+var commands = []command{
+	{name: "tail", usage: "follow new entries", run: runTail},
+	{name: "search", usage: "query entries by time, level and fields", run: runSearch},
+	{name: "export", usage: "write matching entries as NDJSON or CSV", run: runExport},
+	{name: "stats", usage: "count entries by level and func over a window", run: runStats},
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := run(ctx, os.Args[1:]); err != nil {
+		if !errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintf(os.Stderr, "telemetry-log: %v\n", err)
+		}
+		os.Exit(1)
+	}
+}
+
+// run is a function that dispatches the arguments to the matching subcommand.
+func run(ctx context.Context, args []string) error {
+	if len(args) == 0 || args[0] == "-h" || args[0] == "--help" || args[0] == "help" {
+		usage()
+		return flag.ErrHelp
+	}
+
+	for _, c := range commands {
+		if c.name == args[0] {
+			return c.run(ctx, args[1:])
+		}
+	}
+
+	usage()
+	return fmt.Errorf("unknown command %q", args[0])
+}
+
+// usage is a function that prints the list of subcommands.
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: telemetry-log <command> [flags]")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Commands:")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-8s %s\n", c.name, c.usage)
+	}
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Run 'telemetry-log <command> -h' for the flags of a command.")
+}
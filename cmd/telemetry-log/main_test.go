@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"testing"
+	"time"
+
+	"github.com/dyaksa/telemetry-log/err"
+	"github.com/dyaksa/telemetry-log/telemetry/query"
+)
+
+func TestParseTime(t *testing.T) {
+	now := time.Date(2024, 6, 14, 12, 0, 0, 0, time.UTC)
+
+	cases := []struct {
+		in   string
+		want time.Time
+	}{
+		{"90m", now.Add(-90 * time.Minute)},
+		{"2024-06-13T08:30:00Z", time.Date(2024, 6, 13, 8, 30, 0, 0, time.UTC)},
+	}
+
+	for _, c := range cases {
+		got, err := parseTime(c.in, now)
+		if err != nil {
+			t.Fatalf("parseTime(%q): %v", c.in, err)
+		}
+		if !got.Equal(c.want) {
+			t.Errorf("parseTime(%q) = %v, want %v", c.in, got, c.want)
+		}
+	}
+
+	if _, err := parseTime("yesterday", now); err == nil {
+		t.Error("parseTime accepted an invalid time")
+	}
+}
+
+func TestParseValue(t *testing.T) {
+	cases := []struct {
+		in   string
+		want interface{}
+	}{
+		{"42", int64(42)},
+		{"-7", int64(-7)},
+		{"1.5", 1.5},
+		{"true", true},
+		{"abc", "abc"},
+		{"", ""},
+	}
+
+	for _, c := range cases {
+		if got := parseValue(c.in); got != c.want {
+			t.Errorf("parseValue(%q) = %#v, want %#v", c.in, got, c.want)
+		}
+	}
+}
+
+func TestCSVWriter(t *testing.T) {
+	b := &bytes.Buffer{}
+	w, e := newEntryWriter("csv", b)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	entries := []query.Entry{
+		{
+			Level:   "error",
+			Time:    time.Date(2024, 6, 14, 12, 0, 0, 0, time.FixedZone("WIB", 7*3600)),
+			Message: "fail to pay, \"card\" declined",
+			Service: "billing",
+			Fields:  map[string]interface{}{"user_id": 42},
+			Trace:   []err.Stack{{Name: "main.pay", File: "pay.go", Line: "12"}},
+		},
+		{Level: "info", Time: time.Date(2024, 6, 14, 12, 0, 1, 0, time.UTC), Message: "paid"},
+	}
+	for _, entry := range entries {
+		if e = w.write(entry); e != nil {
+			t.Fatal(e)
+		}
+	}
+	if e = w.flush(); e != nil {
+		t.Fatal(e)
+	}
+
+	rows, e := csv.NewReader(b).ReadAll()
+	if e != nil {
+		t.Fatal(e)
+	}
+	if len(rows) != 3 {
+		t.Fatalf("got %d rows, want a header and 2 entries", len(rows))
+	}
+	if rows[0][0] != "time" || len(rows[0]) != len(csvHeader) {
+		t.Errorf("header = %v", rows[0])
+	}
+
+	got := rows[1]
+	if got[0] != "2024-06-14T05:00:00Z" {
+		t.Errorf("time = %q, want UTC", got[0])
+	}
+	if got[4] != entries[0].Message {
+		t.Errorf("message = %q", got[4])
+	}
+	if got[9] != `{"user_id":42}` {
+		t.Errorf("fields = %q", got[9])
+	}
+	if got[10] == "" {
+		t.Error("trace column is empty")
+	}
+	if rows[2][9] != "" || rows[2][10] != "" {
+		t.Errorf("empty fields and trace = %q, %q, want empty columns", rows[2][9], rows[2][10])
+	}
+}
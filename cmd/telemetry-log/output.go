@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dyaksa/telemetry-log/telemetry/query"
+)
+
+// These constants are the ANSI escape sequences used to color the output.
+const (
+	colorReset  = "\x1b[0m"
+	colorGray   = "\x1b[90m"
+	colorRed    = "\x1b[31m"
+	colorYellow = "\x1b[33m"
+	colorBlue   = "\x1b[34m"
+	colorCyan   = "\x1b[36m"
+	colorPurple = "\x1b[35m"
+)
+
+// printer is a struct that writes entries as colored, human-readable lines.
+type printer struct {
+	w     io.Writer
+	color bool
+}
+
+// newPrinter is a function that creates a printer on stdout, colored when stdout is a terminal.
+func newPrinter(noColor bool) *printer {
+	return &printer{w: os.Stdout, color: !noColor && os.Getenv("NO_COLOR") == "" && isTerminal(os.Stdout)}
+}
+
+// isTerminal is a function that reports whether f is a character device, such as a terminal.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// paint is a method that wraps s in the given color when coloring is enabled.
+func (p *printer) paint(color, s string) string {
+	if !p.color {
+		return s
+	}
+
+	return color + s + colorReset
+}
+
+// levelColor is a function that returns the color of a level.
+func levelColor(level string) string {
+	switch level {
+	case "error", "fatal", "panic":
+		return colorRed
+	case "warning":
+		return colorYellow
+	case "info":
+		return colorBlue
+	}
+	return colorGray
+}
+
+// print is a method that writes an entry on one line, followed by its trace if any.
+func (p *printer) print(e query.Entry) {
+	var b strings.Builder
+
+	b.WriteString(p.paint(colorGray, e.Time.Local().Format(time.RFC3339)))
+	b.WriteString(" ")
+	b.WriteString(p.paint(levelColor(e.Level), fmt.Sprintf("%-7s", strings.ToUpper(e.Level))))
+	if e.Service != "" {
+		b.WriteString(" " + p.paint(colorPurple, "["+e.Service+"]"))
+	}
+	b.WriteString(" " + e.Message)
+	b.WriteString(" " + p.paint(colorCyan, e.File))
+
+	keys := make([]string, 0, len(e.Fields))
+	for k := range e.Fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		b.WriteString(" " + p.paint(colorGray, k+"=") + fmt.Sprint(e.Fields[k]))
+	}
+	if e.RequestID != "" {
+		b.WriteString(" " + p.paint(colorGray, "request_id=") + e.RequestID)
+	}
+
+	_, _ = fmt.Fprintln(p.w, b.String())
+
+	for _, s := range e.Trace {
+		_, _ = fmt.Fprintf(p.w, "    %s %s\n", s.Name, p.paint(colorGray, s.File+":"+s.Line))
+	}
+}
+
+// entryWriter is an interface that defines the methods of an export format.
+type entryWriter interface {
+	write(e query.Entry) error
+	flush() error
+}
+
+// ndjsonWriter is a struct that writes entries as newline-delimited JSON.
+type ndjsonWriter struct {
+	enc *json.Encoder
+}
+
+// write is a method that writes an entry as a JSON line.
+func (w *ndjsonWriter) write(e query.Entry) error {
+	return w.enc.Encode(e)
+}
+
+// flush is a method that does nothing, lines are written as they come.
+func (w *ndjsonWriter) flush() error {
+	return nil
+}
+
+// csvHeader are the columns of the CSV export.
+var csvHeader = []string{"time", "level", "service", "environment", "message", "func", "file", "request_id", "trace_id", "fields", "trace"}
+
+// csvWriter is a struct that writes entries as CSV rows.
+type csvWriter struct {
+	w      *csv.Writer
+	header bool
+}
+
+// write is a method that writes an entry as a CSV row, with fields and trace encoded as JSON.
+func (w *csvWriter) write(e query.Entry) error {
+	if !w.header {
+		if err := w.w.Write(csvHeader); err != nil {
+			return err
+		}
+		w.header = true
+	}
+
+	fields, trace := "", ""
+	if len(e.Fields) > 0 {
+		b, _ := json.Marshal(e.Fields)
+		fields = string(b)
+	}
+	if len(e.Trace) > 0 {
+		b, _ := json.Marshal(e.Trace)
+		trace = string(b)
+	}
+
+	return w.w.Write([]string{
+		e.Time.UTC().Format(time.RFC3339Nano), e.Level, e.Service, e.Environment, e.Message,
+		e.Func, e.File, e.RequestID, e.TraceID, fields, trace,
+	})
+}
+
+// flush is a method that flushes the buffered rows.
+func (w *csvWriter) flush() error {
+	w.w.Flush()
+	return w.w.Error()
+}
+
+// newEntryWriter is a function that returns the writer of the given format.
+func newEntryWriter(format string, out io.Writer) (entryWriter, error) {
+	switch format {
+	case "ndjson", "json":
+		return &ndjsonWriter{enc: json.NewEncoder(out)}, nil
+	case "csv":
+		return &csvWriter{w: csv.NewWriter(out)}, nil
+	}
+
+	return nil, fmt.Errorf("unknown format %q, want ndjson or csv", format)
+}
@@ -7,8 +7,8 @@ import (
 	"strconv"
 )
 
-// errStack is a struct that holds the necessary information for an error stack.
-type errStack struct {
+// Stack is a struct that holds the necessary information for an error stack.
+type Stack struct {
 	Name string `bson:"name,omitempty" json:"name"` // Name is the name of the function where the error occurred.
 	File string `bson:"file,omitempty" json:"file"` // File is the name of the file where the error occurred.
 	Line string `bson:"line,omitempty" json:"line"` // Line is the line number where the error occurred.
@@ -34,17 +34,17 @@ func callers() []uintptr {
 	return pcs[0:n]
 }
 
-// Print is a method that returns a slice of errStack.
-// It creates an errStack for each program counter in the stack trace.
-func (e *ErrorTracer) Print() []errStack {
-	var traces []errStack
+// Print is a method that returns a slice of Stack.
+// It creates a Stack for each program counter in the stack trace.
+func (e *ErrorTracer) Print() []Stack {
+	var traces []Stack
 
 	for k := range e.stackTrace {
 		v := e.stackTrace[k] - 1
 		f := runtime.FuncForPC(v)
 		file, line := f.FileLine(v)
 
-		traces = append(traces, errStack{Name: f.Name(), File: path.Base(file), Line: strconv.Itoa(line)})
+		traces = append(traces, Stack{Name: f.Name(), File: path.Base(file), Line: strconv.Itoa(line)})
 
 	}
 
@@ -0,0 +1,88 @@
+// Package telemetry provides functionality for telemetry logging.
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/dyaksa/telemetry-log/cmd"
+	"github.com/dyaksa/telemetry-log/telemetry/alert"
+)
+
+// WithAlerts is a function that returns an OptFunc which evaluates alert rules against the logged entries and
+// delivers the alerts they raise to the notifiers. Alerts still queued are delivered when the Lib shuts down.
+// Each call evaluates its rules separately, so that their alerts only go to its notifiers.
+func WithAlerts(rules []alert.Rule, notifiers ...alert.Notifier) OptFunc {
+	return func(li *Lib) (err error) {
+		if len(notifiers) == 0 {
+			return errors.New("alerts need at least one notifier")
+		}
+
+		opts := make([]alert.OptFunc, 0, len(notifiers))
+		for _, n := range notifiers {
+			opts = append(opts, alert.WithNotifier(n))
+		}
+
+		engine, err := alert.New(rules, opts...)
+		if err != nil {
+			return fmt.Errorf("fail to create alert engine: %w", err)
+		}
+
+		li.alerts = append(li.alerts, engine)
+		return
+	}
+}
+
+// WithAlertFile is a function that returns an OptFunc which reads the alert rules from a YAML or JSON file,
+// see alert.LoadFile, and delivers the alerts they raise to the notifiers.
+func WithAlertFile(path string, notifiers ...alert.Notifier) OptFunc {
+	return func(li *Lib) (err error) {
+		rules, err := alert.LoadFile(path)
+		if err != nil {
+			return fmt.Errorf("fail to load alert rules: %w", err)
+		}
+
+		return WithAlerts(rules, notifiers...)(li)
+	}
+}
+
+// WithErrorAlerts is a function that returns an OptFunc which delivers an alert for every error, fatal or panic
+// entry to the notifiers, not repeating an alert for the same function and message before cooldown has elapsed.
+func WithErrorAlerts(cooldown time.Duration, notifiers ...alert.Notifier) OptFunc {
+	return func(li *Lib) (err error) {
+		if cooldown <= 0 {
+			return fmt.Errorf("invalid alert cooldown: %s", cooldown)
+		}
+
+		return WithAlerts([]alert.Rule{alert.ErrorRule("error_entries", cooldown)}, notifiers...)(li)
+	}
+}
+
+// initAlerts is a method that adds the alert engines to the logger and delivers their alerts in the background.
+func (li *Lib) initAlerts() {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var wg sync.WaitGroup
+	for _, engine := range li.alerts {
+		li.logOpt = append(li.logOpt, cmd.WithHook(engine))
+
+		wg.Add(1)
+		go func(engine *alert.Engine) {
+			defer wg.Done()
+			engine.Run(ctx)
+		}(engine)
+	}
+
+	li.RegisterExitHandler(func(ctx context.Context) (err error) {
+		cancel()
+		wg.Wait()
+
+		for _, engine := range li.alerts {
+			err = errors.Join(err, engine.Flush(ctx))
+		}
+		return err
+	})
+}
@@ -0,0 +1,530 @@
+// Package alert provides an in-process rules engine raising alerts from log entries: threshold rules firing when
+// enough entries match within a window, absence rules firing when none did, and pattern rules firing on an entry
+// whose message or field matches a regular expression. Alerts are deduplicated by rule and group, and not raised
+// again for a group before its cooldown has elapsed. The groups of threshold and pattern rules are forgotten once no
+// entry matched them within their window and their cooldown is over.
+package alert
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dyaksa/telemetry-log/telemetry/derive"
+	"github.com/sirupsen/logrus"
+)
+
+// These constants are the kinds of rule.
+const (
+	KindThreshold = "threshold"
+	KindAbsence   = "absence"
+	KindPattern   = "pattern"
+)
+
+// These constants are the defaults of an Engine.
+const (
+	DefaultCooldown  = 5 * time.Minute
+	DefaultQueueSize = 100
+	DefaultTimeout   = 10 * time.Second
+)
+
+// checkInterval is the interval at which the absence rules are evaluated.
+const checkInterval = time.Second
+
+// Rule is a struct that describes when an alert is raised.
+//
+// An entry matches a rule when every field of Match has one of the listed values, alternatives being separated by
+// commas, and when Pattern, if set, matches its Field, the message when Field is empty. The "level" and "msg" fields
+// are the level and the message of the entry. Entries are counted separately for every value of the GroupBy fields.
+type Rule struct {
+	Name        string            `json:"name" yaml:"name"`
+	Kind        string            `json:"kind" yaml:"kind"`
+	Description string            `json:"description,omitempty" yaml:"description,omitempty"`
+	Severity    string            `json:"severity,omitempty" yaml:"severity,omitempty"`
+	Match       map[string]string `json:"match,omitempty" yaml:"match,omitempty"`
+	Pattern     string            `json:"pattern,omitempty" yaml:"pattern,omitempty"`
+	Field       string            `json:"field,omitempty" yaml:"field,omitempty"`
+	GroupBy     []string          `json:"group_by,omitempty" yaml:"group_by,omitempty"`
+	Channels    []string          `json:"channels,omitempty" yaml:"channels,omitempty"` // Channels restrict the alerts to the notifiers of those names.
+
+	// Count is the number of matching entries within Window raising a threshold alert, one for a pattern alert.
+	Count int `json:"count,omitempty" yaml:"count,omitempty"`
+	// Window is the period entries are counted over, or without a matching entry for an absence alert.
+	Window Duration `json:"window,omitempty" yaml:"window,omitempty"`
+	// Cooldown is the minimum time between two alerts of a group, Window or DefaultCooldown when zero.
+	Cooldown Duration `json:"cooldown,omitempty" yaml:"cooldown,omitempty"`
+}
+
+// Alert is a struct that holds an alert raised by a rule.
+type Alert struct {
+	Rule        string                 `json:"rule"`
+	Kind        string                 `json:"kind"`
+	Severity    string                 `json:"severity,omitempty"`
+	Description string                 `json:"description,omitempty"`
+	Channels    []string               `json:"-"`
+	Summary     string                 `json:"summary"`
+	Group       map[string]string      `json:"group,omitempty"`
+	Count       int                    `json:"count,omitempty"`      // Count is the number of matching entries within the window.
+	Suppressed  int                    `json:"suppressed,omitempty"` // Suppressed is the number of times the rule was met during the cooldown before this alert.
+	Window      Duration               `json:"window,omitempty"`
+	Time        time.Time              `json:"time"`
+	LastSeen    time.Time              `json:"last_seen,omitempty"` // LastSeen is when an entry last matched an absence rule.
+	Message     string                 `json:"message,omitempty"`   // Message is the message of the entry that raised the alert.
+	Fields      map[string]interface{} `json:"fields,omitempty"`    // Fields are the fields of the entry that raised the alert.
+}
+
+// Notifier is an interface that defines a method for delivering an alert.
+type Notifier interface {
+	Notify(ctx context.Context, a Alert) error
+}
+
+// NotifierFunc is a type that adapts a function to the Notifier interface.
+type NotifierFunc func(ctx context.Context, a Alert) error
+
+// Notify is a method that calls f.
+func (f NotifierFunc) Notify(ctx context.Context, a Alert) error {
+	return f(ctx, a)
+}
+
+// OptFunc is a type that defines a function that modifies an Engine instance.
+type OptFunc func(*Engine) error
+
+// WithNotifier is a function that returns an OptFunc which delivers the alerts to n, in addition to the other notifiers.
+func WithNotifier(n Notifier) OptFunc {
+	return func(e *Engine) (err error) {
+		if n == nil {
+			return errors.New("notifier must not be nil")
+		}
+
+		e.notifiers = append(e.notifiers, n)
+		return
+	}
+}
+
+// WithQueueSize is a function that returns an OptFunc which sets how many alerts may wait to be delivered.
+// Alerts raised while the queue is full are dropped and counted.
+func WithQueueSize(n int) OptFunc {
+	return func(e *Engine) (err error) {
+		if n <= 0 {
+			return fmt.Errorf("invalid queue size: %d", n)
+		}
+
+		e.queue = make(chan Alert, n)
+		return
+	}
+}
+
+// WithTimeout is a function that returns an OptFunc which sets how long each notifier may take to deliver an alert.
+func WithTimeout(d time.Duration) OptFunc {
+	return func(e *Engine) (err error) {
+		if d <= 0 {
+			return fmt.Errorf("invalid timeout: %s", d)
+		}
+
+		e.timeout = d
+		return
+	}
+}
+
+// Engine is a logrus hook that evaluates rules against the entries it receives and delivers the alerts they raise.
+type Engine struct {
+	rules     []*rule
+	notifiers []Notifier
+	queue     chan Alert
+	timeout   time.Duration
+	dropped   atomic.Uint64
+	now       func() time.Time
+
+	mu     sync.Mutex
+	groups map[string]*group
+}
+
+// rule is a struct that holds a Rule ready to be evaluated.
+type rule struct {
+	Rule
+	match    map[string][]string
+	pattern  *regexp.Regexp
+	window   time.Duration
+	cooldown time.Duration
+}
+
+// group is a struct that holds the state of a rule for a value of its GroupBy fields.
+type group struct {
+	rule       *rule
+	labels     map[string]string
+	times      []time.Time // times are the times of the last Count matching entries, oldest first.
+	lastSeen   time.Time
+	lastAlert  time.Time
+	suppressed int
+}
+
+// New is a function that creates an Engine evaluating rules.
+// It applies the provided options to the Engine instance, and fails when a rule is invalid.
+func New(rules []Rule, opts ...OptFunc) (*Engine, error) {
+	e := &Engine{
+		queue:   make(chan Alert, DefaultQueueSize),
+		timeout: DefaultTimeout,
+		now:     time.Now,
+		groups:  map[string]*group{},
+	}
+
+	for _, opt := range opts {
+		if err := opt(e); err != nil {
+			return nil, fmt.Errorf("fail to apply options: %w", err)
+		}
+	}
+
+	seen := map[string]bool{}
+	for _, in := range rules {
+		r, err := compile(in)
+		if err != nil {
+			return nil, err
+		}
+
+		if seen[r.Name] {
+			return nil, fmt.Errorf("duplicate rule name %q", r.Name)
+		}
+		seen[r.Name] = true
+
+		e.rules = append(e.rules, r)
+	}
+
+	start := e.now()
+	for _, r := range e.rules {
+		if r.Kind == KindAbsence && len(r.GroupBy) == 0 {
+			e.groups[r.Name] = &group{rule: r, lastSeen: start}
+		}
+	}
+
+	return e, nil
+}
+
+// compile is a function that validates a rule and applies its defaults.
+func compile(in Rule) (*rule, error) {
+	if in.Name == "" {
+		return nil, errors.New("rule name must not be empty")
+	}
+
+	r := &rule{Rule: in, match: map[string][]string{}, window: time.Duration(in.Window)}
+	for k, v := range in.Match {
+		r.match[k] = strings.Split(v, ",")
+	}
+
+	if in.Pattern != "" {
+		var err error
+		if r.pattern, err = regexp.Compile(in.Pattern); err != nil {
+			return nil, fmt.Errorf("invalid pattern of rule %s: %w", in.Name, err)
+		}
+	}
+
+	switch in.Kind {
+	case KindThreshold:
+		if in.Count <= 0 || r.window <= 0 {
+			return nil, fmt.Errorf("threshold rule %s needs a count and a window", in.Name)
+		}
+	case KindAbsence:
+		if r.window <= 0 {
+			return nil, fmt.Errorf("absence rule %s needs a window", in.Name)
+		}
+	case KindPattern:
+		if r.pattern == nil {
+			return nil, fmt.Errorf("pattern rule %s needs a pattern", in.Name)
+		}
+		if r.Count <= 0 {
+			r.Count = 1
+		}
+		if r.Count > 1 && r.window <= 0 {
+			return nil, fmt.Errorf("pattern rule %s needs a window to count entries", in.Name)
+		}
+	default:
+		return nil, fmt.Errorf("invalid kind %q of rule %s", in.Kind, in.Name)
+	}
+
+	switch {
+	case in.Cooldown < 0:
+		return nil, fmt.Errorf("invalid cooldown of rule %s: %s", in.Name, time.Duration(in.Cooldown))
+	case in.Cooldown > 0:
+		r.cooldown = time.Duration(in.Cooldown)
+	case r.window > 0:
+		r.cooldown = r.window
+	default:
+		r.cooldown = DefaultCooldown
+	}
+
+	return r, nil
+}
+
+// Fire is a method that evaluates the rules against an entry and queues the alerts it raises.
+func (e *Engine) Fire(entry *logrus.Entry) error {
+	now := e.now()
+
+	var raised []Alert
+	e.mu.Lock()
+	for _, r := range e.rules {
+		if !r.matches(entry) {
+			continue
+		}
+
+		g := e.group(r, entry)
+		g.lastSeen = now
+		if r.Kind == KindAbsence {
+			continue
+		}
+
+		if g.times = append(g.times, now); len(g.times) > r.Count {
+			g.times = g.times[1:]
+		}
+		if len(g.times) < r.Count || (r.window > 0 && now.Sub(g.times[0]) > r.window) {
+			continue
+		}
+
+		if a, ok := g.raise(now); ok {
+			a.Count = len(g.times)
+			a.Message, a.Fields = entry.Message, fields(entry)
+			if r.Kind == KindThreshold && a.Count > 1 {
+				a.Summary = fmt.Sprintf("%d entries matched %s within %s", a.Count, r.Name, r.window)
+			} else {
+				a.Summary = fmt.Sprintf("entry matched %s: %s", r.Name, entry.Message)
+			}
+			raised = append(raised, a)
+		}
+	}
+	e.mu.Unlock()
+
+	for _, a := range raised {
+		e.enqueue(a)
+	}
+
+	return nil
+}
+
+// Levels is a method that returns all logrus levels.
+func (e *Engine) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Dropped is a method that returns the number of alerts dropped because the queue was full.
+func (e *Engine) Dropped() uint64 {
+	return e.dropped.Load()
+}
+
+// Run is a method that evaluates the absence rules and delivers the alerts until ctx is done.
+// The alerts still queued when it returns are delivered by Flush.
+func (e *Engine) Run(ctx context.Context) {
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case a := <-e.queue:
+			e.notify(ctx, a)
+		case <-ticker.C:
+			e.check()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Flush is a method that delivers the alerts still queued, until ctx is done.
+func (e *Engine) Flush(ctx context.Context) error {
+	for {
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("fail to deliver %d alerts: %w", len(e.queue), err)
+		}
+
+		select {
+		case a := <-e.queue:
+			e.notify(ctx, a)
+		default:
+			return nil
+		}
+	}
+}
+
+// check is a method that raises an alert for every group of an absence rule without a matching entry within its
+// window, and removes the idle groups of the other rules.
+func (e *Engine) check() {
+	now := e.now()
+
+	var raised []Alert
+	e.mu.Lock()
+	for key, g := range e.groups {
+		r := g.rule
+		if r.Kind != KindAbsence {
+			if g.idle(now) {
+				delete(e.groups, key)
+			}
+			continue
+		}
+		if now.Sub(g.lastSeen) <= r.window {
+			continue
+		}
+
+		if a, ok := g.raise(now); ok {
+			a.LastSeen = g.lastSeen
+			a.Summary = fmt.Sprintf("no entry matched %s within %s", r.Name, r.window)
+			raised = append(raised, a)
+		}
+	}
+	e.mu.Unlock()
+
+	sort.Slice(raised, func(i, j int) bool { return raised[i].Rule < raised[j].Rule })
+	for _, a := range raised {
+		e.enqueue(a)
+	}
+}
+
+// group is a method that returns the state of the group of an entry, creating it if needed; the lock must be held.
+func (e *Engine) group(r *rule, entry *logrus.Entry) *group {
+	labels := make(map[string]string, len(r.GroupBy))
+	values := make([]string, len(r.GroupBy))
+	for i, k := range r.GroupBy {
+		values[i] = derive.FieldString(entry, k)
+		labels[k] = values[i]
+	}
+
+	key := r.Name
+	if len(values) > 0 {
+		key += "\xff" + strings.Join(values, "\xff")
+	}
+
+	g, ok := e.groups[key]
+	if !ok {
+		g = &group{rule: r, labels: labels}
+		e.groups[key] = g
+	}
+
+	return g
+}
+
+// enqueue is a method that queues an alert to be delivered, dropping it when the queue is full.
+func (e *Engine) enqueue(a Alert) {
+	select {
+	case e.queue <- a:
+	default:
+		e.dropped.Add(1)
+	}
+}
+
+// notify is a method that delivers an alert to every notifier, or to the named ones listed by its rule.
+// Every notifier has its own timeout, so a slow one does not leave the others without time.
+func (e *Engine) notify(ctx context.Context, a Alert) {
+	for _, n := range e.notifiers {
+		if len(a.Channels) > 0 && !routed(n, a.Channels) {
+			continue
+		}
+
+		if err := e.deliver(ctx, n, a); err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "telemetry: fail to deliver alert %s: %v\n", a.Rule, err)
+		}
+	}
+}
+
+// deliver is a method that delivers an alert to a notifier within the timeout.
+func (e *Engine) deliver(ctx context.Context, n Notifier, a Alert) error {
+	ctx, cancel := context.WithTimeout(ctx, e.timeout)
+	defer cancel()
+
+	return n.Notify(ctx, a)
+}
+
+// routed is a function that reports whether a notifier is named after one of the channels.
+func routed(n Notifier, channels []string) bool {
+	named, ok := n.(interface{ Name() string })
+	if !ok {
+		return false
+	}
+
+	for _, c := range channels {
+		if c == named.Name() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ErrorRule is a function that returns a rule raising an alert for every error, fatal or panic entry, deduplicated
+// by function and message and held back for cooldown after an alert.
+func ErrorRule(name string, cooldown time.Duration) Rule {
+	return Rule{
+		Name:     name,
+		Kind:     KindThreshold,
+		Severity: "error",
+		Match:    map[string]string{"level": "error,fatal,panic"},
+		GroupBy:  []string{"func", "msg"},
+		Count:    1,
+		Window:   Duration(cooldown),
+	}
+}
+
+// idle is a method that reports whether the group holds no state worth keeping: no entry matched it within the
+// window of its rule, and its cooldown is over; the lock must be held.
+func (g *group) idle(now time.Time) bool {
+	r := g.rule
+	return now.Sub(g.lastSeen) > r.window && now.Sub(g.lastAlert) >= r.cooldown
+}
+
+// raise is a method that returns the alert of the group unless its cooldown is running, in which case the alert is
+// counted as suppressed; the lock must be held.
+func (g *group) raise(now time.Time) (Alert, bool) {
+	r := g.rule
+	if !g.lastAlert.IsZero() && now.Sub(g.lastAlert) < r.cooldown {
+		g.suppressed++
+		return Alert{}, false
+	}
+
+	a := Alert{
+		Rule:        r.Name,
+		Kind:        r.Kind,
+		Severity:    r.Severity,
+		Description: r.Description,
+		Channels:    r.Channels,
+		Group:       g.labels,
+		Suppressed:  g.suppressed,
+		Window:      Duration(r.window),
+		Time:        now,
+	}
+	g.lastAlert, g.suppressed = now, 0
+
+	return a, true
+}
+
+// matches is a method that reports whether an entry matches the fields and the pattern of the rule.
+func (r *rule) matches(entry *logrus.Entry) bool {
+	if !derive.MatchFields(entry, r.match) {
+		return false
+	}
+
+	if r.pattern == nil {
+		return true
+	}
+
+	field := r.Field
+	if field == "" {
+		field = "msg"
+	}
+
+	return r.pattern.MatchString(derive.FieldString(entry, field))
+}
+
+// fields is a function that returns a copy of the fields of an entry, with errors as their message.
+func fields(entry *logrus.Entry) map[string]interface{} {
+	out := make(map[string]interface{}, len(entry.Data))
+	for k, v := range entry.Data {
+		if err, ok := v.(error); ok {
+			v = err.Error()
+		}
+		out[k] = v
+	}
+
+	return out
+}
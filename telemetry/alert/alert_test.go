@@ -0,0 +1,174 @@
+package alert
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// clock is a struct that holds the time seen by an Engine under test.
+type clock struct{ t time.Time }
+
+func (c *clock) now() time.Time          { return c.t }
+func (c *clock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+// newEngine is a function that creates an Engine evaluating rules at the time of the returned clock.
+func newEngine(t *testing.T, rules ...Rule) (*Engine, *clock) {
+	t.Helper()
+
+	c := &clock{t: time.Date(2024, 6, 14, 10, 0, 0, 0, time.UTC)}
+	e, err := New(rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.now = c.now
+
+	return e, c
+}
+
+// drain is a function that returns the alerts queued by the engine.
+func drain(e *Engine) (out []Alert) {
+	for {
+		select {
+		case a := <-e.queue:
+			out = append(out, a)
+		default:
+			return
+		}
+	}
+}
+
+func TestThresholdRule(t *testing.T) {
+	e, c := newEngine(t, Rule{
+		Name: "payment_errors", Kind: KindThreshold, Count: 3, Window: Duration(5 * time.Minute),
+		Match: map[string]string{"level": "error", "code": "502,503"}, GroupBy: []string{"service"},
+	})
+
+	fire := func(service string, code int) {
+		_ = e.Fire(&logrus.Entry{Level: logrus.ErrorLevel, Message: "upstream failed", Data: logrus.Fields{"service": service, "code": code}})
+	}
+
+	fire("billing", 502)
+	fire("billing", 400)
+	fire("checkout", 503)
+	c.advance(6 * time.Minute)
+	fire("billing", 503)
+	fire("billing", 502)
+	if alerts := drain(e); len(alerts) != 0 {
+		t.Fatalf("alert raised outside the window: %+v", alerts)
+	}
+
+	fire("billing", 502)
+	alerts := drain(e)
+	if len(alerts) != 1 || alerts[0].Group["service"] != "billing" || alerts[0].Count != 3 {
+		t.Fatalf("unexpected alerts: %+v", alerts)
+	}
+
+	// The cooldown, the window by default, holds back the following alerts of the group.
+	fire("billing", 502)
+	c.advance(time.Minute)
+	fire("billing", 502)
+	if alerts = drain(e); len(alerts) != 0 {
+		t.Fatalf("alert raised during the cooldown: %+v", alerts)
+	}
+
+	c.advance(5 * time.Minute)
+	fire("billing", 502)
+	fire("billing", 502)
+	fire("billing", 502)
+	if alerts = drain(e); len(alerts) != 1 || alerts[0].Suppressed != 2 {
+		t.Fatalf("unexpected alerts after the cooldown: %+v", alerts)
+	}
+}
+
+func TestAbsenceRule(t *testing.T) {
+	e, c := newEngine(t, Rule{
+		Name: "heartbeat", Kind: KindAbsence, Window: Duration(10 * time.Minute), Match: map[string]string{"msg": "heartbeat"},
+	})
+
+	c.advance(9 * time.Minute)
+	_ = e.Fire(&logrus.Entry{Level: logrus.InfoLevel, Message: "heartbeat"})
+	c.advance(9 * time.Minute)
+	e.check()
+	if alerts := drain(e); len(alerts) != 0 {
+		t.Fatalf("unexpected alerts: %+v", alerts)
+	}
+
+	c.advance(2 * time.Minute)
+	e.check()
+	e.check()
+	alerts := drain(e)
+	if len(alerts) != 1 || alerts[0].Kind != KindAbsence || alerts[0].LastSeen.IsZero() {
+		t.Fatalf("unexpected alerts: %+v", alerts)
+	}
+}
+
+func TestPatternRule(t *testing.T) {
+	e, _ := newEngine(t, Rule{Name: "deadlock", Kind: KindPattern, Pattern: `(?i)deadlock`, Field: "error"})
+
+	_ = e.Fire(&logrus.Entry{Message: "query failed", Data: logrus.Fields{"error": "timeout"}})
+	_ = e.Fire(&logrus.Entry{Message: "query failed", Data: logrus.Fields{"error": "Deadlock found"}})
+
+	alerts := drain(e)
+	if len(alerts) != 1 || alerts[0].Fields["error"] != "Deadlock found" {
+		t.Fatalf("unexpected alerts: %+v", alerts)
+	}
+}
+
+func TestIdleGroupsEvicted(t *testing.T) {
+	e, c := newEngine(t, ErrorRule("errors", time.Minute))
+
+	for i := 0; i < 3; i++ {
+		_ = e.Fire(&logrus.Entry{Level: logrus.ErrorLevel, Message: fmt.Sprintf("order %d not found", i)})
+	}
+	if n := len(drain(e)); n != 3 {
+		t.Fatalf("got %d alerts, want one per message", n)
+	}
+
+	c.advance(30 * time.Second)
+	_ = e.Fire(&logrus.Entry{Level: logrus.ErrorLevel, Message: "order 0 not found"})
+	e.check()
+	if len(e.groups) != 3 {
+		t.Fatalf("got %d groups, want the groups still in their cooldown kept", len(e.groups))
+	}
+
+	c.advance(45 * time.Second)
+	e.check()
+	if len(e.groups) != 1 {
+		t.Fatalf("got %d groups, want the idle groups evicted", len(e.groups))
+	}
+
+	c.advance(time.Minute)
+	e.check()
+	if len(e.groups) != 0 {
+		t.Fatalf("got %d groups, want every idle group evicted", len(e.groups))
+	}
+}
+
+func TestParse(t *testing.T) {
+	rules, err := Parse([]byte(`
+rules:
+  - name: payment_errors
+    kind: threshold
+    match: {level: error, code: "502"}
+    count: 5
+    window: 5m
+    cooldown: 15m
+`), "yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 1 || rules[0].Window != Duration(5*time.Minute) || rules[0].Cooldown != Duration(15*time.Minute) {
+		t.Fatalf("unexpected rules: %+v", rules)
+	}
+
+	if _, err = Parse([]byte(`{"rules": [{"name": "x", "kind": "absence", "windw": "1m"}]}`), "json"); err == nil {
+		t.Fatal("unknown key was accepted")
+	}
+
+	if _, err = New([]Rule{{Name: "x", Kind: KindThreshold, Count: 1}}); err == nil {
+		t.Fatal("threshold rule without a window was accepted")
+	}
+}
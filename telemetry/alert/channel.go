@@ -0,0 +1,198 @@
+// Package alert provides an in-process rules engine raising alerts from log entries.
+package alert
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// These constants are the defaults of a Channel.
+const (
+	DefaultAttempts = 3
+	DefaultBackoff  = time.Second
+)
+
+// ErrRateLimited is returned by a Channel delivering more alerts than its rate limit allows.
+var ErrRateLimited = errors.New("alert channel rate limit exceeded")
+
+// permanentError is a struct that marks an error retrying cannot fix, such as a rejected request.
+type permanentError struct{ err error }
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent is a function that marks err as not worth retrying.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return &permanentError{err: err}
+}
+
+// IsPermanent is a function that reports whether err was marked as not worth retrying.
+func IsPermanent(err error) bool {
+	var p *permanentError
+	return errors.As(err, &p)
+}
+
+// ChannelOptFunc is a type that defines a function that modifies a Channel instance.
+type ChannelOptFunc func(*Channel) error
+
+// WithRetries is a function that returns a ChannelOptFunc which sets the number of delivery attempts of an alert,
+// the delay before the second one, doubling for each following attempt.
+func WithRetries(attempts int, backoff time.Duration) ChannelOptFunc {
+	return func(c *Channel) (err error) {
+		if attempts <= 0 || backoff < 0 {
+			return fmt.Errorf("invalid retries %d or backoff %s", attempts, backoff)
+		}
+
+		c.attempts, c.backoff = attempts, backoff
+		return
+	}
+}
+
+// WithRateLimit is a function that returns a ChannelOptFunc which delivers at most perMinute alerts a minute,
+// with bursts of up to burst alerts; the others are dropped.
+func WithRateLimit(perMinute float64, burst int) ChannelOptFunc {
+	return func(c *Channel) (err error) {
+		if perMinute <= 0 || burst <= 0 {
+			return fmt.Errorf("invalid rate limit %v per minute with burst %d", perMinute, burst)
+		}
+
+		c.rate, c.burst, c.tokens = perMinute/60, float64(burst), float64(burst)
+		return
+	}
+}
+
+// Channel is a struct that delivers alerts through a notifier under a name, retrying failed deliveries and
+// limiting their rate. Rules listing channels only deliver their alerts to the channels of those names.
+type Channel struct {
+	name     string
+	n        Notifier
+	attempts int
+	backoff  time.Duration
+
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewChannel is a function that creates a Channel named name delivering through n.
+// It applies the provided options to the Channel instance.
+func NewChannel(name string, n Notifier, opts ...ChannelOptFunc) (*Channel, error) {
+	if name == "" || n == nil {
+		return nil, errors.New("channel needs a name and a notifier")
+	}
+
+	c := &Channel{name: name, n: n, attempts: DefaultAttempts, backoff: DefaultBackoff, last: time.Now()}
+	for _, opt := range opts {
+		if err := opt(c); err != nil {
+			return nil, fmt.Errorf("fail to apply options: %w", err)
+		}
+	}
+
+	return c, nil
+}
+
+// Name is a method that returns the name of the channel.
+func (c *Channel) Name() string {
+	return c.name
+}
+
+// Notify is a method that delivers an alert, retrying with a growing backoff until an attempt succeeds,
+// the error is permanent, the attempts are exhausted or ctx is done.
+func (c *Channel) Notify(ctx context.Context, a Alert) (err error) {
+	if !c.allow(time.Now()) {
+		return fmt.Errorf("%s: %w", c.name, ErrRateLimited)
+	}
+
+	backoff := c.backoff
+	for attempt := 0; attempt < c.attempts; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return fmt.Errorf("%s: %w", c.name, errors.Join(err, ctx.Err()))
+			}
+			backoff *= 2
+		}
+
+		if err = c.n.Notify(ctx, a); err == nil || IsPermanent(err) {
+			break
+		}
+	}
+
+	if err != nil {
+		return fmt.Errorf("%s: %w", c.name, err)
+	}
+
+	return nil
+}
+
+// allow is a method that takes a token from the bucket, refilling it for the time elapsed since the last call.
+func (c *Channel) allow(now time.Time) bool {
+	if c.rate == 0 {
+		return true
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.tokens += now.Sub(c.last).Seconds() * c.rate
+	if c.tokens > c.burst {
+		c.tokens = c.burst
+	}
+	c.last = now
+
+	if c.tokens < 1 {
+		return false
+	}
+
+	c.tokens--
+	return true
+}
+
+// postJSON is a function that posts a JSON body to url. Client errors other than 408 and 429 are permanent.
+func postJSON(ctx context.Context, client *http.Client, url string, body []byte, header http.Header) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return Permanent(fmt.Errorf("fail to create request: %w", err))
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range header {
+		req.Header[k] = v
+	}
+
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("fail to post alert: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 300 {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	err = fmt.Errorf("alert rejected with status %d: %s", resp.StatusCode, bytes.TrimSpace(msg))
+	if resp.StatusCode < 500 && resp.StatusCode != http.StatusRequestTimeout && resp.StatusCode != http.StatusTooManyRequests {
+		return Permanent(err)
+	}
+
+	return err
+}
@@ -0,0 +1,103 @@
+// Package alert provides an in-process rules engine raising alerts from log entries.
+package alert
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Duration is a type that holds a time.Duration written as a string such as "5m" in rule files.
+type Duration time.Duration
+
+// String is a method that returns the duration formatted as a time.Duration.
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+// MarshalJSON is a method that writes the duration as a string.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// UnmarshalJSON is a method that reads the duration from a string such as "5m".
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("invalid duration %s: expected a string such as \"5m\"", b)
+	}
+
+	return d.parse(s)
+}
+
+// UnmarshalYAML is a method that reads the duration from a string such as "5m".
+func (d *Duration) UnmarshalYAML(value *yaml.Node) error {
+	return d.parse(value.Value)
+}
+
+// parse is a method that sets the duration from its string form.
+func (d *Duration) parse(s string) error {
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("invalid duration %q: %w", s, err)
+	}
+
+	*d = Duration(v)
+	return nil
+}
+
+// File is a struct that holds the content of a rule file.
+type File struct {
+	Rules []Rule `json:"rules" yaml:"rules"`
+}
+
+// LoadFile is a function that reads the rules of a YAML file, with a .yaml or .yml extension, or of a JSON file.
+// Unknown keys are rejected so that a misspelt setting is not silently ignored.
+func LoadFile(path string) ([]Rule, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("fail to read rule file: %w", err)
+	}
+
+	format := "json"
+	if ext := strings.ToLower(filepath.Ext(path)); ext == ".yaml" || ext == ".yml" {
+		format = "yaml"
+	}
+
+	rules, err := Parse(b, format)
+	if err != nil {
+		return nil, fmt.Errorf("fail to parse %s: %w", path, err)
+	}
+
+	return rules, nil
+}
+
+// Parse is a function that reads the rules of a rule file in the given format, "yaml" or "json".
+func Parse(b []byte, format string) ([]Rule, error) {
+	var f File
+
+	switch format {
+	case "yaml":
+		dec := yaml.NewDecoder(bytes.NewReader(b))
+		dec.KnownFields(true)
+		if err := dec.Decode(&f); err != nil {
+			return nil, err
+		}
+	case "json":
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&f); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("invalid rule file format: %s", format)
+	}
+
+	return f.Rules, nil
+}
@@ -0,0 +1,215 @@
+package alert
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var testAlert = Alert{
+	Rule:     "payment_errors",
+	Kind:     KindThreshold,
+	Severity: "critical",
+	Summary:  "20 entries matched payment_errors within 5m0s",
+	Group:    map[string]string{"service": "billing"},
+	Count:    20,
+	Time:     time.Date(2024, 6, 14, 10, 0, 0, 0, time.UTC),
+}
+
+func TestWebhook(t *testing.T) {
+	var got struct {
+		body      string
+		signature string
+		timestamp string
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		got.body, got.signature, got.timestamp = string(b), r.Header.Get(SignatureHeader), r.Header.Get(TimestampHeader)
+	}))
+	defer srv.Close()
+
+	w, err := NewWebhook(srv.URL, `{"text": {{json .Summary}}, "service": {{json (index .Group "service")}}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Secret = "s3cret"
+
+	if err = w.Notify(context.Background(), testAlert); err != nil {
+		t.Fatal(err)
+	}
+
+	if got.body != `{"text": "20 entries matched payment_errors within 5m0s", "service": "billing"}` {
+		t.Fatalf("unexpected body: %s", got.body)
+	}
+	if got.signature != Sign("s3cret", got.timestamp, []byte(got.body)) {
+		t.Fatalf("invalid signature %q", got.signature)
+	}
+}
+
+func TestSlack(t *testing.T) {
+	var payload struct {
+		Text        string `json:"text"`
+		Attachments []struct {
+			Color  string `json:"color"`
+			Fields []struct {
+				Title string `json:"title"`
+				Value string `json:"value"`
+			} `json:"fields"`
+		} `json:"attachments"`
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewDecoder(r.Body).Decode(&payload)
+	}))
+	defer srv.Close()
+
+	if err := (&Slack{URL: srv.URL}).Notify(context.Background(), testAlert); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(payload.Text, "[critical] payment_errors:") || len(payload.Attachments) != 1 ||
+		payload.Attachments[0].Color != "#D00000" || payload.Attachments[0].Fields[0].Value != "billing" {
+		t.Fatalf("unexpected payload: %+v", payload)
+	}
+}
+
+func TestChannelRetries(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch calls.Add(1) {
+		case 1:
+			w.WriteHeader(http.StatusBadGateway)
+		case 2:
+			w.WriteHeader(http.StatusOK)
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer srv.Close()
+
+	w, _ := NewWebhook(srv.URL, "")
+	c, err := NewChannel("ops", w, WithRetries(3, time.Millisecond), WithRateLimit(60, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = c.Notify(context.Background(), testAlert); err != nil || calls.Load() != 2 {
+		t.Fatalf("expected a retried success, got %v after %d calls", err, calls.Load())
+	}
+
+	if err = c.Notify(context.Background(), testAlert); !IsPermanent(err) || calls.Load() != 3 {
+		t.Fatalf("expected a permanent failure without retry, got %v after %d calls", err, calls.Load())
+	}
+
+	if err = c.Notify(context.Background(), testAlert); !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("expected the rate limit, got %v", err)
+	}
+}
+
+func TestSMTP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	received := make(chan string, 1)
+	go serveSMTP(ln, received)
+
+	s := &SMTP{Addr: ln.Addr().String(), From: "alerts@example.com", To: []string{"ops@example.com"}}
+	if err = s.Notify(context.Background(), testAlert); err != nil {
+		t.Fatal(err)
+	}
+
+	msg := <-received
+	for _, want := range []string{"MAIL FROM:<alerts@example.com>", "RCPT TO:<ops@example.com>", "Subject: [critical] payment_errors:", "service: billing"} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("missing %q in:\n%s", want, msg)
+		}
+	}
+}
+
+// serveSMTP is a function that answers a single SMTP session and sends the commands and message it received.
+func serveSMTP(ln net.Listener, received chan<- string) {
+	conn, err := ln.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	var session strings.Builder
+	r := bufio.NewReader(conn)
+	reply := func(s string) { _, _ = io.WriteString(conn, s+"\r\n") }
+
+	reply("220 localhost ESMTP")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		session.WriteString(line)
+
+		switch cmd := strings.ToUpper(strings.TrimSpace(line)); {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			reply("250 localhost")
+		case cmd == "DATA":
+			reply("354 end with .")
+			for {
+				if line, err = r.ReadString('\n'); err != nil || line == ".\r\n" {
+					break
+				}
+				session.WriteString(line)
+			}
+			reply("250 queued")
+		case cmd == "QUIT":
+			reply("221 bye")
+			received <- session.String()
+			return
+		default:
+			reply("250 ok")
+		}
+	}
+}
+
+func TestNotifierTimeouts(t *testing.T) {
+	slow := NotifierFunc(func(ctx context.Context, _ Alert) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	var delivered atomic.Bool
+	fast := NotifierFunc(func(ctx context.Context, _ Alert) error {
+		if ctx.Err() == nil {
+			delivered.Store(true)
+		}
+		return ctx.Err()
+	})
+
+	e, err := New(nil, WithNotifier(slow), WithNotifier(fast), WithTimeout(20*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e.enqueue(testAlert)
+	if err = e.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if !delivered.Load() {
+		t.Fatal("a slow notifier used up the timeout of the next one")
+	}
+
+	e.enqueue(testAlert)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err = e.Flush(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Flush() with a done context = %v, want context.Canceled", err)
+	}
+}
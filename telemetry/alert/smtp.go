@@ -0,0 +1,118 @@
+// Package alert provides an in-process rules engine raising alerts from log entries.
+package alert
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// SMTP is a struct that sends alerts by email.
+// STARTTLS is used when the server offers it, and required to authenticate unless the server is local.
+type SMTP struct {
+	Addr      string      // Addr is the host:port of the server.
+	From      string      // From is the sender address.
+	To        []string    // To are the recipient addresses.
+	Username  string      // Username authenticates with PLAIN when set.
+	Password  string      // Password is the password of Username.
+	TLSConfig *tls.Config // TLSConfig is used for STARTTLS, verifying the server host by default.
+}
+
+// Notify is a method that sends an alert as a plain-text email.
+func (s *SMTP) Notify(ctx context.Context, a Alert) error {
+	if s.From == "" || len(s.To) == 0 {
+		return Permanent(errors.New("smtp notifier needs a sender and recipients"))
+	}
+
+	host, _, err := net.SplitHostPort(s.Addr)
+	if err != nil {
+		return Permanent(fmt.Errorf("invalid smtp address: %w", err))
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", s.Addr)
+	if err != nil {
+		return fmt.Errorf("fail to connect to smtp server: %w", err)
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		return fmt.Errorf("fail to greet smtp server: %w", err)
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		cfg := s.TLSConfig
+		if cfg == nil {
+			cfg = &tls.Config{ServerName: host}
+		}
+		if err = c.StartTLS(cfg); err != nil {
+			return fmt.Errorf("fail to start tls: %w", err)
+		}
+	}
+
+	if s.Username != "" {
+		if err = c.Auth(smtp.PlainAuth("", s.Username, s.Password, host)); err != nil {
+			return Permanent(fmt.Errorf("fail to authenticate: %w", err))
+		}
+	}
+
+	if err = c.Mail(s.From); err != nil {
+		return fmt.Errorf("fail to set sender: %w", err)
+	}
+	for _, to := range s.To {
+		if err = c.Rcpt(to); err != nil {
+			return fmt.Errorf("fail to add recipient %s: %w", to, err)
+		}
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return fmt.Errorf("fail to start message: %w", err)
+	}
+	if _, err = w.Write(s.message(a)); err != nil {
+		return fmt.Errorf("fail to write message: %w", err)
+	}
+	if err = w.Close(); err != nil {
+		return fmt.Errorf("fail to send message: %w", err)
+	}
+
+	return c.Quit()
+}
+
+// message is a method that returns the email of an alert, headers included.
+func (s *SMTP) message(a Alert) []byte {
+	var b bytes.Buffer
+	header := func(k, v string) { fmt.Fprintf(&b, "%s: %s\r\n", k, v) }
+
+	header("From", s.From)
+	header("To", strings.Join(s.To, ", "))
+	header("Subject", mime.QEncoding.Encode("utf-8", title(a)))
+	header("Date", a.Time.Format(time.RFC1123Z))
+	header("MIME-Version", "1.0")
+	header("Content-Type", "text/plain; charset=utf-8")
+	b.WriteString("\r\n")
+
+	b.WriteString(a.Summary + "\r\n")
+	if a.Description != "" {
+		b.WriteString("\r\n" + a.Description + "\r\n")
+	}
+	b.WriteString("\r\n")
+	for _, f := range facts(a) {
+		fmt.Fprintf(&b, "%s: %s\r\n", f[0], f[1])
+	}
+
+	return b.Bytes()
+}
@@ -0,0 +1,214 @@
+// Package alert provides an in-process rules engine raising alerts from log entries.
+package alert
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// These constants are the headers carrying the signature of a webhook body.
+const (
+	SignatureHeader = "X-Telemetry-Signature"
+	TimestampHeader = "X-Telemetry-Timestamp"
+)
+
+// Webhook is a struct that posts alerts as JSON to a URL.
+type Webhook struct {
+	URL    string       // URL is the address the alerts are posted to.
+	Secret string       // Secret signs the body with HMAC-SHA256 when set, see Sign.
+	Header http.Header  // Header holds additional request headers.
+	Client *http.Client // Client sends the requests, http.DefaultClient when nil.
+
+	body *template.Template
+}
+
+// NewWebhook is a function that creates a Webhook posting to url the body rendered by the body template,
+// a text/template executed with the Alert, or the Alert as JSON when body is empty.
+// The template function json writes a value as JSON, e.g. {"text": {{json .Summary}}}.
+func NewWebhook(url, body string) (*Webhook, error) {
+	w := &Webhook{URL: url}
+	if body == "" {
+		return w, nil
+	}
+
+	t, err := template.New("webhook").Funcs(template.FuncMap{"json": toJSON}).Parse(body)
+	if err != nil {
+		return nil, fmt.Errorf("fail to parse webhook template: %w", err)
+	}
+
+	w.body = t
+	return w, nil
+}
+
+// Notify is a method that posts an alert.
+func (w *Webhook) Notify(ctx context.Context, a Alert) error {
+	var body []byte
+	if w.body == nil {
+		var err error
+		if body, err = json.Marshal(a); err != nil {
+			return Permanent(fmt.Errorf("fail to encode alert: %w", err))
+		}
+	} else {
+		var b bytes.Buffer
+		if err := w.body.Execute(&b, a); err != nil {
+			return Permanent(fmt.Errorf("fail to render webhook template: %w", err))
+		}
+		body = b.Bytes()
+	}
+
+	header := w.Header.Clone()
+	if w.Secret != "" {
+		if header == nil {
+			header = http.Header{}
+		}
+
+		ts := strconv.FormatInt(time.Now().Unix(), 10)
+		header.Set(TimestampHeader, ts)
+		header.Set(SignatureHeader, Sign(w.Secret, ts, body))
+	}
+
+	return postJSON(ctx, w.Client, w.URL, body, header)
+}
+
+// Sign is a function that returns the signature of a webhook body sent at timestamp, as found in SignatureHeader:
+// "sha256=" followed by the hex HMAC-SHA256 of the timestamp, a dot and the body. Receivers compare it with
+// hmac.Equal, and reject old timestamps to prevent replays.
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Slack is a struct that posts alerts to a Slack incoming webhook.
+type Slack struct {
+	URL    string       // URL is the incoming webhook address.
+	Client *http.Client // Client sends the requests, http.DefaultClient when nil.
+}
+
+// Notify is a method that posts an alert as a Slack message with an attachment holding its details.
+func (s *Slack) Notify(ctx context.Context, a Alert) error {
+	type field struct {
+		Title string `json:"title"`
+		Value string `json:"value"`
+		Short bool   `json:"short"`
+	}
+
+	fields := make([]field, 0, len(a.Group)+2)
+	for _, f := range facts(a) {
+		fields = append(fields, field{Title: f[0], Value: f[1], Short: len(f[1]) < 40})
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"text": title(a),
+		"attachments": []map[string]interface{}{{
+			"color":  color(a.Severity),
+			"text":   a.Description,
+			"fields": fields,
+			"ts":     a.Time.Unix(),
+		}},
+	})
+	if err != nil {
+		return Permanent(fmt.Errorf("fail to encode alert: %w", err))
+	}
+
+	return postJSON(ctx, s.Client, s.URL, body, nil)
+}
+
+// Teams is a struct that posts alerts to a Microsoft Teams incoming webhook.
+type Teams struct {
+	URL    string       // URL is the incoming webhook address.
+	Client *http.Client // Client sends the requests, http.DefaultClient when nil.
+}
+
+// Notify is a method that posts an alert as a Teams message card listing its details.
+func (t *Teams) Notify(ctx context.Context, a Alert) error {
+	type fact struct {
+		Name  string `json:"name"`
+		Value string `json:"value"`
+	}
+
+	list := make([]fact, 0, len(a.Group)+2)
+	for _, f := range facts(a) {
+		list = append(list, fact{Name: f[0], Value: f[1]})
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"@type":      "MessageCard",
+		"@context":   "https://schema.org/extensions",
+		"summary":    title(a),
+		"title":      title(a),
+		"themeColor": strings.TrimPrefix(color(a.Severity), "#"),
+		"text":       a.Description,
+		"sections":   []map[string]interface{}{{"facts": list}},
+	})
+	if err != nil {
+		return Permanent(fmt.Errorf("fail to encode alert: %w", err))
+	}
+
+	return postJSON(ctx, t.Client, t.URL, body, nil)
+}
+
+// title is a function that returns the one-line description of an alert.
+func title(a Alert) string {
+	if a.Severity == "" {
+		return fmt.Sprintf("%s: %s", a.Rule, a.Summary)
+	}
+
+	return fmt.Sprintf("[%s] %s: %s", a.Severity, a.Rule, a.Summary)
+}
+
+// facts is a function that returns the details of an alert as name and value pairs, group labels first.
+func facts(a Alert) [][2]string {
+	keys := make([]string, 0, len(a.Group))
+	for k := range a.Group {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	out := make([][2]string, 0, len(keys)+4)
+	for _, k := range keys {
+		out = append(out, [2]string{k, a.Group[k]})
+	}
+
+	if a.Message != "" {
+		out = append(out, [2]string{"message", a.Message})
+	}
+	if a.Suppressed > 0 {
+		out = append(out, [2]string{"suppressed", strconv.Itoa(a.Suppressed)})
+	}
+	out = append(out, [2]string{"time", a.Time.UTC().Format(time.RFC3339)})
+
+	return out
+}
+
+// color is a function that returns the color of a severity.
+func color(severity string) string {
+	switch strings.ToLower(severity) {
+	case "critical", "error", "fatal", "page":
+		return "#D00000"
+	case "warning", "warn":
+		return "#FFA500"
+	}
+
+	return "#439FE0"
+}
+
+// toJSON is a function that returns a value as JSON, for webhook templates.
+func toJSON(v interface{}) (string, error) {
+	b, err := json.Marshal(v)
+	return string(b), err
+}
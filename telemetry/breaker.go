@@ -0,0 +1,169 @@
+// Package telemetry provides functionality for telemetry logging.
+package telemetry
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/dyaksa/telemetry-log/telemetry/breaker"
+	"github.com/dyaksa/telemetry-log/telemetry/log"
+	"github.com/sirupsen/logrus"
+)
+
+// WithCircuitBreaker is a function that returns an OptFunc which wraps every sink with a circuit breaker.
+// While the breaker of a sink is open its entries go to a fallback instead: the spool for the Mongo sink when
+// WithSpool is used, the sink set by WithFallbackSink, or stderr. State changes are logged to the console only.
+// As the Mongo sink does not fail when it spools, breaker.WithLatencyThreshold should be set along with WithSpool.
+func WithCircuitBreaker(opts ...breaker.OptFunc) OptFunc {
+	return func(li *Lib) (err error) {
+		if _, err = breaker.New(opts...); err != nil {
+			return fmt.Errorf("fail to create circuit breaker: %w", err)
+		}
+
+		li.withBreaker = true
+		li.breakerOpts = opts
+		return
+	}
+}
+
+// errSinkPanic is the result recorded by the breaker for a call whose sink panicked.
+var errSinkPanic = errors.New("sink panicked")
+
+// BreakerHook is a struct that wraps a logrus.Hook with a circuit breaker and sends entries to a fallback while it is open.
+type BreakerHook struct {
+	name     string
+	hook     logrus.Hook
+	fallback logrus.Hook
+	breaker  *breaker.Breaker
+}
+
+// NewBreakerHook is a function that creates a new BreakerHook delivering to hook through a breaker configured by opts.
+// Entries rejected by the open breaker, or failed by hook, are fired to fallback; without fallback they are dropped
+// with breaker.ErrOpen. The error is returned in both cases, marked as handled when the fallback took the entry,
+// so that the sink failures are still counted and reported by WithOnSinkError.
+func NewBreakerHook(name string, hook, fallback logrus.Hook, opts ...breaker.OptFunc) (*BreakerHook, error) {
+	b, err := breaker.New(opts...)
+	if err != nil {
+		return nil, fmt.Errorf("fail to create circuit breaker: %w", err)
+	}
+
+	return &BreakerHook{name: name, hook: hook, fallback: fallback, breaker: b}, nil
+}
+
+// Name is a method that returns the name of the wrapped sink.
+func (h *BreakerHook) Name() string {
+	return h.name
+}
+
+// Unwrap is a method that returns the wrapped hook.
+func (h *BreakerHook) Unwrap() logrus.Hook {
+	return h.hook
+}
+
+// State is a method that returns the state of the breaker.
+func (h *BreakerHook) State() breaker.State {
+	return h.breaker.State()
+}
+
+// Levels is a method that returns the levels of the wrapped hook.
+func (h *BreakerHook) Levels() []logrus.Level {
+	return h.hook.Levels()
+}
+
+// Fire is a method that delivers the entry to the wrapped hook when the breaker allows it, to the fallback otherwise.
+func (h *BreakerHook) Fire(e *logrus.Entry) error {
+	if !h.breaker.Allow() {
+		return h.fallbackFire(e, breaker.ErrOpen)
+	}
+
+	start := time.Now()
+	done := false
+	defer func() {
+		// A panicking sink still ends its call, or a half-open breaker would wait for the probe forever.
+		if !done {
+			h.breaker.Done(errSinkPanic, time.Since(start))
+		}
+	}()
+
+	err := h.hook.Fire(e)
+	done = true
+	h.breaker.Done(err, time.Since(start))
+
+	if err != nil {
+		return h.fallbackFire(e, err)
+	}
+
+	return nil
+}
+
+// fallbackFire is a method that fires the entry to the fallback and returns err, marked as handled when the
+// fallback took the entry.
+func (h *BreakerHook) fallbackFire(e *logrus.Entry, err error) error {
+	if h.fallback == nil {
+		return err
+	}
+
+	if ferr := h.fallback.Fire(e); ferr != nil {
+		return fmt.Errorf("%w, fallback failed: %w", err, ferr)
+	}
+
+	return &handledError{err: err}
+}
+
+// handledError is a struct that holds the error of a sink whose entry was delivered to a fallback instead.
+type handledError struct {
+	err error
+}
+
+// Error is a method that returns the message of the sink error.
+func (e *handledError) Error() string {
+	return e.err.Error() + " (entry sent to the fallback)"
+}
+
+// Unwrap is a method that returns the sink error.
+func (e *handledError) Unwrap() error {
+	return e.err
+}
+
+// breakerHook is a method that wraps a sink with a circuit breaker whose state changes are logged to console.
+func (li *Lib) breakerHook(sink logrus.Hook, console log.Logger) (logrus.Hook, error) {
+	name := sinkName(sink)
+
+	fallback := li.fallback
+	if fallback == nil {
+		fallback = &WriterHook{Writer: os.Stderr, Formatter: &logrus.JSONFormatter{}}
+	}
+	if m, ok := sink.(*MongoHook); ok && m.Spool != nil {
+		fallback = &spoolHook{m: m}
+	}
+
+	opts := append([]breaker.OptFunc{}, li.breakerOpts...)
+	opts = append(opts, breaker.WithStateChange(func(from, to breaker.State) {
+		fields := []log.LogContextFunc{log.String("sink", name), log.String("from", from.String()), log.String("to", to.String())}
+		if to == breaker.Open {
+			console.Warn("sink circuit breaker opened", fields...)
+			return
+		}
+		console.Info("sink circuit breaker state changed", fields...)
+	}))
+
+	return NewBreakerHook(name, sink, fallback, opts...)
+}
+
+// spoolHook is a struct that spools the entries of a MongoHook without trying to store them.
+type spoolHook struct {
+	m *MongoHook
+}
+
+// Levels is a method that returns all logrus levels.
+func (h *spoolHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Fire is a method that appends the entry to the spool of the MongoHook.
+func (h *spoolHook) Fire(e *logrus.Entry) error {
+	r := h.m.route(e)
+	return h.m.spool(r, h.m.document(e, r))
+}
@@ -0,0 +1,238 @@
+// Package breaker provides a circuit breaker that stops calls to a failing dependency for a while.
+//
+// A closed breaker lets every call through. It opens after a number of consecutive failures, a call slower than the
+// latency threshold counting as a failure. An open breaker rejects every call until the open timeout has elapsed,
+// then turns half-open and lets one probe call through at a time: enough successful probes close it again, a failed
+// one opens it for another timeout.
+package breaker
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// These constants are the defaults of a Breaker.
+const (
+	DefaultFailureThreshold = 5
+	DefaultOpenTimeout      = 30 * time.Second
+	DefaultHalfOpenTrials   = 1
+)
+
+// ErrOpen is returned by callers when a call was rejected by an open breaker.
+var ErrOpen = errors.New("circuit breaker is open")
+
+// State is a type that defines the state of a Breaker.
+type State int
+
+// These constants represent the different states.
+const (
+	Closed   State = iota // Closed lets every call through.
+	Open                  // Open rejects every call.
+	HalfOpen              // HalfOpen lets one probe call through at a time.
+)
+
+// String is a method that returns the name of the state.
+func (s State) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half-open"
+	}
+
+	return fmt.Sprintf("state(%d)", int(s))
+}
+
+// OptFunc is a type that defines a function that modifies a Breaker instance.
+type OptFunc func(*Breaker) error
+
+// Breaker is a struct that holds the state of a circuit breaker.
+type Breaker struct {
+	failureThreshold int
+	latencyThreshold time.Duration
+	openTimeout      time.Duration
+	halfOpenTrials   int
+	onChange         func(from, to State)
+	now              func() time.Time
+
+	mu        sync.Mutex
+	state     State
+	failures  int
+	successes int
+	probing   bool
+	openedAt  time.Time
+}
+
+// WithFailureThreshold is a function that returns an OptFunc which sets the number of consecutive failures opening the breaker.
+func WithFailureThreshold(n int) OptFunc {
+	return func(b *Breaker) (err error) {
+		if n <= 0 {
+			return fmt.Errorf("invalid failure threshold: %d", n)
+		}
+
+		b.failureThreshold = n
+		return
+	}
+}
+
+// WithLatencyThreshold is a function that returns an OptFunc which counts the calls slower than d as failures.
+func WithLatencyThreshold(d time.Duration) OptFunc {
+	return func(b *Breaker) (err error) {
+		if d < 0 {
+			return fmt.Errorf("invalid latency threshold: %s", d)
+		}
+
+		b.latencyThreshold = d
+		return
+	}
+}
+
+// WithOpenTimeout is a function that returns an OptFunc which sets how long the breaker stays open before probing.
+func WithOpenTimeout(d time.Duration) OptFunc {
+	return func(b *Breaker) (err error) {
+		if d <= 0 {
+			return fmt.Errorf("invalid open timeout: %s", d)
+		}
+
+		b.openTimeout = d
+		return
+	}
+}
+
+// WithHalfOpenTrials is a function that returns an OptFunc which sets the number of successful probes closing the breaker.
+func WithHalfOpenTrials(n int) OptFunc {
+	return func(b *Breaker) (err error) {
+		if n <= 0 {
+			return fmt.Errorf("invalid half-open trials: %d", n)
+		}
+
+		b.halfOpenTrials = n
+		return
+	}
+}
+
+// WithStateChange is a function that returns an OptFunc which adds a function called on every state change.
+// It is called synchronously, outside the lock of the breaker; several functions are called in the order they were added.
+func WithStateChange(fn func(from, to State)) OptFunc {
+	return func(b *Breaker) (err error) {
+		if fn == nil {
+			return errors.New("state change function must not be nil")
+		}
+
+		if prev := b.onChange; prev != nil {
+			b.onChange = func(from, to State) {
+				prev(from, to)
+				fn(from, to)
+			}
+			return
+		}
+
+		b.onChange = fn
+		return
+	}
+}
+
+// New is a function that creates a new closed Breaker.
+// It applies the provided options to the Breaker instance.
+func New(opts ...OptFunc) (*Breaker, error) {
+	b := &Breaker{
+		failureThreshold: DefaultFailureThreshold,
+		openTimeout:      DefaultOpenTimeout,
+		halfOpenTrials:   DefaultHalfOpenTrials,
+		now:              time.Now,
+	}
+
+	for _, opt := range opts {
+		if err := opt(b); err != nil {
+			return nil, fmt.Errorf("fail to apply options: %w", err)
+		}
+	}
+
+	return b, nil
+}
+
+// State is a method that returns the current state of the breaker.
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.state
+}
+
+// Allow is a method that reports whether a call may go through.
+// Every allowed call must be followed by a call to Done with its result.
+func (b *Breaker) Allow() bool {
+	b.mu.Lock()
+
+	from := b.state
+	switch b.state {
+	case Open:
+		if b.now().Sub(b.openedAt) < b.openTimeout {
+			b.mu.Unlock()
+			return false
+		}
+		b.state, b.successes = HalfOpen, 0
+		fallthrough
+	case HalfOpen:
+		if b.probing {
+			b.mu.Unlock()
+			return false
+		}
+		b.probing = true
+	}
+
+	to := b.state
+	b.mu.Unlock()
+
+	b.changed(from, to)
+	return true
+}
+
+// Done is a method that records the result of an allowed call and how long it took.
+func (b *Breaker) Done(err error, elapsed time.Duration) {
+	failed := err != nil || (b.latencyThreshold > 0 && elapsed > b.latencyThreshold)
+
+	b.mu.Lock()
+
+	from := b.state
+	switch b.state {
+	case Closed:
+		if !failed {
+			b.failures = 0
+			break
+		}
+		if b.failures++; b.failures >= b.failureThreshold {
+			b.open()
+		}
+	case HalfOpen:
+		b.probing = false
+		if failed {
+			b.open()
+			break
+		}
+		if b.successes++; b.successes >= b.halfOpenTrials {
+			b.state, b.failures = Closed, 0
+		}
+	}
+
+	to := b.state
+	b.mu.Unlock()
+
+	b.changed(from, to)
+}
+
+// open is a method that opens the breaker; the lock must be held.
+func (b *Breaker) open() {
+	b.state, b.openedAt, b.failures, b.successes = Open, b.now(), 0, 0
+}
+
+// changed is a method that calls the state change function when the state changed.
+func (b *Breaker) changed(from, to State) {
+	if from != to && b.onChange != nil {
+		b.onChange(from, to)
+	}
+}
@@ -0,0 +1,80 @@
+package breaker_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/dyaksa/telemetry-log/telemetry/breaker"
+)
+
+func TestBreakerTransitions(t *testing.T) {
+	var changes []string
+	b, err := breaker.New(
+		breaker.WithFailureThreshold(2),
+		breaker.WithLatencyThreshold(50*time.Millisecond),
+		breaker.WithOpenTimeout(20*time.Millisecond),
+		breaker.WithStateChange(func(from, to breaker.State) { changes = append(changes, from.String()+">"+to.String()) }),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fail := errors.New("insert failed")
+
+	b.Allow()
+	b.Done(fail, time.Millisecond)
+	b.Allow()
+	b.Done(nil, time.Second) // too slow, counted as a failure
+	if b.State() != breaker.Open || b.Allow() {
+		t.Fatalf("breaker is %s after two failures, want open and rejecting", b.State())
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if !b.Allow() {
+		t.Fatal("breaker did not let a probe through after the open timeout")
+	}
+	if b.Allow() {
+		t.Fatal("breaker let a second probe through while half-open")
+	}
+	b.Done(fail, time.Millisecond)
+	if b.State() != breaker.Open {
+		t.Fatalf("breaker is %s after a failed probe, want open", b.State())
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	b.Allow()
+	b.Done(nil, time.Millisecond)
+	if b.State() != breaker.Closed {
+		t.Fatalf("breaker is %s after a successful probe, want closed", b.State())
+	}
+
+	want := []string{"closed>open", "open>half-open", "half-open>open", "open>half-open", "half-open>closed"}
+	if len(changes) != len(want) {
+		t.Fatalf("got changes %v, want %v", changes, want)
+	}
+	for i := range want {
+		if changes[i] != want[i] {
+			t.Fatalf("got changes %v, want %v", changes, want)
+		}
+	}
+}
+
+func TestBreakerChainsStateChanges(t *testing.T) {
+	var calls []string
+	b, err := breaker.New(
+		breaker.WithFailureThreshold(1),
+		breaker.WithStateChange(func(from, to breaker.State) { calls = append(calls, "caller:"+to.String()) }),
+		breaker.WithStateChange(func(from, to breaker.State) { calls = append(calls, "library:"+to.String()) }),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b.Allow()
+	b.Done(errors.New("insert failed"), time.Millisecond)
+
+	if len(calls) != 2 || calls[0] != "caller:open" || calls[1] != "library:open" {
+		t.Fatalf("got calls %v, want both functions called in order", calls)
+	}
+}
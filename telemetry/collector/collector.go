@@ -0,0 +1,414 @@
+// Package collector provides a server receiving log entries over HTTP and a socket protocol and storing them in batches.
+package collector
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dyaksa/telemetry-log/telemetry/log"
+	"github.com/dyaksa/telemetry-log/telemetry/metrics"
+	"github.com/dyaksa/telemetry-log/telemetry/wire"
+	"github.com/sirupsen/logrus"
+)
+
+// These constants are the defaults of a Server.
+const (
+	DefaultQueueSize     = 1024
+	DefaultBatchSize     = 500
+	DefaultFlushInterval = time.Second
+	DefaultMaxEntries    = 10000
+)
+
+// writeAttempts is the number of times a batch write is tried before its entries are rejected.
+const writeAttempts = 3
+
+// These variables are the errors reported to clients.
+var (
+	ErrUnauthorized = errors.New("invalid api key")
+	ErrQueueFull    = errors.New("queue is full")
+	ErrClosed       = errors.New("collector is shutting down")
+	ErrInvalid      = errors.New("invalid entries")
+)
+
+// BatchWriter is an interface that defines a method for storing entries in a single round trip.
+// It is implemented by telemetry.MongoHook.
+type BatchWriter interface {
+	WriteBatch(ctx context.Context, entries []*logrus.Entry) error
+}
+
+// OptFunc is a type that defines a function that modifies a Server instance.
+type OptFunc func(*Server) error
+
+// Server is a struct that holds the queue and the settings of the collector.
+type Server struct {
+	w             BatchWriter
+	keys          map[string]string
+	queue         chan *batch
+	batchSize     int
+	flushInterval time.Duration
+	maxEntries    int
+	ready         func(ctx context.Context) error
+	log           log.Logger
+	metrics       *serverMetrics
+	done          chan struct{} // done is closed when Run starts draining the queue.
+	stopped       chan struct{} // stopped is closed when Run returns.
+}
+
+// batch is a struct that holds the entries received in a request or a frame.
+// done, when set, receives the result of the write once the entries are stored.
+type batch struct {
+	entries []*logrus.Entry
+	done    chan error
+}
+
+// WithAPIKey is a function that returns an OptFunc which accepts the given key from clients.
+// Entries sent with the key are attributed to service, whatever service field they carry, so that a key holder
+// cannot write entries under another service; with an empty service they keep their own.
+// When no key is configured the collector accepts every client.
+func WithAPIKey(key, service string) OptFunc {
+	return func(s *Server) (err error) {
+		if key == "" {
+			return errors.New("api key must not be empty")
+		}
+
+		s.keys[key] = service
+		return
+	}
+}
+
+// WithQueueSize is a function that returns an OptFunc which sets how many requests and frames may wait to be written.
+// Requests are rejected, and socket clients stop being read, once the queue is full.
+func WithQueueSize(n int) OptFunc {
+	return func(s *Server) (err error) {
+		if n <= 0 {
+			return fmt.Errorf("invalid queue size: %d", n)
+		}
+
+		s.queue = make(chan *batch, n)
+		return
+	}
+}
+
+// WithBatchSize is a function that returns an OptFunc which sets the number of entries written at once,
+// and the interval after which a smaller batch is written anyway.
+func WithBatchSize(size int, flushInterval time.Duration) OptFunc {
+	return func(s *Server) (err error) {
+		if size <= 0 || flushInterval <= 0 {
+			return fmt.Errorf("invalid batch size %d or flush interval %s", size, flushInterval)
+		}
+
+		s.batchSize = size
+		s.flushInterval = flushInterval
+		return
+	}
+}
+
+// WithMaxEntries is a function that returns an OptFunc which sets the maximum number of entries in a request or a frame.
+func WithMaxEntries(n int) OptFunc {
+	return func(s *Server) (err error) {
+		if n <= 0 {
+			return fmt.Errorf("invalid max entries: %d", n)
+		}
+
+		s.maxEntries = n
+		return
+	}
+}
+
+// WithReadyCheck is a function that returns an OptFunc which sets the check run by the readiness endpoint,
+// typically a ping of the store.
+func WithReadyCheck(fn func(ctx context.Context) error) OptFunc {
+	return func(s *Server) (err error) {
+		s.ready = fn
+		return
+	}
+}
+
+// WithLogger is a function that returns an OptFunc which sets the logger the collector reports its own errors to.
+func WithLogger(l log.Logger) OptFunc {
+	return func(s *Server) (err error) {
+		s.log = l
+		return
+	}
+}
+
+// WithMetrics is a function that returns an OptFunc which records the activity of the collector in r,
+// metrics.Default when nil, and serves it on GET /metrics: entries by result, queue depth, batch sizes and write latency.
+func WithMetrics(r *metrics.Registry) OptFunc {
+	return func(s *Server) (err error) {
+		if r == nil {
+			r = metrics.Default
+		}
+
+		s.metrics = &serverMetrics{
+			registry: r,
+			entries:  r.Counter("collector_entries_total", "Entries received, by result: accepted, invalid, rejected, written or failed.", "result"),
+			batches:  r.Histogram("collector_batch_size", "Entries per batch written to the store.", metrics.SizeBuckets),
+			latency:  r.Histogram("collector_write_seconds", "Time taken to write a batch, retries included.", nil),
+		}
+		return
+	}
+}
+
+// serverMetrics is a struct that holds the metrics recorded by the collector.
+type serverMetrics struct {
+	registry *metrics.Registry
+	entries  *metrics.Counter
+	batches  *metrics.Histogram
+	latency  *metrics.Histogram
+}
+
+// New is a function that creates a new Server writing the received entries to w.
+// It applies the provided options to the Server instance.
+func New(w BatchWriter, opts ...OptFunc) (*Server, error) {
+	if w == nil {
+		return nil, errors.New("batch writer must not be nil")
+	}
+
+	s := &Server{
+		w:             w,
+		keys:          map[string]string{},
+		queue:         make(chan *batch, DefaultQueueSize),
+		batchSize:     DefaultBatchSize,
+		flushInterval: DefaultFlushInterval,
+		maxEntries:    DefaultMaxEntries,
+		done:          make(chan struct{}),
+		stopped:       make(chan struct{}),
+	}
+
+	for _, opt := range opts {
+		if err := opt(s); err != nil {
+			return nil, fmt.Errorf("fail to apply options: %w", err)
+		}
+	}
+
+	if s.metrics != nil {
+		s.metrics.registry.GaugeFunc("collector_queue_depth", "Requests and frames waiting to be written.", func() float64 { return float64(len(s.queue)) })
+	}
+
+	return s, nil
+}
+
+// Run is a method that writes the queued entries in batches until ctx is done.
+// The entries still queued are then written before it returns, so the listeners must be stopped first.
+func (s *Server) Run(ctx context.Context) {
+	defer close(s.stopped)
+
+	ticker := time.NewTicker(s.flushInterval)
+	defer ticker.Stop()
+
+	var pending []*batch
+	size := 0
+	flush := func(ctx context.Context) {
+		if len(pending) > 0 {
+			s.write(ctx, pending, size)
+			pending, size = nil, 0
+		}
+	}
+
+	for {
+		select {
+		case b := <-s.queue:
+			pending = append(pending, b)
+			if size += len(b.entries); size >= s.batchSize {
+				flush(ctx)
+			}
+		case <-ticker.C:
+			flush(ctx)
+		case <-ctx.Done():
+			close(s.done)
+
+			drain, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			for {
+				select {
+				case b := <-s.queue:
+					pending = append(pending, b)
+					size += len(b.entries)
+				default:
+					flush(drain)
+					return
+				}
+			}
+		}
+	}
+}
+
+// write is a method that stores the entries of the batches, retrying with a backoff, and reports the result to each batch.
+func (s *Server) write(ctx context.Context, batches []*batch, size int) {
+	entries := make([]*logrus.Entry, 0, size)
+	for _, b := range batches {
+		entries = append(entries, b.entries...)
+	}
+
+	start := time.Now()
+
+	var err error
+	for attempt := 0; attempt < writeAttempts; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(time.Duration(100<<attempt) * time.Millisecond):
+			case <-ctx.Done():
+			}
+		}
+
+		if err = s.w.WriteBatch(ctx, entries); err == nil || ctx.Err() != nil {
+			break
+		}
+	}
+
+	if s.metrics != nil {
+		result := "written"
+		if err != nil {
+			result = "failed"
+		}
+		s.metrics.entries.Add(float64(len(entries)), result)
+		s.metrics.batches.Observe(float64(len(entries)))
+		s.metrics.latency.Observe(time.Since(start).Seconds())
+	}
+
+	if err != nil && s.log != nil {
+		s.log.Error("fail to write entries", log.Error("error", err), log.Int64("entries", int64(len(entries))))
+	}
+
+	for _, b := range batches {
+		if b.done != nil {
+			b.done <- err
+		}
+	}
+}
+
+// enqueue is a method that queues the entries, waiting for room until ctx is done when wait is set.
+func (s *Server) enqueue(ctx context.Context, b *batch, wait bool) (err error) {
+	if s.metrics != nil {
+		defer func() {
+			result := "accepted"
+			if err != nil {
+				result = "rejected"
+			}
+			s.metrics.entries.Add(float64(len(b.entries)), result)
+		}()
+	}
+
+	select {
+	case <-s.done:
+		return ErrClosed
+	default:
+	}
+
+	if !wait {
+		select {
+		case s.queue <- b:
+			return nil
+		default:
+			return ErrQueueFull
+		}
+	}
+
+	select {
+	case s.queue <- b:
+		return nil
+	case <-s.done:
+		return ErrClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// authenticate is a method that checks the API key and returns the service it belongs to.
+func (s *Server) authenticate(key string) (service string, err error) {
+	if len(s.keys) == 0 {
+		return "", nil
+	}
+
+	ok := false
+	for k, svc := range s.keys {
+		if subtle.ConstantTimeCompare([]byte(k), []byte(key)) == 1 {
+			ok, service = true, svc
+		}
+	}
+
+	if !ok {
+		return "", ErrUnauthorized
+	}
+
+	return service, nil
+}
+
+// entries is a method that validates the received entries and turns them into logrus entries.
+// Entries without a time are stamped with received, and every entry gets the service of the API key when it has one.
+func (s *Server) entries(in []wire.Entry, service string, received time.Time) (out []*logrus.Entry, err error) {
+	if s.metrics != nil {
+		defer func() {
+			if err != nil {
+				s.metrics.entries.Add(float64(len(in)), "invalid")
+			}
+		}()
+	}
+
+	if len(in) == 0 {
+		return nil, fmt.Errorf("%w: no entries", ErrInvalid)
+	}
+	if len(in) > s.maxEntries {
+		return nil, fmt.Errorf("%w: %d entries exceed the maximum of %d", ErrInvalid, len(in), s.maxEntries)
+	}
+
+	out = make([]*logrus.Entry, 0, len(in))
+	for i, e := range in {
+		lvl, err := logrus.ParseLevel(e.Level)
+		if err != nil {
+			return nil, fmt.Errorf("%w: entry %d has level %q", ErrInvalid, i, e.Level)
+		}
+
+		if e.Message == "" {
+			return nil, fmt.Errorf("%w: entry %d has an empty message", ErrInvalid, i)
+		}
+
+		if e.Time.IsZero() {
+			e.Time = received
+		}
+
+		data := logrus.Fields(e.Fields)
+		if data == nil {
+			data = logrus.Fields{}
+		}
+		if service != "" {
+			data["service"] = service
+		}
+		normalizeCaller(data)
+
+		out = append(out, &logrus.Entry{Time: e.Time, Level: lvl, Message: e.Message, Data: data})
+	}
+
+	return out, nil
+}
+
+// normalizeCaller is a function that stores the line as an integer, as the hook formats it,
+// splitting it from the file when it was sent as "file:line".
+func normalizeCaller(data logrus.Fields) {
+	switch line := data["line"].(type) {
+	case float64:
+		data["line"] = int(line)
+		return
+	case int:
+		return
+	}
+
+	file, ok := data["file"].(string)
+	if !ok {
+		return
+	}
+
+	if i := strings.LastIndexByte(file, ':'); i > 0 {
+		if line, err := strconv.Atoi(file[i+1:]); err == nil {
+			data["file"], data["line"] = file[:i], line
+			return
+		}
+	}
+	data["line"] = 0
+}
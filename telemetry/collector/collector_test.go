@@ -0,0 +1,198 @@
+package collector_test
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dyaksa/telemetry-log/telemetry/collector"
+	"github.com/dyaksa/telemetry-log/telemetry/wire"
+	"github.com/sirupsen/logrus"
+)
+
+// memWriter is a BatchWriter keeping the entries in memory.
+type memWriter struct {
+	mu      sync.Mutex
+	entries []*logrus.Entry
+}
+
+func (w *memWriter) WriteBatch(_ context.Context, entries []*logrus.Entry) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.entries = append(w.entries, entries...)
+	return nil
+}
+
+func (w *memWriter) len() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return len(w.entries)
+}
+
+func newServer(t *testing.T, w *memWriter) *collector.Server {
+	t.Helper()
+
+	s, err := collector.New(w, collector.WithAPIKey("secret", "billing"), collector.WithBatchSize(100, 10*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.Run(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	return s
+}
+
+func TestHTTPEntries(t *testing.T) {
+	w := &memWriter{}
+	srv := httptest.NewServer(newServer(t, w).Handler())
+	defer srv.Close()
+
+	body := `{"level":"info","msg":"started","time":"2024-06-14T22:36:10Z","file":"main.go:12"}
+{"level":"error","msg":"failed","request_id":"r-1","service":"checkout"}
+`
+	post := func(key string) int {
+		req, _ := http.NewRequest(http.MethodPost, srv.URL+"/v1/entries", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/x-ndjson")
+		req.Header.Set("Authorization", "Bearer "+key)
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return res.StatusCode
+	}
+
+	if code := post("wrong"); code != http.StatusUnauthorized {
+		t.Fatalf("got status %d with a wrong key, want 401", code)
+	}
+	if code := post("secret"); code != http.StatusAccepted {
+		t.Fatalf("got status %d, want 202", code)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for w.len() < 2 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if w.len() != 2 {
+		t.Fatalf("got %d entries written, want 2", w.len())
+	}
+
+	e := w.entries[0]
+	if e.Data["service"] != "billing" || e.Data["file"] != "main.go" || e.Data["line"] != 12 {
+		t.Fatalf("entry not enriched: %v", e.Data)
+	}
+	if s := w.entries[1].Data["service"]; s != "billing" {
+		t.Fatalf("entry stored under service %v, want the service of the key", s)
+	}
+	if w.entries[1].Time.IsZero() {
+		t.Fatal("entry without time was not stamped")
+	}
+}
+
+func TestSocketAcks(t *testing.T) {
+	w := &memWriter{}
+	s := newServer(t, w)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = s.Serve(ctx, l) }()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+
+	roundTrip := func(m *wire.Message) *wire.Message {
+		if err := wire.WriteFrame(conn, m); err != nil {
+			t.Fatal(err)
+		}
+		ack, err := wire.ReadFrame(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ack
+	}
+
+	if ack := roundTrip(&wire.Message{Type: wire.TypeHello, Key: "secret"}); ack.Error != "" {
+		t.Fatalf("hello rejected: %s", ack.Error)
+	}
+
+	ack := roundTrip(&wire.Message{Type: wire.TypeBatch, Seq: 1, Entries: []wire.Entry{{Level: "warning", Message: "slow"}}})
+	if ack.Seq != 1 || ack.Error != "" {
+		t.Fatalf("unexpected ack: %+v", ack)
+	}
+	if w.len() != 1 {
+		t.Fatalf("batch acked before being written")
+	}
+
+	ack = roundTrip(&wire.Message{Type: wire.TypeBatch, Seq: 2, Entries: []wire.Entry{{Level: "loud", Message: "x"}}})
+	if ack.Seq != 2 || ack.Error == "" {
+		t.Fatalf("invalid batch was not rejected: %+v", ack)
+	}
+}
+
+func TestSocketRejectsWrongKey(t *testing.T) {
+	w := &memWriter{}
+	s := newServer(t, w)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = s.Serve(ctx, l) }()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	r := bufio.NewReader(conn)
+
+	if err = wire.WriteFrame(conn, &wire.Message{Type: wire.TypeHello, Key: "wrong"}); err != nil {
+		t.Fatal(err)
+	}
+	ack, err := wire.ReadFrame(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ack.Error == "" {
+		t.Fatal("hello with a wrong key was accepted")
+	}
+
+	// The server closes the connection, so the batch is never acked nor stored.
+	_ = wire.WriteFrame(conn, &wire.Message{Type: wire.TypeBatch, Seq: 1, Entries: []wire.Entry{{Level: "warning", Message: "slow"}}})
+	if _, err = wire.ReadFrame(r); err == nil {
+		t.Fatal("connection with a wrong key stayed open")
+	}
+	if w.len() != 0 {
+		t.Fatalf("stored %d entries from an unauthenticated client", w.len())
+	}
+}
@@ -0,0 +1,174 @@
+// Package collector provides a server receiving log entries over HTTP and a socket protocol and storing them in batches.
+package collector
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dyaksa/telemetry-log/telemetry/wire"
+)
+
+// maxBodySize is the maximum size of a request body.
+const maxBodySize = 32 << 20
+
+// Handler is a method that returns the HTTP handler of the collector.
+//
+// POST /v1/entries accepts a JSON entry, a JSON array of entries, or NDJSON with Content-Type application/x-ndjson,
+// each entry shaped like the logrus JSON formatter output. The API key is read from the X-API-Key header
+// or an "Authorization: Bearer" header. Entries are answered with 202 once queued, 503 when the queue is full.
+//
+// GET /healthz reports the process is alive, GET /readyz that the store answers and the queue has room.
+// GET /metrics serves the metrics in the Prometheus text format when WithMetrics is used.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/entries", s.handleEntries)
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	})
+	mux.HandleFunc("GET /readyz", s.handleReady)
+	if s.metrics != nil {
+		mux.Handle("GET /metrics", s.metrics.registry)
+	}
+	return mux
+}
+
+// handleEntries is a method that queues the entries of a request.
+func (s *Server) handleEntries(w http.ResponseWriter, r *http.Request) {
+	received := time.Now()
+
+	service, err := s.authenticate(apiKey(r))
+	if err != nil {
+		writeError(w, http.StatusUnauthorized, err)
+		return
+	}
+
+	in, err := decodeEntries(http.MaxBytesReader(w, r.Body, maxBodySize), r.Header.Get("Content-Type"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	entries, err := s.entries(in, service, received)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err = s.enqueue(r.Context(), &batch{entries: entries}, false); err != nil {
+		w.Header().Set("Retry-After", "1")
+		writeError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+
+	writeJSON(w, http.StatusAccepted, map[string]int{"accepted": len(entries)})
+}
+
+// handleReady is a method that runs the readiness check and reports whether the queue has room.
+func (s *Server) handleReady(w http.ResponseWriter, r *http.Request) {
+	if s.ready != nil {
+		ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+		defer cancel()
+
+		if err := s.ready(ctx); err != nil {
+			writeError(w, http.StatusServiceUnavailable, fmt.Errorf("store unavailable: %w", err))
+			return
+		}
+	}
+
+	select {
+	case <-s.done:
+		writeError(w, http.StatusServiceUnavailable, ErrClosed)
+		return
+	default:
+	}
+
+	if len(s.queue) == cap(s.queue) {
+		writeError(w, http.StatusServiceUnavailable, ErrQueueFull)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ready"})
+}
+
+// apiKey is a function that returns the API key of a request.
+func apiKey(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+
+	if auth := r.Header.Get("Authorization"); len(auth) > 7 && strings.EqualFold(auth[:7], "bearer ") {
+		return auth[7:]
+	}
+
+	return ""
+}
+
+// decodeEntries is a function that decodes a JSON entry, a JSON array of entries or NDJSON.
+func decodeEntries(body io.Reader, contentType string) ([]wire.Entry, error) {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+
+	if mediaType == "application/x-ndjson" || mediaType == "application/jsonl" {
+		var entries []wire.Entry
+		sc := bufio.NewScanner(body)
+		sc.Buffer(make([]byte, 64<<10), wire.MaxFrameSize)
+		for n := 1; sc.Scan(); n++ {
+			line := bytes.TrimSpace(sc.Bytes())
+			if len(line) == 0 {
+				continue
+			}
+
+			var e wire.Entry
+			if err := json.Unmarshal(line, &e); err != nil {
+				return nil, fmt.Errorf("line %d: %w", n, err)
+			}
+			entries = append(entries, e)
+		}
+
+		return entries, sc.Err()
+	}
+
+	b, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+
+	b = bytes.TrimSpace(b)
+	if len(b) > 0 && b[0] == '[' {
+		var entries []wire.Entry
+		err = json.Unmarshal(b, &entries)
+		return entries, err
+	}
+
+	var e wire.Entry
+	if err = json.Unmarshal(b, &e); err != nil {
+		return nil, err
+	}
+
+	return []wire.Entry{e}, nil
+}
+
+// writeJSON is a function that writes v as the JSON body of a response.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// writeError is a function that writes err as the JSON body of a response.
+func writeError(w http.ResponseWriter, status int, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		status = http.StatusRequestEntityTooLarge
+	}
+
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,156 @@
+// Package collector provides a server receiving log entries over HTTP and a socket protocol and storing them in batches.
+package collector
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/dyaksa/telemetry-log/telemetry/log"
+	"github.com/dyaksa/telemetry-log/telemetry/wire"
+)
+
+// maxInFlight is the number of batches of a connection that may wait for their ack.
+const maxInFlight = 64
+
+// handshakeTimeout bounds the time a client has to send its hello message.
+const handshakeTimeout = 10 * time.Second
+
+// Serve is a method that accepts socket connections on l until ctx is done or l is closed.
+//
+// A client first sends a hello message with its API key, which is answered by an ack, or by an ack carrying
+// an error before the connection is closed. Each batch is then acked once its entries are stored, or with an error
+// if they were not stored, so a client can resend the batches left unacked when the connection drops.
+// Acks of batches that failed for a transient reason, such as a full queue or an unavailable store, are marked retry.
+// A client that sends faster than the entries are stored is slowed down by the connection no longer being read.
+func (s *Server) Serve(ctx context.Context, l net.Listener) error {
+	stop := context.AfterFunc(ctx, func() { _ = l.Close() })
+	defer stop()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return fmt.Errorf("fail to accept connection: %w", err)
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.serveConn(ctx, conn)
+		}()
+	}
+}
+
+// pendingAck is a struct that holds a batch waiting for its ack.
+type pendingAck struct {
+	seq  uint64
+	done chan error
+}
+
+// serveConn is a method that runs the protocol on a connection.
+// Frames are read by the calling goroutine while another writes the acks in order.
+func (s *Server) serveConn(ctx context.Context, conn net.Conn) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+	defer stop()
+
+	r := bufio.NewReader(conn)
+	_ = conn.SetReadDeadline(time.Now().Add(handshakeTimeout))
+
+	hello, err := wire.ReadFrame(r)
+	if err != nil {
+		_ = conn.Close()
+		return
+	}
+
+	service, authErr := s.authenticate(hello.Key)
+	if authErr == nil && hello.Type != wire.TypeHello {
+		authErr = fmt.Errorf("expected a %s message, got %q", wire.TypeHello, hello.Type)
+	}
+	// The handshake error is acked to the client before the connection is closed, so it knows why.
+	if err = s.ack(conn, hello.Seq, authErr); err != nil || authErr != nil {
+		_ = conn.Close()
+		return
+	}
+	_ = conn.SetReadDeadline(time.Time{})
+
+	acks := make(chan pendingAck, maxInFlight)
+	writerDone := make(chan struct{})
+	go func() {
+		defer close(writerDone)
+		for a := range acks {
+			if err := s.ack(conn, a.seq, s.wait(a.done)); err != nil {
+				cancel()
+			}
+		}
+	}()
+
+	for {
+		m, err := wire.ReadFrame(r)
+		if err != nil {
+			if !errors.Is(err, io.EOF) && ctx.Err() == nil && s.log != nil {
+				s.log.Warn("fail to read frame", log.Error("error", err), log.String("remote", conn.RemoteAddr().String()))
+			}
+			break
+		}
+
+		done := make(chan error, 1)
+		if m.Type != wire.TypeBatch {
+			done <- fmt.Errorf("%w: unexpected %q message", ErrInvalid, m.Type)
+		} else if entries, err := s.entries(m.Entries, service, time.Now()); err != nil {
+			done <- err
+		} else if err = s.enqueue(ctx, &batch{entries: entries, done: done}, true); err != nil {
+			done <- err
+		}
+
+		select {
+		case acks <- pendingAck{seq: m.Seq, done: done}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	close(acks)
+	<-writerDone
+	_ = conn.Close()
+}
+
+// wait is a method that returns the result of a queued batch, or ErrClosed if the collector stopped without writing it.
+func (s *Server) wait(done chan error) error {
+	select {
+	case err := <-done:
+		return err
+	case <-s.stopped:
+		select {
+		case err := <-done:
+			return err
+		default:
+			return ErrClosed
+		}
+	}
+}
+
+// ack is a method that answers a message, with the error message if it was rejected.
+func (s *Server) ack(conn net.Conn, seq uint64, result error) error {
+	m := &wire.Message{Type: wire.TypeAck, Seq: seq}
+	if result != nil {
+		m.Error = result.Error()
+		m.Retry = !errors.Is(result, ErrInvalid) && !errors.Is(result, ErrUnauthorized)
+	}
+
+	return wire.WriteFrame(conn, m)
+}
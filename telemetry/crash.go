@@ -0,0 +1,61 @@
+// Package telemetry provides functionality for telemetry logging.
+package telemetry
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/dyaksa/telemetry-log/cmd"
+	"github.com/dyaksa/telemetry-log/telemetry/mongo"
+	"github.com/sirupsen/logrus"
+)
+
+// CrashWriter is a struct that stores fatal diagnostics documents in a MongoDB collection.
+type CrashWriter struct {
+	Client      *mongo.Mongo  // Client is a pointer to a Mongo instance.
+	Database    string        // Database is the name template of the database, the client's default when empty.
+	Collection  string        // Collection is the name template of the collection the documents are written to.
+	Service     string        // Service is the service substituted in the name templates.
+	Environment string        // Environment is the environment substituted in the name templates.
+	Retention   time.Duration // Retention is how long the documents are kept, forever when zero.
+	Timeout     time.Duration // Timeout is the duration before the write times out.
+}
+
+// crashDocument is a struct that holds a diagnostics document as it is stored.
+type crashDocument struct {
+	cmd.Diagnostics `bson:",inline"`
+	ExpireAt        time.Time `bson:"expire_at,omitempty"`
+}
+
+// WriteDiagnostics is a method that inserts a diagnostics document synchronously.
+// The name templates are expanded at the time of the document. When a retention is set, the document gets an
+// expire_at date and the collection the TTL index removing it.
+func (c *CrashWriter) WriteDiagnostics(d *cmd.Diagnostics) (err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.Timeout)
+	defer cancel()
+
+	vars := nameVars{service: c.Service, environment: c.Environment, level: "fatal", time: d.Time}
+	coll := c.Client.CollectionIn(expandName(c.Database, vars), expandName(c.Collection, vars))
+
+	doc := crashDocument{Diagnostics: *d}
+	if c.Retention > 0 {
+		doc.ExpireAt = d.Time.Add(c.Retention)
+		if _, err = coll.Indexes().CreateMany(ctx, crashIndexes); err != nil {
+			return fmt.Errorf("fail to create crash indexes: %w", err)
+		}
+	}
+
+	_, err = coll.InsertOne(ctx, doc)
+	return
+}
+
+// crashRetention is a method that returns how long crash documents are kept: the retention of the fatal level,
+// else the one of the crash collection.
+func (li *Lib) crashRetention() time.Duration {
+	if d, ok := levelRetention(li.LevelRetention)[logrus.FatalLevel.String()]; ok {
+		return d
+	}
+
+	return li.CollectionRetention[li.CrashCollection]
+}
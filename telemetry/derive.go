@@ -0,0 +1,89 @@
+// Package telemetry provides functionality for telemetry logging.
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dyaksa/telemetry-log/cmd"
+	"github.com/dyaksa/telemetry-log/telemetry/derive"
+	"github.com/dyaksa/telemetry-log/telemetry/metrics"
+)
+
+// WithDerivedMetrics is a function that returns an OptFunc which derives metrics from the logged entries by rules,
+// such as the number of errors by function or a histogram of a logged latency by route.
+// The metrics are recorded in the registry given to WithMetrics, metrics.Default otherwise.
+func WithDerivedMetrics(rules ...derive.Rule) OptFunc {
+	return func(li *Lib) (err error) {
+		li.derivedRules = append(li.derivedRules, rules...)
+		return
+	}
+}
+
+// WithMetricRollup is a function that returns an OptFunc which calls fn every interval with what the rules of
+// WithDerivedMetrics observed during the interval. The last rollup is reported when the Lib shuts down.
+func WithMetricRollup(interval time.Duration, fn func([]derive.Rollup)) OptFunc {
+	return func(li *Lib) (err error) {
+		if interval <= 0 {
+			return fmt.Errorf("invalid rollup interval: %s", interval)
+		}
+		if fn == nil {
+			return errors.New("rollup function must not be nil")
+		}
+
+		li.rollupInterval, li.onRollup = interval, fn
+		return
+	}
+}
+
+// initDerived is a method that creates the hook deriving metrics from the entries and starts the periodic rollups.
+func (li *Lib) initDerived() error {
+	r := li.metrics
+	if r == nil {
+		r = metrics.Default
+	}
+
+	p, err := derive.New(r, li.derivedRules...)
+	if err != nil {
+		return fmt.Errorf("fail to create derived metrics: %w", err)
+	}
+	li.logOpt = append(li.logOpt, cmd.WithHook(p))
+
+	if li.onRollup == nil {
+		return nil
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		ticker := time.NewTicker(li.rollupInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if rollup := p.Rollup(); len(rollup) > 0 {
+					li.onRollup(rollup)
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	li.RegisterExitHandler(func(context.Context) error {
+		close(stop)
+		<-done
+
+		if rollup := p.Rollup(); len(rollup) > 0 {
+			li.onRollup(rollup)
+		}
+		return nil
+	})
+
+	return nil
+}
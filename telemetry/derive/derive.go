@@ -0,0 +1,326 @@
+// Package derive provides a hook deriving metrics from log entries by rules, such as the number of errors by function
+// or a histogram of a logged latency by route, exposed in a metrics registry and as periodic rollups.
+package derive
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dyaksa/telemetry-log/telemetry/metrics"
+	"github.com/sirupsen/logrus"
+)
+
+// These variables are the valid names of a metric and of a label in the Prometheus exposition format.
+var (
+	metricName = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+	labelChars = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+)
+
+// Rule is a struct that describes a metric derived from the entries it matches.
+//
+// An entry matches when every field of Match has one of the listed values, alternatives being separated by commas;
+// the "level" and "msg" fields are the level and the message of the entry.
+//
+// Without Field the metric is a counter of the matching entries. With Field it is a histogram of the value of that
+// field, read as a number, a numeric string or a time.Duration in seconds; entries without a numeric value are skipped.
+type Rule struct {
+	Name    string            `json:"name" yaml:"name"`                             // Name is the name of the metric.
+	Help    string            `json:"help,omitempty" yaml:"help,omitempty"`         // Help describes the metric.
+	Match   map[string]string `json:"match,omitempty" yaml:"match,omitempty"`       // Match holds the values an entry must have, by field.
+	Field   string            `json:"field,omitempty" yaml:"field,omitempty"`       // Field is the field observed by a histogram.
+	Buckets []float64         `json:"buckets,omitempty" yaml:"buckets,omitempty"`   // Buckets are the histogram buckets, metrics.DefaultBuckets when empty.
+	GroupBy []string          `json:"group_by,omitempty" yaml:"group_by,omitempty"` // GroupBy are the fields the metric is labelled by.
+}
+
+// Rollup is a struct that holds what a rule observed for a group of entries during an interval.
+// For a counter, Sum, Min and Max are zero.
+type Rollup struct {
+	Rule   string
+	Labels map[string]string
+	Start  time.Time
+	End    time.Time
+	Count  uint64
+	Sum    float64
+	Min    float64
+	Max    float64
+}
+
+// Mean is a method that returns the mean of the observed values, zero when there are none.
+func (r Rollup) Mean() float64 {
+	if r.Count == 0 {
+		return 0
+	}
+
+	return r.Sum / float64(r.Count)
+}
+
+// rule is a struct that holds a Rule ready to be evaluated.
+type rule struct {
+	Rule
+	match     map[string][]string
+	counter   *metrics.Counter
+	histogram *metrics.Histogram
+}
+
+// Processor is a logrus hook that derives metrics from the entries it receives.
+type Processor struct {
+	rules []*rule
+	now   func() time.Time
+
+	mu     sync.Mutex
+	start  time.Time
+	window map[string]*Rollup
+}
+
+// New is a function that creates a Processor recording the metrics of rules in r, metrics.Default when nil.
+// It fails when a rule has an invalid name or its name is used by another rule.
+func New(r *metrics.Registry, rules ...Rule) (*Processor, error) {
+	if r == nil {
+		r = metrics.Default
+	}
+
+	p := &Processor{now: time.Now, window: map[string]*Rollup{}}
+	p.start = p.now()
+
+	seen := map[string]bool{}
+	for _, in := range rules {
+		if !metricName.MatchString(in.Name) {
+			return nil, fmt.Errorf("invalid metric name %q", in.Name)
+		}
+		if seen[in.Name] {
+			return nil, fmt.Errorf("duplicate metric name %q", in.Name)
+		}
+		seen[in.Name] = true
+
+		ru := &rule{Rule: in, match: map[string][]string{}}
+		for k, v := range in.Match {
+			ru.match[k] = strings.Split(v, ",")
+		}
+
+		labels := make([]string, len(in.GroupBy))
+		for i, g := range in.GroupBy {
+			labels[i] = labelName(g)
+		}
+
+		help := in.Help
+		if help == "" {
+			help = fmt.Sprintf("Derived from log entries by the %s rule.", in.Name)
+		}
+
+		var err error
+		func() {
+			defer func() {
+				if v := recover(); v != nil {
+					err = fmt.Errorf("fail to register %s: %v", in.Name, v)
+				}
+			}()
+
+			if in.Field == "" {
+				ru.counter = r.Counter(in.Name, help, labels...)
+				return
+			}
+			ru.histogram = r.Histogram(in.Name, help, in.Buckets, labels...)
+		}()
+		if err != nil {
+			return nil, err
+		}
+
+		p.rules = append(p.rules, ru)
+	}
+
+	if len(p.rules) == 0 {
+		return nil, errors.New("no rules to derive metrics from")
+	}
+
+	return p, nil
+}
+
+// Fire is a method that updates the metrics of every rule matching the entry.
+func (p *Processor) Fire(e *logrus.Entry) error {
+	for _, ru := range p.rules {
+		if !ru.matches(e) {
+			continue
+		}
+
+		values := make([]string, len(ru.GroupBy))
+		for i, g := range ru.GroupBy {
+			values[i] = FieldString(e, g)
+		}
+
+		if ru.counter != nil {
+			ru.counter.Inc(values...)
+			p.roll(ru, values, 0, false)
+			continue
+		}
+
+		v, ok := Number(e.Data[ru.Field])
+		if !ok {
+			continue
+		}
+		ru.histogram.Observe(v, values...)
+		p.roll(ru, values, v, true)
+	}
+
+	return nil
+}
+
+// Levels is a method that returns all logrus levels.
+func (p *Processor) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Rollup is a method that returns what every rule observed since the previous call, sorted by rule and labels,
+// and starts a new interval.
+func (p *Processor) Rollup() []Rollup {
+	p.mu.Lock()
+	window, start, end := p.window, p.start, p.now()
+	p.window, p.start = map[string]*Rollup{}, end
+	p.mu.Unlock()
+
+	keys := make([]string, 0, len(window))
+	for k := range window {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	out := make([]Rollup, len(keys))
+	for i, k := range keys {
+		r := *window[k]
+		r.Start, r.End = start, end
+		out[i] = r
+	}
+
+	return out
+}
+
+// roll is a method that adds an observation to the current interval.
+func (p *Processor) roll(ru *rule, values []string, v float64, observed bool) {
+	key := ru.Name + "\xff" + strings.Join(values, "\xff")
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	r, ok := p.window[key]
+	if !ok {
+		labels := make(map[string]string, len(values))
+		for i, g := range ru.GroupBy {
+			labels[g] = values[i]
+		}
+		r = &Rollup{Rule: ru.Name, Labels: labels, Min: math.Inf(1), Max: math.Inf(-1)}
+		if !observed {
+			r.Min, r.Max = 0, 0
+		}
+		p.window[key] = r
+	}
+
+	r.Count++
+	if observed {
+		r.Sum += v
+		r.Min = math.Min(r.Min, v)
+		r.Max = math.Max(r.Max, v)
+	}
+}
+
+// matches is a method that reports whether the entry has one of the expected values for every field of the rule.
+func (ru *rule) matches(e *logrus.Entry) bool {
+	return MatchFields(e, ru.match)
+}
+
+// MatchFields is a function that reports whether the entry has one of the expected values for every field of match,
+// the values being read by FieldString.
+func MatchFields(e *logrus.Entry, match map[string][]string) bool {
+	for k, alternatives := range match {
+		v := FieldString(e, k)
+
+		ok := false
+		for _, a := range alternatives {
+			if strings.TrimSpace(a) == v {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+// FieldString is a function that returns the value of a field as a string, "level" and "msg" being the level and the
+// message of the entry, and an empty string when the field is missing.
+func FieldString(e *logrus.Entry, k string) string {
+	switch k {
+	case "level":
+		return e.Level.String()
+	case "msg":
+		return e.Message
+	}
+
+	v, ok := e.Data[k]
+	if !ok || v == nil {
+		return ""
+	}
+
+	if err, ok := v.(error); ok {
+		return err.Error()
+	}
+
+	return fmt.Sprint(v)
+}
+
+// Number is a function that returns the value of a field as a number: an integer, a float, a numeric string
+// or a time.Duration in seconds.
+func Number(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case time.Duration:
+		return n.Seconds(), true
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int8:
+		return float64(n), true
+	case int16:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint:
+		return float64(n), true
+	case uint8:
+		return float64(n), true
+	case uint16:
+		return float64(n), true
+	case uint32:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	case string:
+		f, err := strconv.ParseFloat(n, 64)
+		return f, err == nil
+	}
+
+	return 0, false
+}
+
+// labelName is a function that turns a field name into a valid label name, replacing the invalid characters with
+// underscores.
+func labelName(field string) string {
+	name := labelChars.ReplaceAllString(field, "_")
+	if name == "" || (name[0] >= '0' && name[0] <= '9') {
+		name = "_" + name
+	}
+
+	return name
+}
@@ -0,0 +1,93 @@
+package derive_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dyaksa/telemetry-log/telemetry/derive"
+	"github.com/dyaksa/telemetry-log/telemetry/metrics"
+	"github.com/sirupsen/logrus"
+)
+
+func TestProcessor(t *testing.T) {
+	r := metrics.NewRegistry()
+	p, err := derive.New(r,
+		derive.Rule{Name: "app_errors_total", Match: map[string]string{"level": "error,fatal"}, GroupBy: []string{"func"}},
+		derive.Rule{Name: "app_latency_ms", Field: "latency_ms", Buckets: []float64{10, 100}, GroupBy: []string{"http.route"}},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries := []*logrus.Entry{
+		{Level: logrus.ErrorLevel, Data: logrus.Fields{"func": "Pay", "latency_ms": 5}},
+		{Level: logrus.ErrorLevel, Data: logrus.Fields{"func": "Pay", "http.route": "/pay", "latency_ms": "50"}},
+		{Level: logrus.InfoLevel, Data: logrus.Fields{"func": "Pay", "http.route": "/pay", "latency_ms": 500.0}},
+		{Level: logrus.InfoLevel, Data: logrus.Fields{"latency_ms": "slow"}},
+	}
+	for _, e := range entries {
+		if err = p.Fire(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	text := r.Text()
+	for _, want := range []string{
+		`app_errors_total{func="Pay"} 2`,
+		`app_latency_ms_bucket{http_route="",le="10"} 1`,
+		`app_latency_ms_bucket{http_route="/pay",le="100"} 1`,
+		`app_latency_ms_count{http_route="/pay"} 2`,
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("missing %s in:\n%s", want, text)
+		}
+	}
+
+	rollup := p.Rollup()
+	if len(rollup) != 3 {
+		t.Fatalf("expected 3 rollups, got %+v", rollup)
+	}
+
+	latency := rollup[2]
+	if latency.Rule != "app_latency_ms" || latency.Labels["http.route"] != "/pay" || latency.Count != 2 ||
+		latency.Min != 50 || latency.Max != 500 || latency.Mean() != 275 {
+		t.Fatalf("unexpected rollup: %+v", latency)
+	}
+
+	if rollup = p.Rollup(); len(rollup) != 0 {
+		t.Fatalf("rollup was not reset: %+v", rollup)
+	}
+}
+
+func TestProcessorDuration(t *testing.T) {
+	r := metrics.NewRegistry()
+	p, err := derive.New(r, derive.Rule{Name: "app_elapsed_seconds", Field: "elapsed"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_ = p.Fire(&logrus.Entry{Data: logrus.Fields{"elapsed": 1500 * time.Millisecond}})
+
+	if rollup := p.Rollup(); len(rollup) != 1 || rollup[0].Sum != 1.5 {
+		t.Fatalf("unexpected rollup: %+v", rollup)
+	}
+}
+
+func TestInvalidRules(t *testing.T) {
+	for _, rules := range [][]derive.Rule{
+		nil,
+		{{Name: "1bad"}},
+		{{Name: "dup"}, {Name: "dup"}},
+	} {
+		if _, err := derive.New(metrics.NewRegistry(), rules...); err == nil {
+			t.Errorf("expected an error for %+v", rules)
+		}
+	}
+
+	r := metrics.NewRegistry()
+	r.Gauge("taken", "Taken.")
+	if _, err := derive.New(r, derive.Rule{Name: "taken"}); err == nil {
+		t.Error("expected an error for a name used by another metric")
+	}
+}
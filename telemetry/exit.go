@@ -0,0 +1,142 @@
+// Package telemetry provides functionality for telemetry logging.
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// Flusher is an interface implemented by sinks that buffer entries before delivering them.
+type Flusher interface {
+	Flush(ctx context.Context) error // Flush delivers every buffered entry or returns when ctx is done.
+}
+
+// ExitHandler is a function run before the process exits from Fatal or a termination signal.
+type ExitHandler func(ctx context.Context) error
+
+// WithExitTimeout is a function that returns an OptFunc which bounds the time the exit handlers may take.
+func WithExitTimeout(d time.Duration) OptFunc {
+	return func(li *Lib) (err error) {
+		if d <= 0 {
+			return fmt.Errorf("invalid exit timeout: %s", d)
+		}
+
+		li.exitTimeout = d
+		return
+	}
+}
+
+// WithExitFunc is a function that returns an OptFunc which sets the function used to terminate the process.
+// It defaults to os.Exit; tests can inject a function that records the exit code instead.
+func WithExitFunc(fn func(int)) OptFunc {
+	return func(li *Lib) (err error) {
+		if fn == nil {
+			return errors.New("exit func must not be nil")
+		}
+
+		li.exitFunc = fn
+		return
+	}
+}
+
+// WithSignalHandler is a function that returns an OptFunc which runs the exit handlers on SIGTERM and SIGINT.
+// After the handlers have run the process exits with the conventional 128+signal code.
+func WithSignalHandler(status bool) OptFunc {
+	return func(li *Lib) (err error) {
+		li.handleSignals = status
+		return
+	}
+}
+
+// RegisterExitHandler is a method that registers a handler run before the process exits.
+// Handlers run in registration order, followed by a flush of every sink.
+func (li *Lib) RegisterExitHandler(fn ExitHandler) {
+	li.mu.Lock()
+	defer li.mu.Unlock()
+
+	li.exitHandlers = append(li.exitHandlers, fn)
+}
+
+// Flush is a method that flushes every sink implementing Flusher, the fallback sink included.
+func (li *Lib) Flush(ctx context.Context) (err error) {
+	for _, s := range append(li.sinks[:len(li.sinks):len(li.sinks)], li.fallback) {
+		if f, ok := s.(Flusher); ok {
+			err = errors.Join(err, f.Flush(ctx))
+		}
+	}
+
+	return
+}
+
+// Shutdown is a method that runs the exit handlers, flushes and closes the sinks and closes the Mongo connection.
+// It is safe to call more than once; only the first call has an effect.
+func (li *Lib) Shutdown(ctx context.Context) (err error) {
+	li.exitOnce.Do(func() {
+		li.mu.Lock()
+		handlers := append([]ExitHandler(nil), li.exitHandlers...)
+		li.mu.Unlock()
+
+		for _, h := range handlers {
+			err = errors.Join(err, h(ctx))
+		}
+
+		err = errors.Join(err, li.Flush(ctx))
+
+		for _, s := range append(li.sinks[:len(li.sinks):len(li.sinks)], li.fallback) {
+			if c, ok := s.(io.Closer); ok {
+				err = errors.Join(err, c.Close())
+			}
+		}
+
+		if li.mc != nil {
+			err = errors.Join(err, li.mc.Close(ctx))
+		}
+	})
+
+	return
+}
+
+// runExitHandlers is a method that shuts the Lib down within the configured exit timeout.
+func (li *Lib) runExitHandlers() {
+	ctx, cancel := context.WithTimeout(context.Background(), li.exitTimeout)
+	defer cancel()
+
+	if err := li.Shutdown(ctx); err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "fail to run exit handlers: %v\n", err)
+	}
+}
+
+// flushSinks is a method that flushes the sinks within the configured exit timeout, leaving the Lib open.
+func (li *Lib) flushSinks() {
+	ctx, cancel := context.WithTimeout(context.Background(), li.exitTimeout)
+	defer cancel()
+
+	if err := li.Flush(ctx); err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "fail to flush sinks: %v\n", err)
+	}
+}
+
+// watchSignals is a method that runs the exit handlers and exits when SIGTERM or SIGINT is received.
+func (li *Lib) watchSignals() {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGTERM, syscall.SIGINT)
+
+	go func() {
+		sig := <-ch
+		signal.Stop(ch)
+
+		li.runExitHandlers()
+
+		code := 1
+		if s, ok := sig.(syscall.Signal); ok {
+			code = 128 + int(s)
+		}
+		li.exitFunc(code)
+	}()
+}
@@ -0,0 +1,201 @@
+// Package telemetry provides functionality for telemetry logging.
+package telemetry
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// SinkErrorHandler is a function called when a sink fails to deliver an entry.
+type SinkErrorHandler func(sink string, e *logrus.Entry, err error)
+
+// WithOnSinkError is a function that returns an OptFunc which sets the function called when a sink fails.
+// Once a handler or a fallback sink is set, sink errors are no longer reported by logrus on stderr.
+func WithOnSinkError(fn SinkErrorHandler) OptFunc {
+	return func(li *Lib) (err error) {
+		if fn == nil {
+			return errors.New("sink error handler must not be nil")
+		}
+
+		li.onSinkError = fn
+		return
+	}
+}
+
+// WithSink is a function that returns an OptFunc which adds a sink receiving every entry, next to the Mongo sink.
+func WithSink(h logrus.Hook) OptFunc {
+	return func(li *Lib) (err error) {
+		if h == nil {
+			return errors.New("sink must not be nil")
+		}
+
+		li.sinks = append(li.sinks, h)
+		return
+	}
+}
+
+// WithFallbackSink is a function that returns an OptFunc which sets the sink receiving the entries another sink
+// failed to deliver, for instance &WriterHook{Writer: os.Stderr} or a sink created by NewFileSink.
+func WithFallbackSink(h logrus.Hook) OptFunc {
+	return func(li *Lib) (err error) {
+		if h == nil {
+			return errors.New("fallback sink must not be nil")
+		}
+
+		li.fallback = h
+		return
+	}
+}
+
+// SinkFailures is a method that returns the number of entries each sink failed to deliver, by sink name.
+func (li *Lib) SinkFailures() map[string]uint64 {
+	failures := map[string]uint64{}
+	li.failures.Range(func(k, v any) bool {
+		failures[k.(string)] = v.(*atomic.Uint64).Load()
+		return true
+	})
+
+	return failures
+}
+
+// guardHook is a struct that reports the errors of a sink to the Lib instead of returning them to logrus.
+type guardHook struct {
+	name     string
+	hook     logrus.Hook
+	failures *atomic.Uint64
+	li       *Lib
+}
+
+// guard is a method that wraps a sink so that its failures are counted, and handled when a handler or a fallback is set.
+func (li *Lib) guard(name string, hook logrus.Hook) logrus.Hook {
+	v, _ := li.failures.LoadOrStore(name, new(atomic.Uint64))
+	return &guardHook{name: name, hook: hook, failures: v.(*atomic.Uint64), li: li}
+}
+
+// Name is a method that returns the name of the guarded sink.
+func (g *guardHook) Name() string {
+	return g.name
+}
+
+// Unwrap is a method that returns the guarded hook.
+func (g *guardHook) Unwrap() logrus.Hook {
+	return g.hook
+}
+
+// Levels is a method that returns the levels of the guarded hook.
+func (g *guardHook) Levels() []logrus.Level {
+	return g.hook.Levels()
+}
+
+// Fire is a method that fires the guarded hook and hands its error to the handler and the entry to the fallback sink.
+// The error is returned to logrus only when neither is set. Entries a circuit breaker already sent to its fallback
+// are counted and handed to the handler, but not fired to the fallback sink again.
+func (g *guardHook) Fire(e *logrus.Entry) error {
+	start := time.Now()
+	err := g.hook.Fire(e)
+	if g.li.sinkMetrics != nil {
+		g.li.sinkMetrics.observe(g.name, e, time.Since(start), err)
+	}
+
+	if err == nil {
+		return nil
+	}
+
+	g.failures.Add(1)
+
+	var handled *handledError
+	if errors.As(err, &handled) {
+		if g.li.onSinkError != nil {
+			g.li.onSinkError(g.name, e, handled.err)
+		}
+		return nil
+	}
+
+	if g.li.onSinkError == nil && g.li.fallback == nil {
+		return err
+	}
+
+	if g.li.onSinkError != nil {
+		g.li.onSinkError(g.name, e, err)
+	}
+
+	if g.li.fallback != nil {
+		if ferr := g.li.fallback.Fire(e); ferr != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "telemetry: fallback sink failed after %s failed: %v, %v\n", g.name, err, ferr)
+		}
+	}
+
+	return nil
+}
+
+// WriterHook is a struct that writes formatted entries to an io.Writer, such as stderr or a file.
+type WriterHook struct {
+	Writer    io.Writer        // Writer is where the entries are written.
+	Formatter logrus.Formatter // Formatter formats the entries, the logrus JSON formatter when nil.
+
+	mu   sync.Mutex
+	file *os.File
+}
+
+// NewFileSink is a function that creates a WriterHook appending JSON entries to the file at path.
+// The file is created if needed and closed when the Lib shuts down.
+func NewFileSink(path string) (*WriterHook, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("fail to open file sink: %w", err)
+	}
+
+	return &WriterHook{Writer: f, Formatter: &logrus.JSONFormatter{}, file: f}, nil
+}
+
+// Name is a method that returns the name of the sink.
+func (w *WriterHook) Name() string {
+	if w.file != nil {
+		return "file"
+	}
+
+	return "writer"
+}
+
+// Levels is a method that returns all logrus levels.
+func (w *WriterHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Fire is a method that formats the entry and writes it.
+func (w *WriterHook) Fire(e *logrus.Entry) error {
+	f := w.Formatter
+	if f == nil {
+		f = &logrus.JSONFormatter{}
+	}
+
+	b, err := f.Format(e)
+	if err != nil {
+		return fmt.Errorf("fail to format entry: %w", err)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	_, err = w.Writer.Write(b)
+	return err
+}
+
+// Close is a method that closes the file of a sink created by NewFileSink; other writers are left open.
+func (w *WriterHook) Close() error {
+	if w.file == nil {
+		return nil
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.file.Close()
+}
@@ -0,0 +1,151 @@
+package telemetry_test
+
+import (
+	"bytes"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dyaksa/telemetry-log/telemetry"
+	"github.com/dyaksa/telemetry-log/telemetry/breaker"
+	"github.com/dyaksa/telemetry-log/telemetry/derive"
+	"github.com/dyaksa/telemetry-log/telemetry/metrics"
+	"github.com/sirupsen/logrus"
+)
+
+// failingHook is a sink failing every entry.
+type failingHook struct{}
+
+func (failingHook) Name() string             { return "failing" }
+func (failingHook) Levels() []logrus.Level   { return logrus.AllLevels }
+func (failingHook) Fire(*logrus.Entry) error { return errors.New("unreachable") }
+
+func TestSinkErrorFallback(t *testing.T) {
+	var fallback bytes.Buffer
+	var handled []string
+
+	tl, err := telemetry.New(
+		telemetry.WithMongo(false),
+		telemetry.WithSink(failingHook{}),
+		telemetry.WithFallbackSink(&telemetry.WriterHook{Writer: &fallback}),
+		telemetry.WithOnSinkError(func(sink string, e *logrus.Entry, err error) {
+			handled = append(handled, sink+": "+e.Message+": "+err.Error())
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tl.Log.Info("payment accepted")
+	tl.Log.Warn("payment slow")
+
+	if len(handled) != 2 || handled[0] != "failing: payment accepted: unreachable" {
+		t.Fatalf("unexpected handler calls: %v", handled)
+	}
+	if !strings.Contains(fallback.String(), `"msg":"payment slow"`) {
+		t.Fatalf("entry missing from the fallback sink: %s", fallback.String())
+	}
+	if n := tl.SinkFailures()["failing"]; n != 2 {
+		t.Fatalf("got %d failures counted, want 2", n)
+	}
+}
+
+// funcHook is a sink calling fire for every entry.
+type funcHook func(*logrus.Entry) error
+
+func (funcHook) Levels() []logrus.Level       { return logrus.AllLevels }
+func (h funcHook) Fire(e *logrus.Entry) error { return h(e) }
+
+func TestBreakerHookSurvivesPanickingProbe(t *testing.T) {
+	calls := 0
+	sink := funcHook(func(*logrus.Entry) error {
+		calls++
+		switch calls {
+		case 1:
+			return errors.New("unreachable")
+		case 2:
+			panic("sink bug")
+		}
+		return nil
+	})
+
+	h, err := telemetry.NewBreakerHook("func", sink, nil,
+		breaker.WithFailureThreshold(1), breaker.WithOpenTimeout(10*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := logrus.NewEntry(logrus.New())
+	_ = h.Fire(e)
+	time.Sleep(20 * time.Millisecond)
+
+	func() {
+		defer func() { _ = recover() }()
+		_ = h.Fire(e)
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	if err = h.Fire(e); err != nil || h.State() != breaker.Closed {
+		t.Fatalf("Fire() = %v with the breaker %s after a panicking probe, want a new probe closing it", err, h.State())
+	}
+}
+
+func TestBreakerFailuresReachGuard(t *testing.T) {
+	var fallback bytes.Buffer
+	var handled []string
+
+	tl, err := telemetry.New(
+		telemetry.WithMongo(false),
+		telemetry.WithSink(failingHook{}),
+		telemetry.WithCircuitBreaker(breaker.WithFailureThreshold(1), breaker.WithOpenTimeout(time.Hour)),
+		telemetry.WithFallbackSink(&telemetry.WriterHook{Writer: &fallback}),
+		telemetry.WithOnSinkError(func(sink string, e *logrus.Entry, err error) {
+			handled = append(handled, sink+": "+err.Error())
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tl.Log.Info("payment accepted")
+	tl.Log.Warn("payment slow")
+
+	if n := tl.SinkFailures()["failing"]; n != 2 {
+		t.Fatalf("got %d failures counted, want 2", n)
+	}
+	if len(handled) != 2 || handled[0] != "failing: unreachable" || handled[1] != "failing: "+breaker.ErrOpen.Error() {
+		t.Fatalf("unexpected handler calls: %v", handled)
+	}
+	if n := strings.Count(fallback.String(), "\n"); n != 2 {
+		t.Fatalf("got %d entries in the fallback sink, want each entry once: %s", n, fallback.String())
+	}
+}
+
+func TestBreakerStateChangesStayOnConsole(t *testing.T) {
+	r := metrics.NewRegistry()
+
+	tl, err := telemetry.New(
+		telemetry.WithMongo(false),
+		telemetry.WithSink(failingHook{}),
+		telemetry.WithCircuitBreaker(breaker.WithFailureThreshold(1)),
+		telemetry.WithFallbackSink(&telemetry.WriterHook{Writer: &bytes.Buffer{}}),
+		telemetry.WithMetrics(r),
+		telemetry.WithDerivedMetrics(derive.Rule{Name: "entries_total", GroupBy: []string{"msg"}}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tl.Log.Info("payment accepted")
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.Contains(rec.Body.String(), `msg="payment accepted"`) {
+		t.Fatalf("logged entry missing from the derived metric:\n%s", rec.Body.String())
+	}
+	if strings.Contains(rec.Body.String(), "circuit breaker") {
+		t.Fatalf("breaker state change counted by the derived metric:\n%s", rec.Body.String())
+	}
+}
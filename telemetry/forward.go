@@ -0,0 +1,410 @@
+// Package telemetry provides functionality for telemetry logging.
+package telemetry
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dyaksa/telemetry-log/telemetry/metrics"
+	"github.com/dyaksa/telemetry-log/telemetry/wire"
+	"github.com/sirupsen/logrus"
+)
+
+// These constants are the defaults of a ForwardHook.
+const (
+	DefaultForwardBuffer    = 10000
+	DefaultForwardBatchSize = 200
+)
+
+// These constants bound the behaviour of the forwarding connection.
+const (
+	forwardMaxInFlight    = 16                     // forwardMaxInFlight is the number of batches sent before waiting for acks.
+	forwardFlushInterval  = 200 * time.Millisecond // forwardFlushInterval is the interval after which a partial batch is sent.
+	forwardDialTimeout    = 5 * time.Second
+	forwardWriteTimeout   = 10 * time.Second
+	forwardMinBackoff     = 100 * time.Millisecond
+	forwardMaxBackoff     = 10 * time.Second
+	forwardFlushPollDelay = 10 * time.Millisecond
+
+	// forwardMaxBatchBytes is the encoded size of the entries of a batch, leaving room in the frame for the message.
+	forwardMaxBatchBytes = wire.MaxFrameSize - 4<<10
+)
+
+// WithForwarder is a function that returns an OptFunc which forwards every entry to a collector.
+// The address is tcp://host:port or unix:///path; up to bufferSize entries are held while the collector is unreachable.
+// Combined with WithMongo(false), the service no longer needs the Mongo credentials.
+func WithForwarder(address, apiKey string, bufferSize int) OptFunc {
+	return func(li *Lib) (err error) {
+		f, err := NewForwardHook(address, apiKey, bufferSize)
+		if err != nil {
+			return fmt.Errorf("fail to create forwarder: %w", err)
+		}
+
+		li.sinks = append(li.sinks, f)
+		return
+	}
+}
+
+// WithMongo is a function that returns an OptFunc which determines whether entries are stored in MongoDB directly.
+// When disabled no Mongo connection is made, and entries only reach the other sinks, such as a forwarder.
+func WithMongo(status bool) OptFunc {
+	return func(li *Lib) (err error) {
+		li.withMongo = status
+		return
+	}
+}
+
+// ForwardHook is a struct that streams entries to a collector over a persistent Unix or TCP connection.
+// Entries are sent in numbered batches which are kept until the collector acks them, and sent again after a reconnection.
+type ForwardHook struct {
+	Service     string            // Service is the service set on entries without a service field.
+	Environment string            // Environment is the environment set on entries without an environment field.
+	Metrics     *metrics.Registry // Metrics records the batch sizes and the entries dropped when set.
+
+	network string
+	address string
+	key     string
+
+	batchSize int
+	entries   chan wire.Entry
+	pending   atomic.Int64
+	dropped   atomic.Uint64
+	rejected  atomic.Uint64
+
+	// The fields below are only used by the run goroutine.
+	unacked      []*wire.Message
+	current      []wire.Entry
+	currentBytes int // currentBytes is the encoded size of the current entries.
+	seq          uint64
+
+	flush   chan struct{}
+	stop    chan struct{}
+	stopped chan struct{}
+	once    sync.Once
+}
+
+// NewForwardHook is a function that creates a ForwardHook connecting to address, tcp://host:port or unix:///path.
+// At most bufferSize entries wait to be sent; entries fired while the buffer is full are dropped and counted.
+func NewForwardHook(address, apiKey string, bufferSize int) (*ForwardHook, error) {
+	network, addr, ok := strings.Cut(address, "://")
+	if !ok || (network != "tcp" && network != "unix") || addr == "" {
+		return nil, fmt.Errorf("invalid address %q, expected tcp://host:port or unix:///path", address)
+	}
+
+	if bufferSize <= 0 {
+		bufferSize = DefaultForwardBuffer
+	}
+
+	f := &ForwardHook{
+		network:   network,
+		address:   addr,
+		key:       apiKey,
+		batchSize: DefaultForwardBatchSize,
+		entries:   make(chan wire.Entry, bufferSize),
+		flush:     make(chan struct{}, 1),
+		stop:      make(chan struct{}),
+		stopped:   make(chan struct{}),
+	}
+
+	go f.run()
+	return f, nil
+}
+
+// Fire is a method that queues an entry to be forwarded without waiting for the connection.
+func (f *ForwardHook) Fire(e *logrus.Entry) error {
+	fields := make(map[string]interface{}, len(e.Data)+2)
+	for k, v := range e.Data {
+		if err, ok := v.(error); ok {
+			v = err.Error()
+		}
+		fields[k] = v
+	}
+
+	if _, ok := fields["service"]; !ok && f.Service != "" {
+		fields["service"] = f.Service
+	}
+	if _, ok := fields["environment"]; !ok && f.Environment != "" {
+		fields["environment"] = f.Environment
+	}
+
+	// The entry is counted before it is queued, so a Flush racing with the send cannot miss it.
+	f.pending.Add(1)
+	select {
+	case f.entries <- wire.Entry{Time: e.Time, Level: e.Level.String(), Message: e.Message, Fields: fields}:
+	default:
+		f.pending.Add(-1)
+		f.dropped.Add(1)
+		if f.Metrics != nil {
+			f.Metrics.Counter("telemetry_dropped_total", "Entries dropped, by reason and level or sink.", "reason", "source").Inc("buffer_full", f.Name())
+		}
+	}
+
+	return nil
+}
+
+// Flush is a method that waits until every entry fired so far has been acked by the collector.
+func (f *ForwardHook) Flush(ctx context.Context) error {
+	select {
+	case f.flush <- struct{}{}:
+	default:
+	}
+
+	ticker := time.NewTicker(forwardFlushPollDelay)
+	defer ticker.Stop()
+
+	for f.pending.Load() > 0 {
+		select {
+		case <-ticker.C:
+		case <-f.stopped:
+			return fmt.Errorf("fail to flush %d entries: forwarder is closed", f.pending.Load())
+		case <-ctx.Done():
+			return fmt.Errorf("fail to flush %d entries: %w", f.pending.Load(), ctx.Err())
+		}
+	}
+
+	return nil
+}
+
+// Close is a method that stops the forwarder; entries not acked yet are lost, so Flush should be called first.
+func (f *ForwardHook) Close() error {
+	f.once.Do(func() { close(f.stop) })
+	<-f.stopped
+	return nil
+}
+
+// Dropped is a method that returns the number of entries dropped since the last call because the buffer was full
+// or they were too large for a frame, and resets it.
+func (f *ForwardHook) Dropped() uint64 {
+	return f.dropped.Swap(0)
+}
+
+// Rejected is a method that returns the number of entries the collector refused as invalid since the last call,
+// and resets it.
+func (f *ForwardHook) Rejected() uint64 {
+	return f.rejected.Swap(0)
+}
+
+// Name is a method that returns the name of the sink.
+func (f *ForwardHook) Name() string {
+	return "forward"
+}
+
+// Levels is a method that returns all logrus levels.
+func (f *ForwardHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// run is a method that connects to the collector, with a growing backoff between attempts, until the hook is closed.
+func (f *ForwardHook) run() {
+	defer close(f.stopped)
+
+	backoff := forwardMinBackoff
+	reported := false
+	for {
+		conn, r, err := f.dial()
+		if err == nil {
+			backoff, reported = forwardMinBackoff, false
+			err = f.session(conn, r)
+			_ = conn.Close()
+		}
+
+		select {
+		case <-f.stop:
+			return
+		default:
+		}
+
+		if err != nil && !reported {
+			_, _ = fmt.Fprintf(os.Stderr, "telemetry: collector at %s://%s unavailable, buffering entries: %v\n", f.network, f.address, err)
+			reported = true
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-f.stop:
+			return
+		}
+
+		if backoff *= 2; backoff > forwardMaxBackoff {
+			backoff = forwardMaxBackoff
+		}
+	}
+}
+
+// dial is a method that opens a connection and authenticates with the hello message.
+func (f *ForwardHook) dial() (net.Conn, *bufio.Reader, error) {
+	conn, err := net.DialTimeout(f.network, f.address, forwardDialTimeout)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	r := bufio.NewReader(conn)
+	_ = conn.SetDeadline(time.Now().Add(forwardDialTimeout))
+
+	if err = wire.WriteFrame(conn, &wire.Message{Type: wire.TypeHello, Key: f.key}); err == nil {
+		var ack *wire.Message
+		if ack, err = wire.ReadFrame(r); err == nil && ack.Error != "" {
+			err = errors.New(ack.Error)
+		}
+	}
+
+	if err != nil {
+		_ = conn.Close()
+		return nil, nil, fmt.Errorf("fail to open session: %w", err)
+	}
+
+	_ = conn.SetDeadline(time.Time{})
+	return conn, r, nil
+}
+
+// session is a method that sends the unacked batches again, then new batches, until the connection fails,
+// the collector asks for a batch to be retried, or the hook is closed.
+func (f *ForwardHook) session(conn net.Conn, r *bufio.Reader) error {
+	acks := make(chan *wire.Message)
+	readErr := make(chan error, 1)
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		for {
+			m, err := wire.ReadFrame(r)
+			if err != nil {
+				readErr <- err
+				return
+			}
+
+			select {
+			case acks <- m:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	for _, m := range f.unacked {
+		if err := f.write(conn, m); err != nil {
+			return err
+		}
+	}
+
+	ticker := time.NewTicker(forwardFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		entries := f.entries
+		if len(f.unacked) >= forwardMaxInFlight {
+			entries = nil
+		}
+
+		select {
+		case e := <-entries:
+			if err := f.add(conn, e); err != nil {
+				return err
+			}
+		case <-ticker.C:
+			if err := f.send(conn); err != nil {
+				return err
+			}
+		case <-f.flush:
+			if err := f.send(conn); err != nil {
+				return err
+			}
+		case m := <-acks:
+			if err := f.acked(m); err != nil {
+				return err
+			}
+		case err := <-readErr:
+			return err
+		case <-f.stop:
+			return nil
+		}
+	}
+}
+
+// add is a method that adds an entry to the current batch, sending the batch first when the entry would make it
+// exceed the frame size, and after when it is full. An entry too large for a frame on its own is dropped, since it
+// would otherwise be sent again on every reconnection and hold back every entry behind it.
+func (f *ForwardHook) add(conn net.Conn, e wire.Entry) error {
+	b, err := e.MarshalJSON()
+	if err != nil || len(b) > forwardMaxBatchBytes {
+		f.pending.Add(-1)
+		f.dropped.Add(1)
+		if f.Metrics != nil {
+			f.Metrics.Counter("telemetry_dropped_total", "Entries dropped, by reason and level or sink.", "reason", "source").Inc("too_large", f.Name())
+		}
+		return nil
+	}
+
+	// Entries are separated by a comma in the encoded batch.
+	size := len(b) + 1
+	if f.currentBytes+size > forwardMaxBatchBytes {
+		if err = f.send(conn); err != nil {
+			return err
+		}
+	}
+
+	f.current = append(f.current, e)
+	f.currentBytes += size
+	if len(f.current) >= f.batchSize {
+		return f.send(conn)
+	}
+
+	return nil
+}
+
+// send is a method that sends the entries collected so far as a new batch.
+func (f *ForwardHook) send(conn net.Conn) error {
+	if len(f.current) == 0 {
+		return nil
+	}
+
+	if f.Metrics != nil {
+		f.Metrics.Histogram("telemetry_batch_size", "Entries per batch written, by sink.", metrics.SizeBuckets, "sink").Observe(float64(len(f.current)), f.Name())
+	}
+
+	f.seq++
+	m := &wire.Message{Type: wire.TypeBatch, Seq: f.seq, Entries: f.current}
+	f.unacked = append(f.unacked, m)
+	f.current, f.currentBytes = nil, 0
+
+	return f.write(conn, m)
+}
+
+// write is a method that writes a message within the write timeout.
+func (f *ForwardHook) write(conn net.Conn, m *wire.Message) error {
+	_ = conn.SetWriteDeadline(time.Now().Add(forwardWriteTimeout))
+	return wire.WriteFrame(conn, m)
+}
+
+// acked is a method that releases the batch acknowledged by m.
+// A batch rejected with retry set is kept, and an error returned so that it is sent again after a backoff.
+func (f *ForwardHook) acked(m *wire.Message) error {
+	for i, b := range f.unacked {
+		if b.Seq != m.Seq {
+			continue
+		}
+
+		if m.Error != "" && m.Retry {
+			return fmt.Errorf("collector asked to retry: %s", m.Error)
+		}
+
+		if m.Error != "" {
+			f.rejected.Add(uint64(len(b.Entries)))
+			_, _ = fmt.Fprintf(os.Stderr, "telemetry: collector rejected %d entries: %s\n", len(b.Entries), m.Error)
+		}
+
+		f.pending.Add(-int64(len(b.Entries)))
+		f.unacked = append(f.unacked[:i], f.unacked[i+1:]...)
+		return nil
+	}
+
+	return nil
+}
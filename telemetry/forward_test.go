@@ -0,0 +1,125 @@
+package telemetry_test
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dyaksa/telemetry-log/telemetry"
+	"github.com/dyaksa/telemetry-log/telemetry/collector"
+	"github.com/dyaksa/telemetry-log/telemetry/wire"
+	"github.com/sirupsen/logrus"
+)
+
+// memWriter is a collector.BatchWriter keeping the entries in memory.
+type memWriter struct {
+	mu      sync.Mutex
+	entries []*logrus.Entry
+}
+
+func (w *memWriter) WriteBatch(_ context.Context, entries []*logrus.Entry) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.entries = append(w.entries, entries...)
+	return nil
+}
+
+func TestForwardHookBuffersUntilCollectorIsUp(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	_ = l.Close()
+
+	f, err := telemetry.NewForwardHook("tcp://"+addr, "secret", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	f.Service = "billing"
+
+	for i := 0; i < 10; i++ {
+		_ = f.Fire(&logrus.Entry{Time: time.Now(), Level: logrus.InfoLevel, Message: "queued", Data: logrus.Fields{"i": i}})
+	}
+
+	w := &memWriter{}
+	s, err := collector.New(w, collector.WithAPIKey("secret", ""), collector.WithBatchSize(100, 10*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Run(ctx)
+
+	if l, err = net.Listen("tcp", addr); err != nil {
+		t.Fatal(err)
+	}
+	go func() { _ = s.Serve(ctx, l) }()
+
+	flushCtx, flushCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer flushCancel()
+	if err = f.Flush(flushCtx); err != nil {
+		t.Fatal(err)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.entries) != 10 {
+		t.Fatalf("got %d entries stored, want 10", len(w.entries))
+	}
+	if w.entries[0].Data["service"] != "billing" {
+		t.Fatalf("service not forwarded: %v", w.entries[0].Data)
+	}
+}
+
+func TestForwardHookSplitsLargeBatches(t *testing.T) {
+	w := &memWriter{}
+	s, err := collector.New(w, collector.WithAPIKey("secret", ""), collector.WithBatchSize(100, 10*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Run(ctx)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() { _ = s.Serve(ctx, l) }()
+
+	f, err := telemetry.NewForwardHook("tcp://"+l.Addr().String(), "secret", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	// The oversized entry cannot fit in a frame, the others only fit a few per frame.
+	_ = f.Fire(&logrus.Entry{Time: time.Now(), Level: logrus.InfoLevel, Message: strings.Repeat("x", wire.MaxFrameSize+1)})
+	for i := 0; i < 4; i++ {
+		_ = f.Fire(&logrus.Entry{Time: time.Now(), Level: logrus.InfoLevel, Message: strings.Repeat("y", 5<<20)})
+	}
+	_ = f.Fire(&logrus.Entry{Time: time.Now(), Level: logrus.InfoLevel, Message: "small"})
+
+	flushCtx, flushCancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer flushCancel()
+	if err = f.Flush(flushCtx); err != nil {
+		t.Fatal(err)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.entries) != 5 {
+		t.Fatalf("got %d entries stored, want 5", len(w.entries))
+	}
+	if n := f.Dropped(); n != 1 {
+		t.Fatalf("got %d entries dropped, want the oversized one", n)
+	}
+}
@@ -4,48 +4,282 @@ package telemetry
 import (
 	"context"
 	"fmt"
+	"sync"
 	"time"
 
+	"github.com/dyaksa/telemetry-log/telemetry/metrics"
 	"github.com/dyaksa/telemetry-log/telemetry/mongo"
+	"github.com/dyaksa/telemetry-log/telemetry/spool"
 	"github.com/sirupsen/logrus"
 	"go.mongodb.org/mongo-driver/bson"
 )
 
+// These constants are the collection names used when none are configured.
+const (
+	DefaultTraceCollection = "application_trace"
+	DefaultLogCollection   = "application_log"
+	DefaultCrashCollection = "application_crash"
+)
+
 // MongoHook is a struct that holds the necessary information for a MongoDB hook.
 type MongoHook struct {
 	Client   *mongo.Mongo  // Client is a pointer to a Mongo instance.
 	Timeout  time.Duration // Timeout is the duration before the hook times out.
 	WithHook bool          // WithHook is a boolean that determines whether the hook is active.
+
+	Database        string // Database is the name template of the database, the client's default when empty.
+	TraceCollection string // TraceCollection is the name template of the collection error entries go to.
+	LogCollection   string // LogCollection is the name template of the collection other entries go to.
+	Service         string // Service is the name of the service stored with every entry.
+	Environment     string // Environment is the name of the environment stored with every entry.
+
+	AutoIndex           bool                     // AutoIndex is a boolean that determines whether indexes are created on first use of a collection.
+	LevelRetention      map[string]time.Duration // LevelRetention is how long entries are kept, by level as logrus names it.
+	CollectionRetention map[string]time.Duration // CollectionRetention is how long entries are kept, by collection template.
+	TimeSeries          *TimeSeries              // TimeSeries holds the time-series settings, regular collections are used when nil.
+	Host                string                   // Host is the name of the host stored in the meta field of time-series entries.
+	Spool               *spool.Spool             // Spool keeps the entries that failed to be stored because Mongo was unreachable.
+	Metrics             *metrics.Registry        // Metrics records the Mongo errors and batch sizes when set.
+
+	ensured sync.Map
+}
+
+// entryRoute is a struct that holds where an entry is stored.
+type entryRoute struct {
+	database   string
+	collection string
+	template   string
+	level      string
+	time       time.Time
+	timeSeries bool
 }
 
 // Fire is a method that logs an entry to a MongoDB collection.
-// If the entry level is "error" and the hook is active, it logs the entry to the "application_trace" collection.
-// Otherwise, it logs a sample entry to the "application_log" collection.
+// If the entry level is "error" and the hook is active, it logs the entry to the trace collection.
+// Otherwise, it logs a sample entry to the log collection.
+// When a spool is set, entries Mongo failed to store are spooled instead, and the error is not returned.
 func (m *MongoHook) Fire(e *logrus.Entry) error {
-	switch {
-	case e.Level.String() == logrus.ErrorLevel.String() && m.WithHook:
-		_, err := m.Client.Collection("application_trace").InsertOne(context.TODO(), bson.D{
+	r := m.route(e)
+
+	if m.spooling() {
+		return m.spool(r, m.document(e, r))
+	}
+
+	ctx, cancel := m.context()
+	defer cancel()
+
+	if m.AutoIndex || m.TimeSeries != nil {
+		var err error
+		if r.timeSeries, err = m.prepare(ctx, r.database, r.collection); err != nil {
+			return m.fallback(r, []interface{}{m.document(e, r)}, err)
+		}
+	}
+
+	doc := m.document(e, r)
+	if _, err := m.Client.CollectionIn(r.database, r.collection).InsertOne(ctx, doc); err != nil {
+		return m.fallback(r, []interface{}{doc}, err)
+	}
+
+	return nil
+}
+
+// context is a method that returns the context of a write, bounded by Timeout when it is set.
+func (m *MongoHook) context() (context.Context, context.CancelFunc) {
+	if m.Timeout > 0 {
+		return context.WithTimeout(context.Background(), m.Timeout)
+	}
+
+	return context.WithCancel(context.Background())
+}
+
+// WriteBatch is a method that stores entries with one insert per collection rather than one per entry.
+// Entries are routed, prepared and spooled exactly as Fire does.
+func (m *MongoHook) WriteBatch(ctx context.Context, entries []*logrus.Entry) error {
+	type target struct{ database, collection string }
+
+	var order []target
+	routes := map[target]*entryRoute{}
+	docs := map[target][]interface{}{}
+	for _, e := range entries {
+		r := m.route(e)
+		t := target{database: r.database, collection: r.collection}
+
+		if m.AutoIndex || m.TimeSeries != nil {
+			var err error
+			if r.timeSeries, err = m.prepare(ctx, r.database, r.collection); err != nil {
+				if err = m.fallback(r, []interface{}{m.document(e, r)}, err); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+
+		if _, ok := docs[t]; !ok {
+			order = append(order, t)
+			routes[t] = r
+		}
+		docs[t] = append(docs[t], m.document(e, r))
+	}
+
+	if m.Metrics != nil {
+		m.Metrics.Histogram("telemetry_batch_size", "Entries per batch written, by sink.", metrics.SizeBuckets, "sink").Observe(float64(len(entries)), "mongo")
+	}
+
+	for _, t := range order {
+		if m.spooling() {
+			for _, doc := range docs[t] {
+				if err := m.spool(routes[t], doc); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		if _, err := m.Client.CollectionIn(t.database, t.collection).InsertMany(ctx, docs[t]); err != nil {
+			if err = m.fallback(routes[t], docs[t], err); err != nil {
+				return fmt.Errorf("fail to insert into %s: %w", t.collection, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// isTrace is a method that reports whether an entry goes to the trace collection.
+func (m *MongoHook) isTrace(e *logrus.Entry) bool {
+	return e.Level.String() == logrus.ErrorLevel.String() && m.WithHook
+}
+
+// route is a method that returns the database and collection an entry is stored in.
+func (m *MongoHook) route(e *logrus.Entry) *entryRoute {
+	vars := nameVars{service: m.service(e), environment: m.environment(e), level: e.Level.String(), time: e.Time}
+
+	tmpl := m.LogCollection
+	if tmpl == "" {
+		tmpl = DefaultLogCollection
+	}
+
+	if m.isTrace(e) {
+		tmpl = m.TraceCollection
+		if tmpl == "" {
+			tmpl = DefaultTraceCollection
+		}
+	}
+
+	return &entryRoute{
+		database:   expandName(m.Database, vars),
+		collection: expandName(tmpl, vars),
+		template:   tmpl,
+		level:      e.Level.String(),
+		time:       e.Time,
+	}
+}
+
+// document is a method that builds the document stored for an entry.
+func (m *MongoHook) document(e *logrus.Entry, r *entryRoute) bson.D {
+	var doc bson.D
+	if m.isTrace(e) {
+		doc = bson.D{
 			{"level", e.Level.String()},
 			{"trace_date", e.Time},
 			{"func", e.Data["func"]},
 			{"file", fmt.Sprintf("%s:%d", e.Data["file"], e.Data["line"])},
 			{"trace", e.Data["trace"]},
-		})
-		if err != nil {
-			return err
 		}
-	default:
-		_, err := m.Client.Collection("application_log").InsertOne(context.TODO(), bson.D{
+	} else {
+		doc = bson.D{
+			{Key: "level", Value: e.Level.String()},
 			{"trace_date", e.Time},
 			{"func", e.Data["func"]},
 			{"file", fmt.Sprintf("%s:%d", e.Data["file"], e.Data["line"])},
-		})
-		if err != nil {
-			return err
 		}
-		return nil
 	}
-	return nil
+
+	if service := m.service(e); service != "" {
+		doc = append(doc, bson.E{Key: "service", Value: service})
+	}
+	if environment := m.environment(e); environment != "" {
+		doc = append(doc, bson.E{Key: "environment", Value: environment})
+	}
+
+	doc = append(doc, bson.E{Key: "message", Value: e.Message})
+
+	for _, key := range []string{"request_id", "trace_id"} {
+		if v, ok := e.Data[key]; ok {
+			doc = append(doc, bson.E{Key: key, Value: fmt.Sprint(v)})
+		}
+	}
+
+	if fields := entryFields(e); len(fields) > 0 {
+		doc = append(doc, bson.E{Key: "fields", Value: fields})
+	}
+
+	if r.timeSeries {
+		doc = append(doc, bson.E{Key: "meta", Value: bson.D{
+			{Key: "service", Value: m.service(e)},
+			{Key: "level", Value: e.Level.String()},
+			{Key: "host", Value: m.Host},
+		}})
+	} else if at, ok := m.expireAt(r); ok {
+		doc = append(doc, bson.E{Key: "expire_at", Value: at})
+	}
+
+	return doc
+}
+
+// service is a method that returns the service of an entry, its "service" field taking precedence over Service.
+// Entries received by the collector carry the service of their sender this way.
+func (m *MongoHook) service(e *logrus.Entry) string {
+	if s, ok := e.Data["service"].(string); ok && s != "" {
+		return s
+	}
+
+	return m.Service
+}
+
+// environment is a method that returns the environment of an entry, its "environment" field taking precedence over Environment.
+func (m *MongoHook) environment(e *logrus.Entry) string {
+	if s, ok := e.Data["environment"].(string); ok && s != "" {
+		return s
+	}
+
+	return m.Environment
+}
+
+// reservedFields are the entry fields stored as top-level document keys rather than under "fields".
+var reservedFields = map[string]bool{
+	"func": true, "file": true, "line": true, "trace": true,
+	"request_id": true, "trace_id": true, "service": true, "environment": true,
+}
+
+// entryFields is a function that returns the custom fields of an entry, with errors stored as their message.
+// Values BSON cannot encode, such as functions, channels or structs holding them, are stored as their text,
+// so that one field cannot make the whole entry fail to be stored.
+func entryFields(e *logrus.Entry) bson.M {
+	fields := bson.M{}
+	for k, v := range e.Data {
+		if reservedFields[k] {
+			continue
+		}
+
+		switch x := v.(type) {
+		case nil, string, bool, int, int32, int64, float64, time.Time:
+		case error:
+			v = x.Error()
+		default:
+			if _, _, err := bson.MarshalValue(v); err != nil {
+				v = fmt.Sprintf("%+v", v)
+			}
+		}
+		fields[k] = v
+	}
+
+	return fields
+}
+
+// Name is a method that returns the name of the sink.
+func (m *MongoHook) Name() string {
+	return "mongo"
 }
 
 // Levels is a method that returns all logrus levels.
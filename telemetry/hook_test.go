@@ -0,0 +1,134 @@
+package telemetry
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dyaksa/telemetry-log/cmd"
+	"github.com/dyaksa/telemetry-log/telemetry/metrics"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestRoute(t *testing.T) {
+	m := &MongoHook{WithHook: true, Database: "logs_{{service}}", Service: "billing", LogCollection: "{{level}}_{{yyyy_mm}}"}
+	at := time.Date(2024, 6, 14, 10, 0, 0, 0, time.UTC)
+
+	tests := map[logrus.Level][2]string{
+		logrus.ErrorLevel: {DefaultTraceCollection, "error"},
+		logrus.PanicLevel: {"panic_2024_06", "panic"},
+		logrus.TraceLevel: {"trace_2024_06", "trace"},
+		logrus.WarnLevel:  {"warning_2024_06", "warning"},
+	}
+
+	for lvl, want := range tests {
+		r := m.route(&logrus.Entry{Level: lvl, Time: at, Data: logrus.Fields{}})
+		if r.database != "logs_billing" || r.collection != want[0] || r.level != want[1] {
+			t.Errorf("route(%s) = %s.%s at %s, want logs_billing.%s at %s", lvl, r.database, r.collection, r.level, want[0], want[1])
+		}
+	}
+}
+
+func TestExpireAt(t *testing.T) {
+	m := &MongoHook{
+		LevelRetention:      levelRetention(map[string]time.Duration{"warn": time.Hour, "panic": 2 * time.Hour}),
+		CollectionRetention: map[string]time.Duration{DefaultLogCollection: 24 * time.Hour},
+	}
+	at := time.Date(2024, 6, 14, 10, 0, 0, 0, time.UTC)
+
+	tests := map[string]time.Duration{"warning": time.Hour, "panic": 2 * time.Hour, "info": 24 * time.Hour}
+	for level, want := range tests {
+		got, ok := m.expireAt(&entryRoute{level: level, template: DefaultLogCollection, time: at})
+		if !ok || !got.Equal(at.Add(want)) {
+			t.Errorf("expireAt(%s) = %s, %v, want %s", level, got, ok, at.Add(want))
+		}
+	}
+
+	if _, ok := m.expireAt(&entryRoute{level: "info", template: DefaultTraceCollection, time: at}); ok {
+		t.Error("expireAt applied a retention to a collection without one")
+	}
+}
+
+func TestCrashDocument(t *testing.T) {
+	at := time.Date(2024, 6, 14, 10, 0, 0, 0, time.UTC)
+	b, err := bson.Marshal(crashDocument{Diagnostics: cmd.Diagnostics{Message: "bye", Time: at}, ExpireAt: at.Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var doc bson.M
+	if err = bson.Unmarshal(b, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc["message"] != "bye" || doc["expire_at"] == nil {
+		t.Fatalf("unexpected crash document: %v", doc)
+	}
+}
+
+func TestMongoHookMetrics(t *testing.T) {
+	r := metrics.NewRegistry()
+	li, err := Connect(WithMongo(false), WithMetrics(r))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := li.MongoHook().Metrics; got != r {
+		t.Errorf("MongoHook().Metrics = %p, want the registry given to WithMetrics %p", got, r)
+	}
+}
+
+func TestEntryFieldsEncodable(t *testing.T) {
+	type request struct {
+		Path    string
+		GetBody func() error
+	}
+	e := &logrus.Entry{Level: logrus.InfoLevel, Data: logrus.Fields{
+		"func":     "main.pay",
+		"amount":   1250,
+		"callback": func() {},
+		"done":     make(chan struct{}),
+		"request":  &request{Path: "/pay"},
+		"tags":     []string{"a", "b"},
+	}}
+
+	m := &MongoHook{}
+	b, err := bson.Marshal(m.document(e, m.route(e)))
+	if err != nil {
+		t.Fatalf("the entry cannot be stored: %v", err)
+	}
+
+	var doc struct {
+		Fields bson.M `bson:"fields"`
+	}
+	if err = bson.Unmarshal(b, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.Fields["amount"] != int32(1250) || len(doc.Fields["tags"].(bson.A)) != 2 {
+		t.Errorf("encodable fields were converted: %v", doc.Fields)
+	}
+	if s, ok := doc.Fields["request"].(string); !ok || s == "" {
+		t.Errorf("request not stored as text: %v", doc.Fields["request"])
+	}
+}
+
+func TestTimeSeriesMetaService(t *testing.T) {
+	m := &MongoHook{WithHook: true, Service: "collector", Host: "collector-0"}
+	e := &logrus.Entry{Level: logrus.InfoLevel, Time: time.Now(), Message: "paid", Data: logrus.Fields{"service": "billing"}}
+
+	var got struct {
+		Meta struct {
+			Service string `bson:"service"`
+		} `bson:"meta"`
+	}
+	b, err := bson.Marshal(m.document(e, &entryRoute{timeSeries: true}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = bson.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+
+	if got.Meta.Service != "billing" {
+		t.Fatalf("meta.service = %q, want the service of the entry", got.Meta.Service)
+	}
+}
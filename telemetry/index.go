@@ -0,0 +1,185 @@
+// Package telemetry provides functionality for telemetry logging.
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// WithIndexes is a function that returns an OptFunc which creates the indexes of the telemetry collections.
+// Indexes are ensured at startup and then whenever a templated collection name rolls over.
+func WithIndexes(status bool) OptFunc {
+	return func(li *Lib) (err error) {
+		li.autoIndex = status
+		return
+	}
+}
+
+// WithRetention is a function that returns an OptFunc which expires the entries of a collection after d.
+// The collection is given by its name template, as passed to WithCollections.
+func WithRetention(collection string, d time.Duration) OptFunc {
+	return func(li *Lib) (err error) {
+		if d <= 0 {
+			return fmt.Errorf("invalid retention for %s: %s", collection, d)
+		}
+
+		if li.CollectionRetention == nil {
+			li.CollectionRetention = map[string]time.Duration{}
+		}
+		li.CollectionRetention[collection] = d
+		return
+	}
+}
+
+// WithLevelRetention is a function that returns an OptFunc which expires the entries of a level after d.
+// It takes precedence over the retention of the collection, e.g. debug entries kept 3 days and errors 90 days.
+// Every logrus level is accepted, trace and panic included.
+func WithLevelRetention(level string, d time.Duration) OptFunc {
+	return func(li *Lib) (err error) {
+		lvl, err := logrus.ParseLevel(level)
+		if err != nil {
+			return fmt.Errorf("invalid retention level: %s", level)
+		}
+		if d <= 0 {
+			return fmt.Errorf("invalid retention for %s: %s", level, d)
+		}
+
+		if li.LevelRetention == nil {
+			li.LevelRetention = map[string]time.Duration{}
+		}
+		li.LevelRetention[lvl.String()] = d
+		return
+	}
+}
+
+// levelRetention is a function that returns retention with its levels named as logrus names them,
+// so that "warn" read from the environment applies to entries logged at "warning".
+func levelRetention(retention map[string]time.Duration) map[string]time.Duration {
+	if len(retention) == 0 {
+		return retention
+	}
+
+	named := make(map[string]time.Duration, len(retention))
+	for level, d := range retention {
+		if lvl, err := logrus.ParseLevel(level); err == nil {
+			level = lvl.String()
+		}
+		named[level] = d
+	}
+
+	return named
+}
+
+// crashIndexes are the indexes of the crash collection, created when a retention applies to it.
+var crashIndexes = []mongo.IndexModel{
+	{Keys: bson.D{{Key: "expire_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+}
+
+// EnsureIndexes is a method that sets up the current trace and log collections.
+// It creates their indexes, whether WithIndexes is set or not, and the collections themselves when time series
+// are enabled.
+func (li *Lib) EnsureIndexes(ctx context.Context) (err error) {
+	if li.mc == nil {
+		return errors.New("indexes require a Mongo connection")
+	}
+
+	m := li.MongoHook()
+	vars := nameVars{service: li.Service, environment: li.Environment, time: time.Now()}
+
+	for _, level := range []string{"debug", "info", "warning", "error", "fatal", "panic"} {
+		vars.level = level
+		database := expandName(li.Database, vars)
+
+		for _, tmpl := range []string{li.TraceCollection, li.LogCollection} {
+			collection := expandName(tmpl, vars)
+
+			var timeSeries bool
+			if timeSeries, err = m.setup(ctx, database, collection, true); err != nil {
+				return
+			}
+			m.ensured.Store(database+"."+collection, timeSeries)
+		}
+	}
+
+	return
+}
+
+// entryIndexes are the indexes of every telemetry collection.
+// The TTL index only removes documents with an expire_at date, set when a retention applies.
+var entryIndexes = []mongo.IndexModel{
+	{Keys: bson.D{{Key: "trace_date", Value: -1}}},
+	{Keys: bson.D{{Key: "level", Value: 1}, {Key: "trace_date", Value: -1}}},
+	{Keys: bson.D{{Key: "service", Value: 1}, {Key: "trace_date", Value: -1}}},
+	{Keys: bson.D{{Key: "func", Value: 1}, {Key: "trace_date", Value: -1}}},
+	{Keys: bson.D{{Key: "request_id", Value: 1}}, Options: options.Index().SetSparse(true)},
+	{Keys: bson.D{{Key: "trace_id", Value: 1}}, Options: options.Index().SetSparse(true)},
+	{Keys: bson.D{{Key: "expire_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+}
+
+// statsIndexes are the indexes of the stats collection.
+var statsIndexes = []mongo.IndexModel{
+	{Keys: bson.D{{Key: "service", Value: 1}, {Key: "granularity", Value: 1}, {Key: "start", Value: -1}}},
+	{Keys: bson.D{{Key: "expire_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+}
+
+// prepare is a method that sets a collection up once per process and reports whether it is a time-series one.
+// It creates the time-series collection when enabled, then its indexes when AutoIndex is set.
+func (m *MongoHook) prepare(ctx context.Context, database, collection string) (bool, error) {
+	key := database + "." + collection
+	if v, ok := m.ensured.Load(key); ok {
+		return v.(bool), nil
+	}
+
+	timeSeries, err := m.setup(ctx, database, collection, m.AutoIndex)
+	if err != nil {
+		return false, err
+	}
+
+	m.ensured.Store(key, timeSeries)
+	return timeSeries, nil
+}
+
+// setup is a method that creates the time-series collection when enabled, then the indexes of the collection when
+// indexes is set, and reports whether it is a time-series one.
+func (m *MongoHook) setup(ctx context.Context, database, collection string, indexes bool) (bool, error) {
+	var timeSeries bool
+	if m.TimeSeries != nil {
+		var err error
+		if timeSeries, err = m.createTimeSeries(ctx, database, collection); err != nil {
+			return false, err
+		}
+	}
+
+	if indexes {
+		models := entryIndexes
+		if timeSeries {
+			models = timeSeriesIndexes
+		}
+
+		if _, err := m.Client.CollectionIn(database, collection).Indexes().CreateMany(ctx, models); err != nil {
+			return false, fmt.Errorf("fail to create indexes on %s.%s: %w", database, collection, err)
+		}
+	}
+
+	return timeSeries, nil
+}
+
+// expireAt is a method that returns when a routed entry expires, or false if it never does.
+func (m *MongoHook) expireAt(r *entryRoute) (time.Time, bool) {
+	d, ok := m.LevelRetention[r.level]
+	if !ok {
+		d, ok = m.CollectionRetention[r.template]
+	}
+	if !ok {
+		return time.Time{}, false
+	}
+
+	return r.time.Add(d), true
+}
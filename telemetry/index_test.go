@@ -0,0 +1,83 @@
+package telemetry
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+// testMongoURI is a function that returns the URI of the MongoDB server used by the tests needing one,
+// skipping the test when TELEMETRY_TEST_MONGO_URI is not set.
+func testMongoURI(t *testing.T) string {
+	t.Helper()
+
+	uri := os.Getenv("TELEMETRY_TEST_MONGO_URI")
+	if uri == "" {
+		t.Skip("TELEMETRY_TEST_MONGO_URI is not set")
+	}
+	return uri
+}
+
+// connectTest is a function that connects a Lib to the test server, in a database dropped when the test ends.
+func connectTest(t *testing.T, opts ...OptFunc) *Lib {
+	t.Helper()
+
+	t.Setenv("TELEMETRY_MONGO_URI", testMongoURI(t))
+	t.Setenv("TELEMETRY_DATABASE", fmt.Sprintf("telemetry_test_%d", time.Now().UnixNano()))
+
+	li, err := Connect(opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		ctx := context.Background()
+		_ = li.Mongo().Database(li.Database).Drop(ctx)
+		_ = li.Mongo().Close(ctx)
+	})
+
+	return li
+}
+
+func TestEnsureIndexesWithoutMongo(t *testing.T) {
+	li, err := Connect(WithMongo(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = li.EnsureIndexes(context.Background()); err == nil {
+		t.Fatal("EnsureIndexes() succeeded without a Mongo connection")
+	}
+}
+
+func TestEnsureIndexes(t *testing.T) {
+	li := connectTest(t)
+	ctx := context.Background()
+
+	if err := li.EnsureIndexes(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	cursor, err := li.Mongo().CollectionIn(li.Database, li.LogCollection).Indexes().List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var indexes []struct {
+		Name string `bson:"name"`
+	}
+	if err = cursor.All(ctx, &indexes); err != nil {
+		t.Fatal(err)
+	}
+
+	found := map[string]bool{}
+	for _, idx := range indexes {
+		found[idx.Name] = true
+	}
+	for _, name := range []string{"trace_date_-1", "level_1_trace_date_-1", "expire_at_1"} {
+		if !found[name] {
+			t.Errorf("index %s missing, got %v", name, indexes)
+		}
+	}
+}
@@ -2,16 +2,32 @@
 package telemetry
 
 import (
+	"context"
 	"fmt"
+	"os"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/dyaksa/telemetry-log/cmd"
+	"github.com/dyaksa/telemetry-log/telemetry/alert"
+	"github.com/dyaksa/telemetry-log/telemetry/breaker"
+	"github.com/dyaksa/telemetry-log/telemetry/derive"
 	"github.com/dyaksa/telemetry-log/telemetry/log"
+	"github.com/dyaksa/telemetry-log/telemetry/metrics"
 	"github.com/dyaksa/telemetry-log/telemetry/mongo"
+	"github.com/dyaksa/telemetry-log/telemetry/redact"
+	"github.com/sirupsen/logrus"
 )
 
 type Fields map[string]interface{}
 
+// These constants are the credentials used when none are configured.
+const (
+	defaultUsername = "username"
+	defaultPassword = "password"
+)
+
 // OptFunc is a type that defines a function that modifies a Lib instance.
 type OptFunc func(*Lib) error
 
@@ -21,17 +37,96 @@ type Lib struct {
 	Host     string `env:"TELEMETRY_HOST" envDefault:"127.0.0.1" json:"host"`
 	Port     string `env:"TELEMETRY_PORT" envDefault:"27017" json:"port"`
 	Username string `env:"TELEMETRY_USERNAME" envDefault:"username" json:"username"`
-	Password string `env:"TELEMETRY_PASSWORD" envDefault:"password" json:"password"`
+	Password Secret `env:"TELEMETRY_PASSWORD" envDefault:"password" json:"password"`
+
+	// PasswordFile is the path of a file holding the password, as mounted by Docker or Kubernetes secrets.
+	// When set, it takes precedence over Password.
+	PasswordFile string `env:"TELEMETRY_PASSWORD_FILE" json:"password_file"`
+
+	// URI is a full MongoDB connection string, mongodb:// or mongodb+srv://, used instead of Host and Port.
+	URI                    Secret        `env:"TELEMETRY_MONGO_URI" json:"mongo_uri"`
+	AuthSource             string        `env:"TELEMETRY_MONGO_AUTH_SOURCE" json:"auth_source"`
+	AuthMechanism          string        `env:"TELEMETRY_MONGO_AUTH_MECHANISM" json:"auth_mechanism"`
+	TLSCAFile              string        `env:"TELEMETRY_MONGO_TLS_CA_FILE" json:"tls_ca_file"`
+	TLSCertFile            string        `env:"TELEMETRY_MONGO_TLS_CERT_FILE" json:"tls_cert_file"`
+	TLSKeyFile             string        `env:"TELEMETRY_MONGO_TLS_KEY_FILE" json:"tls_key_file"`
+	AppName                string        `env:"TELEMETRY_MONGO_APP_NAME" json:"app_name"`
+	MinPoolSize            uint64        `env:"TELEMETRY_MONGO_MIN_POOL_SIZE" json:"min_pool_size"`
+	MaxPoolSize            uint64        `env:"TELEMETRY_MONGO_MAX_POOL_SIZE" json:"max_pool_size"`
+	WriteConcern           string        `env:"TELEMETRY_MONGO_WRITE_CONCERN" json:"write_concern"`
+	ServerSelectionTimeout time.Duration `env:"TELEMETRY_MONGO_SERVER_SELECTION_TIMEOUT" json:"server_selection_timeout"`
+
+	// Database and the collection names are templates, see WithDatabase.
+	Service         string `env:"TELEMETRY_SERVICE" json:"service"`
+	Environment     string `env:"TELEMETRY_ENVIRONMENT" json:"environment"`
+	Database        string `env:"TELEMETRY_DATABASE" envDefault:"telemetry" json:"database"`
+	TraceCollection string `env:"TELEMETRY_TRACE_COLLECTION" envDefault:"application_trace" json:"trace_collection"`
+	LogCollection   string `env:"TELEMETRY_LOG_COLLECTION" envDefault:"application_log" json:"log_collection"`
+	CrashCollection string `env:"TELEMETRY_CRASH_COLLECTION" envDefault:"application_crash" json:"crash_collection"`
+	StatsCollection string `env:"TELEMETRY_STATS_COLLECTION" envDefault:"application_stats" json:"stats_collection"`
+
+	// LevelRetention and CollectionRetention are read as "debug:72h,error:2160h".
+	LevelRetention      map[string]time.Duration `env:"TELEMETRY_LEVEL_RETENTION" json:"level_retention"`
+	CollectionRetention map[string]time.Duration `env:"TELEMETRY_COLLECTION_RETENTION" json:"collection_retention"`
 
 	Log log.Logger
 
-	withHook bool
-	mc       *mongo.Mongo
+	withHook   bool
+	withMongo  bool
+	autoIndex  bool
+	timeSeries *TimeSeries
+	stats      *Stats
+	crashDump  int
+	rateLimit  float64
+	rateBurst  int
+	spoolDir   string
+	spoolSize  int64
+	mc         *mongo.Mongo
+	hook       *MongoHook
+	sinks      []logrus.Hook
+
+	withBreaker bool
+	breakerOpts []breaker.OptFunc
+	onSinkError SinkErrorHandler
+	fallback    logrus.Hook
+	failures    sync.Map
+	metrics     *metrics.Registry
+	sinkMetrics *sinkMetrics
+
+	derivedRules   []derive.Rule
+	rollupInterval time.Duration
+	onRollup       func([]derive.Rollup)
+
+	alerts []*alert.Engine
+
+	mu            sync.Mutex
+	exitOnce      sync.Once
+	exitHandlers  []ExitHandler
+	exitTimeout   time.Duration
+	exitFunc      func(int)
+	handleSignals bool
 
 	logOpt    []cmd.OptFunc
 	mongoOpts []mongo.OptFunc
 }
 
+// WithPrettyFormatter is a function that returns an OptFunc which sets the colored console formatter for a Lib
+// instance, meant for development.
+func WithPrettyFormatter() OptFunc {
+	return func(li *Lib) (err error) {
+		li.logOpt = append(li.logOpt, cmd.PrettyFormatter())
+		return
+	}
+}
+
+// WithLogfmtFormatter is a function that returns an OptFunc which sets the logfmt formatter for a Lib instance.
+func WithLogfmtFormatter() OptFunc {
+	return func(li *Lib) (err error) {
+		li.logOpt = append(li.logOpt, cmd.LogfmtFormatter())
+		return
+	}
+}
+
 // WithJSONFormatter is a function that returns an OptFunc which sets the JSON formatter for a Lib instance.
 func WithJSONFormatter() OptFunc {
 	return func(li *Lib) (err error) {
@@ -48,10 +143,95 @@ func WithHook(status bool) OptFunc {
 	}
 }
 
+// WithBacktrace is a function that returns an OptFunc which enables the backtrace mode with a buffer of the given size.
+// Debug entries of loggers scoped with WithCtx or WithFields are held back and only logged if that logger logs an error.
+func WithBacktrace(size int) OptFunc {
+	return func(li *Lib) (err error) {
+		li.logOpt = append(li.logOpt, cmd.WithBacktrace(size))
+		return
+	}
+}
+
+// WithRedaction is a function that returns an OptFunc which redacts every field before it is formatted or stored.
+// Rules are given as redact options, for instance redact.WithDefaults() or redact.WithKey("*.card", redact.Hash).
+func WithRedaction(opts ...redact.OptFunc) OptFunc {
+	return func(li *Lib) (err error) {
+		r, err := redact.New(opts...)
+		if err != nil {
+			return fmt.Errorf("fail to create redactor: %w", err)
+		}
+
+		li.logOpt = append(li.logOpt, cmd.WithRedactor(r))
+		return
+	}
+}
+
+// WithCrashDiagnostics is a function that returns an OptFunc which enables a diagnostics dump before Fatal exits.
+// Goroutine stacks, memory statistics, build information and uptime are written to stderr and to the
+// "application_crash" collection, with the stacks bounded to maxSize bytes.
+func WithCrashDiagnostics(maxSize int) OptFunc {
+	return func(li *Lib) (err error) {
+		if maxSize <= 0 {
+			maxSize = cmd.DefaultDiagnosticsSize
+		}
+		li.crashDump = maxSize
+		return
+	}
+}
+
+// WithMongoOptions is a function that returns an OptFunc which adds options to the Mongo connection.
+// They are applied after the settings read from the environment, so they take precedence.
+func WithMongoOptions(opts ...mongo.OptFunc) OptFunc {
+	return func(li *Lib) (err error) {
+		li.mongoOpts = append(li.mongoOpts, opts...)
+		return
+	}
+}
+
+// WithPasswordFile is a function that returns an OptFunc which reads the password from the file at path.
+func WithPasswordFile(path string) OptFunc {
+	return func(li *Lib) (err error) {
+		li.PasswordFile = path
+		return
+	}
+}
+
 // New is a function that creates a new Lib instance.
 // It applies the provided options to the Lib instance and then attempts to initialize the environment and command.
 func New(opts ...OptFunc) (li *Lib, err error) {
-	li = &Lib{withHook: true}
+	if li, err = Connect(opts...); err != nil {
+		return nil, err
+	}
+
+	if err = li.initCMD(); err != nil {
+		return nil, fmt.Errorf("fail to init cmd: %w", err)
+	}
+
+	if li.mc != nil && (li.autoIndex || li.timeSeries != nil) {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		err = li.EnsureIndexes(ctx)
+		cancel()
+
+		if err != nil {
+			return nil, fmt.Errorf("fail to prepare collections: %w", err)
+		}
+	}
+
+	if li.mc != nil && li.URI == "" && (li.Username == defaultUsername || li.Password.Reveal() == defaultPassword) {
+		li.Log.Warn("default mongo credentials are in use, set TELEMETRY_USERNAME and TELEMETRY_PASSWORD")
+	}
+
+	if li.handleSignals {
+		li.watchSignals()
+	}
+
+	return li, nil
+}
+
+// Connect is a function that creates a new Lib instance connected to MongoDB, without setting up logging.
+// It is configured like New, from the environment and the options, and is meant for tools reading the stored entries.
+func Connect(opts ...OptFunc) (li *Lib, err error) {
+	li = &Lib{withHook: true, withMongo: true, exitTimeout: 5 * time.Second, exitFunc: os.Exit}
 
 	if err = LoadEnv(li); err != nil {
 		return nil, fmt.Errorf("fail to load env: %w", err)
@@ -63,27 +243,158 @@ func New(opts ...OptFunc) (li *Lib, err error) {
 		}
 	}
 
+	if err = li.validateNames(); err != nil {
+		return nil, fmt.Errorf("fail to validate names: %w", err)
+	}
+
+	if err = li.loadPassword(); err != nil {
+		return nil, fmt.Errorf("fail to load password: %w", err)
+	}
+
+	if !li.withMongo {
+		return li, nil
+	}
+
 	if err = li.initConnection(); err != nil {
 		return nil, fmt.Errorf("fail to init connection: %w", err)
 	}
 
-	if err = li.initCMD(); err != nil {
-		return nil, fmt.Errorf("fail to init cmd: %w", err)
+	return li, nil
+}
+
+// Mongo is a method that returns the Mongo connection of a Lib instance, nil when created with WithMongo(false).
+func (li *Lib) Mongo() *mongo.Mongo {
+	return li.mc
+}
+
+// MongoHook is a method that returns the Mongo sink of a Lib instance, built from its settings on first use.
+// Together with Connect it lets a process such as the collector store entries without setting up logging.
+func (li *Lib) MongoHook() *MongoHook {
+	if li.hook != nil {
+		return li.hook
 	}
 
-	return li, nil
+	li.hook = &MongoHook{
+		Client:          li.mc,
+		Timeout:         5 * time.Second,
+		WithHook:        li.withHook,
+		Database:        li.Database,
+		TraceCollection: li.TraceCollection,
+		LogCollection:   li.LogCollection,
+		Service:         li.Service,
+		Environment:     li.Environment,
+		Metrics:         li.metrics,
+
+		AutoIndex:           li.autoIndex,
+		TimeSeries:          li.timeSeries,
+		LevelRetention:      levelRetention(li.LevelRetention),
+		CollectionRetention: li.CollectionRetention,
+	}
+
+	if li.timeSeries != nil {
+		li.hook.Host, _ = os.Hostname()
+	}
+
+	return li.hook
+}
+
+// loadPassword is a method that reads the password from PasswordFile when it is set.
+func (li *Lib) loadPassword() (err error) {
+	if li.PasswordFile == "" {
+		return
+	}
+
+	b, err := os.ReadFile(li.PasswordFile)
+	if err != nil {
+		return fmt.Errorf("fail to read password file: %w", err)
+	}
+
+	li.Password = Secret(strings.TrimRight(string(b), "\r\n"))
+	return
 }
 
 // initCMD is a method that initializes the command for a Lib instance.
 func (li *Lib) initCMD() (err error) {
-	mongoHook := &MongoHook{
-		Client:   li.mc,
-		Timeout:  5 * time.Second,
-		WithHook: li.withHook,
+	if li.mc != nil {
+		li.sinks = append(li.sinks, li.MongoHook())
+
+		if li.spoolDir != "" {
+			if err = li.initSpool(); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, sink := range li.sinks {
+		if f, ok := sink.(*ForwardHook); ok {
+			f.Service, f.Environment = li.Service, li.Environment
+		}
 	}
 
 	li.logOpt = append(li.logOpt, cmd.WithLogLevel(li.Level))
-	li.logOpt = append(li.logOpt, cmd.WithHook(mongoHook))
+
+	// The console logger of the breakers is built before the metrics, stats, derived metrics and alerts hooks are
+	// added, so its state change messages stay out of them.
+	var console log.Logger
+	if li.withBreaker {
+		if console, err = cmd.New(li.logOpt...); err != nil {
+			return fmt.Errorf("fail to create console log: %w", err)
+		}
+	}
+
+	if li.metrics != nil {
+		li.initMetrics()
+	}
+
+	if li.stats != nil {
+		if err = li.initStats(); err != nil {
+			return err
+		}
+	}
+
+	if len(li.derivedRules) > 0 {
+		if err = li.initDerived(); err != nil {
+			return err
+		}
+	}
+
+	if len(li.alerts) > 0 {
+		li.initAlerts()
+	}
+
+	for _, sink := range li.sinks {
+		hook := sink
+		if li.withBreaker {
+			if hook, err = li.breakerHook(sink, console); err != nil {
+				return err
+			}
+		}
+
+		hook = li.guard(sinkName(sink), hook)
+
+		if li.rateLimit > 0 {
+			li.logOpt = append(li.logOpt, cmd.WithRateLimitedHook(sinkName(sink), hook, li.rateLimit, li.rateBurst))
+			continue
+		}
+		li.logOpt = append(li.logOpt, cmd.WithHook(hook))
+	}
+	li.logOpt = append(li.logOpt, cmd.WithExitHandler(li.runExitHandlers), cmd.WithFlushHandler(li.flushSinks), cmd.WithExitFunc(li.exitFunc))
+
+	if li.crashDump > 0 {
+		var writers []cmd.DiagnosticsWriter
+		if li.mc != nil {
+			writers = append(writers, &CrashWriter{
+				Client:      li.mc,
+				Database:    li.Database,
+				Collection:  li.CrashCollection,
+				Service:     li.Service,
+				Environment: li.Environment,
+				Retention:   li.crashRetention(),
+				Timeout:     5 * time.Second,
+			})
+		}
+		li.logOpt = append(li.logOpt, cmd.WithDiagnostics(li.crashDump, writers...))
+	}
 
 	li.Log, err = cmd.New(li.logOpt...)
 
@@ -96,7 +407,10 @@ func (li *Lib) initCMD() (err error) {
 
 // initConnection is a method that initializes the connection for a Lib instance.
 func (li *Lib) initConnection() (err error) {
-	li.mongoOpts = append(li.mongoOpts, mongo.WithConnection(li.Host, li.Port, li.Username, li.Password))
+	li.mongoOpts = append(li.connectionOpts(), li.mongoOpts...)
+	if !strings.Contains(li.Database, "{{") {
+		li.mongoOpts = append(li.mongoOpts, mongo.WithDatabase(li.Database))
+	}
 	li.mc, err = mongo.New(li.mongoOpts...)
 
 	if err != nil {
@@ -105,3 +419,42 @@ func (li *Lib) initConnection() (err error) {
 
 	return
 }
+
+// connectionOpts is a method that returns the Mongo options read from the environment.
+func (li *Lib) connectionOpts() (opts []mongo.OptFunc) {
+	if li.URI != "" {
+		opts = append(opts, mongo.WithURI(li.URI.Reveal()))
+		if li.Username != defaultUsername {
+			opts = append(opts, mongo.WithCredentials(li.Username, li.Password.Reveal()))
+		}
+	} else {
+		opts = append(opts, mongo.WithConnection(li.Host, li.Port, li.Username, li.Password.Reveal()))
+	}
+
+	if li.AuthSource != "" {
+		opts = append(opts, mongo.WithAuthSource(li.AuthSource))
+	}
+	if li.AuthMechanism != "" {
+		opts = append(opts, mongo.WithAuthMechanism(li.AuthMechanism))
+	}
+	if li.TLSCAFile != "" {
+		opts = append(opts, mongo.WithCAFile(li.TLSCAFile))
+	}
+	if li.TLSCertFile != "" {
+		opts = append(opts, mongo.WithCertFile(li.TLSCertFile, li.TLSKeyFile))
+	}
+	if li.AppName != "" {
+		opts = append(opts, mongo.WithAppName(li.AppName))
+	}
+	if li.MinPoolSize > 0 || li.MaxPoolSize > 0 {
+		opts = append(opts, mongo.WithPoolSize(li.MinPoolSize, li.MaxPoolSize))
+	}
+	if li.WriteConcern != "" {
+		opts = append(opts, mongo.WithWriteConcern(li.WriteConcern))
+	}
+	if li.ServerSelectionTimeout > 0 {
+		opts = append(opts, mongo.WithServerSelectionTimeout(li.ServerSelectionTimeout))
+	}
+
+	return
+}
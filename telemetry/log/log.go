@@ -18,6 +18,13 @@ type Logger interface {
 	WithFields(fields map[string]interface{}) Logger
 }
 
+// PanicLogger is an interface implemented by Loggers that can also log a message and then panic.
+// It is kept apart from Logger so that the existing implementations of Logger still satisfy it.
+type PanicLogger interface {
+	Logger
+	Panic(message string, fn ...LogContextFunc) // Panic logs a message and then panics.
+}
+
 // LogContextFunc is a function that modifies a LogContext.
 type LogContextFunc func(LogContext)
 
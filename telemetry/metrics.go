@@ -0,0 +1,77 @@
+// Package telemetry provides functionality for telemetry logging.
+package telemetry
+
+import (
+	"time"
+
+	"github.com/dyaksa/telemetry-log/cmd"
+	"github.com/dyaksa/telemetry-log/telemetry/metrics"
+	"github.com/sirupsen/logrus"
+)
+
+// WithMetrics is a function that returns an OptFunc which records the activity of the Lib in a metrics registry,
+// metrics.Default when r is nil. Serve the registry, an http.Handler, to expose the metrics to Prometheus, or call
+// its PublishExpvar method to expose them on /debug/vars.
+//
+// The metrics are entries delivered by level and sink, sink errors and write latency, Mongo errors, batch sizes,
+// entries dropped by sampling, rate limiting or a full buffer, and the depth of the spool and the forwarding buffers.
+func WithMetrics(r *metrics.Registry) OptFunc {
+	return func(li *Lib) (err error) {
+		if r == nil {
+			r = metrics.Default
+		}
+
+		li.metrics = r
+		return
+	}
+}
+
+// sinkMetrics is a struct that holds the metrics recorded around every sink.
+type sinkMetrics struct {
+	entries *metrics.Counter
+	errors  *metrics.Counter
+	latency *metrics.Histogram
+}
+
+// newSinkMetrics is a function that registers the sink metrics in r.
+func newSinkMetrics(r *metrics.Registry) *sinkMetrics {
+	return &sinkMetrics{
+		entries: r.Counter("telemetry_entries_total", "Entries delivered, by level and sink.", "level", "sink"),
+		errors:  r.Counter("telemetry_sink_errors_total", "Entries a sink failed to deliver.", "sink"),
+		latency: r.Histogram("telemetry_sink_write_seconds", "Time taken by a sink to deliver an entry.", nil, "sink"),
+	}
+}
+
+// observe is a method that records the delivery of an entry by a sink.
+func (m *sinkMetrics) observe(sink string, e *logrus.Entry, elapsed time.Duration, err error) {
+	m.latency.Observe(elapsed.Seconds(), sink)
+	if err != nil {
+		m.errors.Inc(sink)
+		return
+	}
+	m.entries.Inc(e.Level.String(), sink)
+}
+
+// initMetrics is a method that registers the metrics of the sinks and the logger options reporting dropped entries.
+func (li *Lib) initMetrics() {
+	r := li.metrics
+	li.sinkMetrics = newSinkMetrics(r)
+
+	dropped := r.Counter("telemetry_dropped_total", "Entries dropped, by reason and level or sink.", "reason", "source")
+	li.logOpt = append(li.logOpt, cmd.WithDropObserver(func(reason, source string) { dropped.Inc(reason, source) }))
+
+	for _, sink := range li.sinks {
+		switch s := sink.(type) {
+		case *MongoHook:
+			s.Metrics = r
+			if s.Spool != nil {
+				sp := s.Spool
+				r.GaugeFunc("telemetry_spool_records", "Entries waiting in the spool.", func() float64 { return float64(sp.Stats().Records) })
+				r.GaugeFunc("telemetry_spool_bytes", "Disk space used by the spool.", func() float64 { return float64(sp.Stats().Bytes) })
+			}
+		case *ForwardHook:
+			s.Metrics = r
+			r.GaugeFunc("telemetry_forward_pending", "Entries waiting to be acked by the collector.", func() float64 { return float64(s.pending.Load()) })
+		}
+	}
+}
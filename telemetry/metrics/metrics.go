@@ -0,0 +1,358 @@
+// Package metrics provides counters, gauges and histograms exposed in the Prometheus text format and through expvar,
+// without depending on a Prometheus client.
+package metrics
+
+import (
+	"expvar"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are the histogram buckets used when none are given, suited to durations in seconds.
+var DefaultBuckets = []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// SizeBuckets are histogram buckets suited to batch and queue sizes.
+var SizeBuckets = []float64{1, 5, 10, 50, 100, 500, 1000, 5000, 10000}
+
+// Default is the registry used by the telemetry package when none is given.
+var Default = NewRegistry()
+
+// These constants are the kinds of metric, as written in the TYPE line of the exposition.
+const (
+	kindCounter   = "counter"
+	kindGauge     = "gauge"
+	kindHistogram = "histogram"
+)
+
+// labelSep separates the label values of a series key.
+const labelSep = "\xff"
+
+// Registry is a struct that holds a set of metrics.
+type Registry struct {
+	mu      sync.Mutex
+	metrics map[string]*metric
+}
+
+// NewRegistry is a function that creates an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{metrics: map[string]*metric{}}
+}
+
+// metric is a struct that holds the series of a metric, by label values.
+type metric struct {
+	name    string
+	help    string
+	kind    string
+	labels  []string
+	buckets []float64
+	bounds  []float64 // bounds are the buckets followed by +Inf.
+	fn      func() float64
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+// series is a struct that holds the value of a metric for a set of label values.
+type series struct {
+	values []string
+	value  float64
+	counts []uint64 // counts holds the non-cumulative bucket counts of a histogram, the last one for +Inf.
+	sum    float64
+	count  uint64
+}
+
+// Counter is a struct that holds a metric which only goes up.
+type Counter struct{ m *metric }
+
+// Gauge is a struct that holds a metric which goes up and down.
+type Gauge struct{ m *metric }
+
+// Histogram is a struct that holds a metric counting observations in buckets.
+type Histogram struct{ m *metric }
+
+// Counter is a method that returns the counter with the given name, creating it on first use.
+func (r *Registry) Counter(name, help string, labels ...string) *Counter {
+	return &Counter{m: r.register(name, help, kindCounter, labels, nil, nil)}
+}
+
+// Gauge is a method that returns the gauge with the given name, creating it on first use.
+func (r *Registry) Gauge(name, help string, labels ...string) *Gauge {
+	return &Gauge{m: r.register(name, help, kindGauge, labels, nil, nil)}
+}
+
+// GaugeFunc is a method that registers a gauge whose value is read from fn when the metrics are collected.
+// Registering the same name again replaces fn.
+func (r *Registry) GaugeFunc(name, help string, fn func() float64) {
+	m := r.register(name, help, kindGauge, nil, nil, fn)
+
+	m.mu.Lock()
+	m.fn = fn
+	m.mu.Unlock()
+}
+
+// Histogram is a method that returns the histogram with the given name, creating it on first use.
+// Buckets are upper bounds in increasing order, DefaultBuckets when nil.
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+
+	return &Histogram{m: r.register(name, help, kindHistogram, labels, buckets, nil)}
+}
+
+// register is a method that returns the metric with the given name, creating it if needed.
+// It panics when the name is already used by a metric of another kind or with other labels, a programming error.
+func (r *Registry) register(name, help, kind string, labels []string, buckets []float64, fn func() float64) *metric {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if m, ok := r.metrics[name]; ok {
+		if m.kind != kind || strings.Join(m.labels, ",") != strings.Join(labels, ",") {
+			panic(fmt.Sprintf("metrics: %s already registered as a %s with labels %v", name, m.kind, m.labels))
+		}
+		return m
+	}
+
+	m := &metric{name: name, help: help, kind: kind, labels: labels, fn: fn, series: map[string]*series{}}
+	if buckets != nil {
+		m.buckets = append([]float64(nil), buckets...)
+		m.bounds = append(append([]float64(nil), buckets...), math.Inf(1))
+	}
+	r.metrics[name] = m
+	return m
+}
+
+// get is a method that returns the series of the given label values, creating it if needed; the lock must be held.
+func (m *metric) get(values []string) *series {
+	if len(values) != len(m.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", m.name, len(m.labels), len(values)))
+	}
+
+	key := strings.Join(values, labelSep)
+	s, ok := m.series[key]
+	if !ok {
+		s = &series{values: append([]string(nil), values...)}
+		if m.kind == kindHistogram {
+			s.counts = make([]uint64, len(m.buckets)+1)
+		}
+		m.series[key] = s
+	}
+
+	return s
+}
+
+// Inc is a method that adds one to the counter of the given label values.
+func (c *Counter) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Add is a method that adds v, which must not be negative, to the counter of the given label values.
+func (c *Counter) Add(v float64, values ...string) {
+	if v < 0 {
+		return
+	}
+
+	c.m.mu.Lock()
+	defer c.m.mu.Unlock()
+
+	c.m.get(values).value += v
+}
+
+// Set is a method that sets the gauge of the given label values.
+func (g *Gauge) Set(v float64, values ...string) {
+	g.m.mu.Lock()
+	defer g.m.mu.Unlock()
+
+	g.m.get(values).value = v
+}
+
+// Add is a method that adds v, possibly negative, to the gauge of the given label values.
+func (g *Gauge) Add(v float64, values ...string) {
+	g.m.mu.Lock()
+	defer g.m.mu.Unlock()
+
+	g.m.get(values).value += v
+}
+
+// Observe is a method that records an observation in the histogram of the given label values.
+func (h *Histogram) Observe(v float64, values ...string) {
+	i := sort.SearchFloat64s(h.m.buckets, v)
+
+	h.m.mu.Lock()
+	defer h.m.mu.Unlock()
+
+	s := h.m.get(values)
+	s.counts[i]++
+	s.sum += v
+	s.count++
+}
+
+// ServeHTTP is a method that writes every metric in the Prometheus text exposition format.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, _ = w.Write([]byte(r.Text()))
+}
+
+// Text is a method that returns every metric in the Prometheus text exposition format, sorted by name.
+func (r *Registry) Text() string {
+	var b strings.Builder
+	for _, m := range r.sorted() {
+		m.mu.Lock()
+
+		fmt.Fprintf(&b, "# HELP %s %s\n", m.name, escapeHelp(m.help))
+		fmt.Fprintf(&b, "# TYPE %s %s\n", m.name, m.kind)
+
+		if m.fn != nil {
+			fmt.Fprintf(&b, "%s %s\n", m.name, formatValue(m.fn()))
+		}
+
+		for _, s := range m.sortedSeries() {
+			labels := formatLabels(m.labels, s.values)
+			if m.kind != kindHistogram {
+				fmt.Fprintf(&b, "%s%s %s\n", m.name, braces(labels), formatValue(s.value))
+				continue
+			}
+
+			var cumulative uint64
+			for i, upper := range m.bounds {
+				cumulative += s.counts[i]
+				le := fmt.Sprintf(`le="%s"`, formatValue(upper))
+				fmt.Fprintf(&b, "%s_bucket%s %d\n", m.name, braces(join(labels, le)), cumulative)
+			}
+			fmt.Fprintf(&b, "%s_sum%s %s\n", m.name, braces(labels), formatValue(s.sum))
+			fmt.Fprintf(&b, "%s_count%s %d\n", m.name, braces(labels), s.count)
+		}
+
+		m.mu.Unlock()
+	}
+
+	return b.String()
+}
+
+// Snapshot is a method that returns the value of every series, by metric name and then by label set.
+// Histograms are reported by their count, sum and cumulative buckets.
+func (r *Registry) Snapshot() map[string]interface{} {
+	out := map[string]interface{}{}
+	for _, m := range r.sorted() {
+		m.mu.Lock()
+
+		if m.fn != nil {
+			out[m.name] = m.fn()
+		} else {
+			values := map[string]interface{}{}
+			for _, s := range m.sortedSeries() {
+				key := formatLabels(m.labels, s.values)
+				if m.kind != kindHistogram {
+					values[key] = s.value
+					continue
+				}
+
+				buckets := map[string]uint64{}
+				var cumulative uint64
+				for i, upper := range m.bounds {
+					cumulative += s.counts[i]
+					buckets[formatValue(upper)] = cumulative
+				}
+				values[key] = map[string]interface{}{"count": s.count, "sum": s.sum, "buckets": buckets}
+			}
+			out[m.name] = values
+		}
+
+		m.mu.Unlock()
+	}
+
+	return out
+}
+
+// PublishExpvar is a method that publishes the snapshot of the registry as the expvar variable name,
+// served on /debug/vars. It panics if the name is already published, as expvar.Publish does.
+func (r *Registry) PublishExpvar(name string) {
+	expvar.Publish(name, expvar.Func(func() any { return r.Snapshot() }))
+}
+
+// sorted is a method that returns the metrics sorted by name.
+func (r *Registry) sorted() []*metric {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ms := make([]*metric, 0, len(r.metrics))
+	for _, m := range r.metrics {
+		ms = append(ms, m)
+	}
+	sort.Slice(ms, func(i, j int) bool { return ms[i].name < ms[j].name })
+
+	return ms
+}
+
+// sortedSeries is a method that returns the series sorted by label values; the lock must be held.
+func (m *metric) sortedSeries() []*series {
+	keys := make([]string, 0, len(m.series))
+	for k := range m.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	out := make([]*series, len(keys))
+	for i, k := range keys {
+		out[i] = m.series[k]
+	}
+
+	return out
+}
+
+// formatLabels is a function that returns the label pairs of a series, as name="value" separated by commas.
+func formatLabels(names, values []string) string {
+	pairs := make([]string, len(names))
+	for i, n := range names {
+		pairs[i] = fmt.Sprintf(`%s="%s"`, n, escapeLabel(values[i]))
+	}
+
+	return strings.Join(pairs, ",")
+}
+
+// join is a function that joins two label lists, either of which may be empty.
+func join(a, b string) string {
+	if a == "" {
+		return b
+	}
+
+	return a + "," + b
+}
+
+// braces is a function that wraps a non-empty label list in braces.
+func braces(labels string) string {
+	if labels == "" {
+		return ""
+	}
+
+	return "{" + labels + "}"
+}
+
+// formatValue is a function that formats a sample value as the exposition format expects.
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// escapeLabel is a function that escapes a label value.
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+// escapeHelp is a function that escapes a help text.
+func escapeHelp(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(s)
+}
@@ -0,0 +1,59 @@
+package metrics_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/dyaksa/telemetry-log/telemetry/metrics"
+)
+
+func TestRegistryText(t *testing.T) {
+	r := metrics.NewRegistry()
+
+	c := r.Counter("entries_total", "Entries \"seen\".", "level")
+	c.Inc("info")
+	c.Add(2, "error")
+	c.Add(-1, "error")
+
+	h := r.Histogram("batch_size", "Entries per batch.", []float64{1, 10})
+	h.Observe(1)
+	h.Observe(5)
+	h.Observe(50)
+
+	r.GaugeFunc("queue_depth", "Queued requests.", func() float64 { return 3 })
+
+	want := `# HELP batch_size Entries per batch.
+# TYPE batch_size histogram
+batch_size_bucket{le="1"} 1
+batch_size_bucket{le="10"} 2
+batch_size_bucket{le="+Inf"} 3
+batch_size_sum 56
+batch_size_count 3
+# HELP entries_total Entries "seen".
+# TYPE entries_total counter
+entries_total{level="error"} 2
+entries_total{level="info"} 1
+# HELP queue_depth Queued requests.
+# TYPE queue_depth gauge
+queue_depth 3
+`
+	if got := r.Text(); got != want {
+		t.Fatalf("unexpected exposition:\n%s", got)
+	}
+
+	if r.Counter("entries_total", "", "level").Inc("info"); !strings.Contains(r.Text(), `entries_total{level="info"} 2`) {
+		t.Fatal("counter was not shared by name")
+	}
+}
+
+func TestRegistryConflict(t *testing.T) {
+	r := metrics.NewRegistry()
+	r.Counter("requests_total", "Requests.", "code")
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("registering a gauge under a counter name did not panic")
+		}
+	}()
+	r.Gauge("requests_total", "Requests.")
+}
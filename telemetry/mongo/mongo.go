@@ -5,24 +5,50 @@ package mongo
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
-	"strings"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
 )
 
+// DefaultDatabase is the database used when none is configured.
+const DefaultDatabase = "telemetry"
+
 // OptFunc is a type that defines a function that modifies a Mongo instance.
 type OptFunc func(*Mongo) error
 
 // Mongo is a struct that holds the necessary information to connect to a MongoDB instance.
 type Mongo struct {
-	client *mongo.Client
+	client   *mongo.Client
+	database string
 
+	uri      string
 	host     string
 	port     string
 	username string
 	password string
+
+	authMechanism string
+	authSource    string
+
+	tlsConfig *tls.Config
+	caFile    string
+	certFile  string
+	keyFile   string
+
+	appName                string
+	minPoolSize            uint64
+	maxPoolSize            uint64
+	writeConcern           *writeconcern.WriteConcern
+	serverSelectionTimeout time.Duration
 }
 
 // WithConnection is a function that returns an OptFunc which sets the connection details of a Mongo instance.
@@ -36,11 +62,148 @@ func WithConnection(host, port, username, password string) OptFunc {
 	}
 }
 
+// WithURI is a function that returns an OptFunc which sets the full connection string of a Mongo instance.
+// Both mongodb:// and mongodb+srv:// schemes are accepted, with any option the driver understands
+// (replica set, TLS, authSource...). The URI takes precedence over the host and port.
+func WithURI(uri string) OptFunc {
+	return func(m *Mongo) (err error) {
+		if !strings.HasPrefix(uri, "mongodb://") && !strings.HasPrefix(uri, "mongodb+srv://") {
+			return errors.New("invalid mongo uri: scheme must be mongodb:// or mongodb+srv://")
+		}
+
+		m.uri = uri
+		return
+	}
+}
+
+// WithDatabase is a function that returns an OptFunc which sets the default database of a Mongo instance.
+func WithDatabase(name string) OptFunc {
+	return func(m *Mongo) (err error) {
+		if name == "" {
+			return errors.New("database name must not be empty")
+		}
+
+		m.database = name
+		return
+	}
+}
+
+// WithCredentials is a function that returns an OptFunc which sets the user and password of a Mongo instance.
+// They are ignored when the connection URI already holds credentials.
+func WithCredentials(username, password string) OptFunc {
+	return func(m *Mongo) (err error) {
+		m.username = username
+		m.password = password
+		return
+	}
+}
+
+// WithAuthMechanism is a function that returns an OptFunc which sets the authentication mechanism,
+// for instance SCRAM-SHA-256 or MONGODB-X509.
+func WithAuthMechanism(mechanism string) OptFunc {
+	return func(m *Mongo) (err error) {
+		m.authMechanism = mechanism
+		return
+	}
+}
+
+// WithAuthSource is a function that returns an OptFunc which sets the database the credentials are defined in.
+func WithAuthSource(source string) OptFunc {
+	return func(m *Mongo) (err error) {
+		m.authSource = source
+		return
+	}
+}
+
+// WithTLSConfig is a function that returns an OptFunc which enables TLS with the given configuration.
+func WithTLSConfig(cfg *tls.Config) OptFunc {
+	return func(m *Mongo) (err error) {
+		m.tlsConfig = cfg
+		return
+	}
+}
+
+// WithCAFile is a function that returns an OptFunc which enables TLS and trusts the PEM certificates in path.
+func WithCAFile(path string) OptFunc {
+	return func(m *Mongo) (err error) {
+		m.caFile = path
+		return
+	}
+}
+
+// WithCertFile is a function that returns an OptFunc which enables TLS with a client certificate, as used by X.509 auth.
+// keyFile may be empty when certFile holds both the certificate and its private key.
+func WithCertFile(certFile, keyFile string) OptFunc {
+	return func(m *Mongo) (err error) {
+		m.certFile = certFile
+		m.keyFile = keyFile
+		return
+	}
+}
+
+// WithAppName is a function that returns an OptFunc which sets the application name reported to the server.
+func WithAppName(name string) OptFunc {
+	return func(m *Mongo) (err error) {
+		m.appName = name
+		return
+	}
+}
+
+// WithPoolSize is a function that returns an OptFunc which sets the minimum and maximum size of the connection pool.
+// A zero value keeps the driver default.
+func WithPoolSize(minSize, maxSize uint64) OptFunc {
+	return func(m *Mongo) (err error) {
+		if maxSize > 0 && minSize > maxSize {
+			return fmt.Errorf("invalid pool size: min %d is greater than max %d", minSize, maxSize)
+		}
+
+		m.minPoolSize = minSize
+		m.maxPoolSize = maxSize
+		return
+	}
+}
+
+// WithWriteConcern is a function that returns an OptFunc which sets the write concern,
+// either "majority", a number of nodes, or a tag set name.
+func WithWriteConcern(w string) OptFunc {
+	return func(m *Mongo) (err error) {
+		switch {
+		case w == "":
+			return errors.New("invalid write concern: empty")
+		case w == "majority":
+			m.writeConcern = writeconcern.Majority()
+		default:
+			n, convErr := strconv.Atoi(w)
+			if convErr != nil {
+				m.writeConcern = writeconcern.Custom(w)
+				return
+			}
+			if n < 0 {
+				return fmt.Errorf("invalid write concern: %d", n)
+			}
+			m.writeConcern = &writeconcern.WriteConcern{W: n}
+		}
+		return
+	}
+}
+
+// WithServerSelectionTimeout is a function that returns an OptFunc which sets how long the driver waits for a suitable server.
+func WithServerSelectionTimeout(d time.Duration) OptFunc {
+	return func(m *Mongo) (err error) {
+		if d <= 0 {
+			return fmt.Errorf("invalid server selection timeout: %s", d)
+		}
+
+		m.serverSelectionTimeout = d
+		return
+	}
+}
+
 // New is a function that creates a new Mongo instance and connects to the MongoDB server.
 // It applies the provided options to the Mongo instance and then attempts to connect to the server.
 // If the connection is successful, it pings the server to ensure the connection is alive.
 func New(opts ...OptFunc) (*Mongo, error) {
-	m := &Mongo{}
+	m := &Mongo{database: DefaultDatabase}
 	for _, opt := range opts {
 		err := opt(m)
 		if err != nil {
@@ -48,34 +211,145 @@ func New(opts ...OptFunc) (*Mongo, error) {
 		}
 	}
 
-	serverUri := strings.Builder{}
-	serverUri.WriteString("mongodb://")
-	serverUri.WriteString(m.host)
-	serverUri.WriteString(":")
-	serverUri.WriteString(m.port)
-
-	serverApi := options.ServerAPI(options.ServerAPIVersion1)
-	authCred := options.Credential{Username: m.username, Password: m.password}
+	opt, err := m.clientOptions()
+	if err != nil {
+		return nil, fmt.Errorf("fail to build client options: %w", err)
+	}
 
-	opt := options.Client().ApplyURI(serverUri.String()).SetServerAPIOptions(serverApi).SetAuth(authCred)
 	client, err := mongo.Connect(context.TODO(), opt)
-
 	if err != nil {
-		err = errors.Join(err, fmt.Errorf("fail to connect to mongo: %w", err))
+		return nil, fmt.Errorf("fail to connect to mongo: %w", err)
 	}
 
 	m.client = client
 
-	if m.client == nil {
-		err = errors.Join(err, fmt.Errorf("fail to connect to mongo"))
-	}
-
-	if err = m.client.Database("telemetry").RunCommand(context.TODO(), map[string]string{"ping": "1"}).Err(); err != nil {
+	if err = m.Ping(context.TODO()); err != nil {
 		_ = m.client.Disconnect(context.Background())
 		return nil, fmt.Errorf("ping failed after connection: %w", err)
 	}
 
-	return m, err
+	return m, nil
+}
+
+// clientOptions is a method that builds the driver options from the settings of the Mongo instance.
+func (m *Mongo) clientOptions() (*options.ClientOptions, error) {
+	uri := m.uri
+	if uri == "" {
+		serverUri := strings.Builder{}
+		serverUri.WriteString("mongodb://")
+		serverUri.WriteString(m.host)
+		serverUri.WriteString(":")
+		serverUri.WriteString(m.port)
+		uri = serverUri.String()
+	}
+
+	serverApi := options.ServerAPI(options.ServerAPIVersion1)
+	opt := options.Client().ApplyURI(uri).SetServerAPIOptions(serverApi)
+
+	m.applyAuth(opt)
+
+	if err := m.applyTLS(opt); err != nil {
+		return nil, err
+	}
+
+	if m.appName != "" {
+		opt.SetAppName(m.appName)
+	}
+	if m.minPoolSize > 0 {
+		opt.SetMinPoolSize(m.minPoolSize)
+	}
+	if m.maxPoolSize > 0 {
+		opt.SetMaxPoolSize(m.maxPoolSize)
+	}
+	if m.writeConcern != nil {
+		opt.SetWriteConcern(m.writeConcern)
+	}
+	if m.serverSelectionTimeout > 0 {
+		opt.SetServerSelectionTimeout(m.serverSelectionTimeout)
+	}
+
+	return opt, opt.Validate()
+}
+
+// applyAuth is a method that sets the credentials, unless the URI already holds some.
+func (m *Mongo) applyAuth(opt *options.ClientOptions) {
+	cred := options.Credential{}
+	if opt.Auth != nil {
+		cred = *opt.Auth
+	}
+
+	if m.authMechanism != "" {
+		cred.AuthMechanism = m.authMechanism
+	}
+	if m.authSource != "" {
+		cred.AuthSource = m.authSource
+	}
+
+	switch {
+	case strings.EqualFold(cred.AuthMechanism, "MONGODB-X509"):
+		cred.AuthMechanism = "MONGODB-X509"
+		if cred.AuthSource == "" {
+			cred.AuthSource = "$external"
+		}
+	case cred.Username == "" && m.username != "":
+		cred.Username = m.username
+		cred.Password = m.password
+	}
+
+	if cred.Username == "" && cred.AuthMechanism == "" && cred.AuthSource == "" {
+		return
+	}
+
+	opt.SetAuth(cred)
+}
+
+// applyTLS is a method that enables TLS when a configuration, a CA or a client certificate is set.
+func (m *Mongo) applyTLS(opt *options.ClientOptions) error {
+	if m.tlsConfig == nil && m.caFile == "" && m.certFile == "" {
+		return nil
+	}
+
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if m.tlsConfig != nil {
+		cfg = m.tlsConfig.Clone()
+	} else if opt.TLSConfig != nil {
+		cfg = opt.TLSConfig.Clone()
+	}
+
+	if m.caFile != "" {
+		pem, err := os.ReadFile(m.caFile)
+		if err != nil {
+			return fmt.Errorf("fail to read ca file: %w", err)
+		}
+
+		if cfg.RootCAs == nil {
+			cfg.RootCAs = x509.NewCertPool()
+		}
+		if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificate found in ca file %s", m.caFile)
+		}
+	}
+
+	if m.certFile != "" {
+		keyFile := m.keyFile
+		if keyFile == "" {
+			keyFile = m.certFile
+		}
+
+		cert, err := tls.LoadX509KeyPair(m.certFile, keyFile)
+		if err != nil {
+			return fmt.Errorf("fail to load client certificate: %w", err)
+		}
+		cfg.Certificates = append(cfg.Certificates, cert)
+	}
+
+	opt.SetTLSConfig(cfg)
+	return nil
+}
+
+// Ping is a method that checks the MongoDB server answers on the default database.
+func (m *Mongo) Ping(ctx context.Context) error {
+	return m.client.Database(m.database).RunCommand(ctx, map[string]string{"ping": "1"}).Err()
 }
 
 // Close is a method that disconnects the Mongo instance from the MongoDB server.
@@ -84,7 +358,23 @@ func (m *Mongo) Close(ctx context.Context) (err error) {
 	return
 }
 
-// Collection is a method that returns a mongo.Collection instance for the specified collection name in the "telemetry" database.
+// Collection is a method that returns a mongo.Collection instance for the specified collection name in the default database.
 func (m *Mongo) Collection(name string) *mongo.Collection {
-	return m.client.Database("telemetry").Collection(name)
+	return m.client.Database(m.database).Collection(name)
+}
+
+// Database is a method that returns a mongo.Database instance for the given database name.
+// An empty database name selects the default database.
+func (m *Mongo) Database(name string) *mongo.Database {
+	if name == "" {
+		name = m.database
+	}
+
+	return m.client.Database(name)
+}
+
+// CollectionIn is a method that returns a mongo.Collection instance for the specified collection name in the given database.
+// An empty database name selects the default database.
+func (m *Mongo) CollectionIn(database, name string) *mongo.Collection {
+	return m.Database(database).Collection(name)
 }
@@ -0,0 +1,163 @@
+package mongo
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newMongo is a function that applies opts to a Mongo instance without connecting it.
+func newMongo(t *testing.T, opts ...OptFunc) *Mongo {
+	t.Helper()
+
+	m := &Mongo{database: DefaultDatabase}
+	for _, opt := range opts {
+		if err := opt(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return m
+}
+
+func TestClientOptions(t *testing.T) {
+	m := newMongo(t,
+		WithConnection("db.local", "27018", "app", "s3cret"),
+		WithAuthSource("admin"),
+		WithAppName("billing"),
+		WithPoolSize(2, 50),
+		WithWriteConcern("majority"),
+		WithServerSelectionTimeout(3*time.Second),
+	)
+
+	opt, err := m.clientOptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(opt.Hosts) != 1 || opt.Hosts[0] != "db.local:27018" {
+		t.Errorf("unexpected hosts: %v", opt.Hosts)
+	}
+	if opt.Auth == nil || opt.Auth.Username != "app" || opt.Auth.Password != "s3cret" || opt.Auth.AuthSource != "admin" {
+		t.Errorf("unexpected credentials: %+v", opt.Auth)
+	}
+	if *opt.AppName != "billing" || *opt.MinPoolSize != 2 || *opt.MaxPoolSize != 50 || *opt.ServerSelectionTimeout != 3*time.Second {
+		t.Errorf("unexpected settings: %v %v %v %v", *opt.AppName, *opt.MinPoolSize, *opt.MaxPoolSize, *opt.ServerSelectionTimeout)
+	}
+	if opt.WriteConcern == nil || opt.WriteConcern.W != "majority" {
+		t.Errorf("unexpected write concern: %+v", opt.WriteConcern)
+	}
+}
+
+func TestApplyAuth(t *testing.T) {
+	m := newMongo(t, WithURI("mongodb://owner:pw@db.local/?replicaSet=rs0"), WithCredentials("app", "s3cret"))
+	opt, err := m.clientOptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opt.Auth.Username != "owner" || opt.Auth.Password != "pw" {
+		t.Errorf("the uri credentials were replaced: %+v", opt.Auth)
+	}
+
+	m = newMongo(t, WithConnection("db.local", "27017", "", ""), WithAuthMechanism("mongodb-x509"))
+	if opt, err = m.clientOptions(); err != nil {
+		t.Fatal(err)
+	}
+	if opt.Auth.AuthMechanism != "MONGODB-X509" || opt.Auth.AuthSource != "$external" {
+		t.Errorf("unexpected x509 credentials: %+v", opt.Auth)
+	}
+
+	m = newMongo(t, WithConnection("db.local", "27017", "", ""))
+	if opt, err = m.clientOptions(); err != nil {
+		t.Fatal(err)
+	}
+	if opt.Auth != nil {
+		t.Errorf("credentials set without any configured: %+v", opt.Auth)
+	}
+}
+
+func TestApplyTLS(t *testing.T) {
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.pem")
+	if err := os.WriteFile(caFile, selfSignedPEM(t), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	m := newMongo(t, WithConnection("db.local", "27017", "", ""), WithCAFile(caFile))
+	opt, err := m.clientOptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opt.TLSConfig == nil || opt.TLSConfig.RootCAs == nil {
+		t.Fatalf("tls not enabled: %+v", opt.TLSConfig)
+	}
+
+	empty := filepath.Join(dir, "empty.pem")
+	if err = os.WriteFile(empty, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = newMongo(t, WithConnection("db.local", "27017", "", ""), WithCAFile(empty)).clientOptions(); err == nil {
+		t.Error("a ca file without certificates was accepted")
+	}
+
+	if opt, err = newMongo(t, WithConnection("db.local", "27017", "", "")).clientOptions(); err != nil || opt.TLSConfig != nil {
+		t.Errorf("tls enabled without any configured: %v", err)
+	}
+}
+
+// selfSignedPEM is a function that returns a self-signed certificate encoded as PEM.
+func selfSignedPEM(t *testing.T) []byte {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+// testMongoURI is a function that returns the URI of the MongoDB server used by the tests needing one,
+// skipping the test when TELEMETRY_TEST_MONGO_URI is not set.
+func testMongoURI(t *testing.T) string {
+	t.Helper()
+
+	uri := os.Getenv("TELEMETRY_TEST_MONGO_URI")
+	if uri == "" {
+		t.Skip("TELEMETRY_TEST_MONGO_URI is not set")
+	}
+	return uri
+}
+
+func TestConnect(t *testing.T) {
+	m, err := New(WithURI(testMongoURI(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close(context.Background())
+
+	if err = m.Ping(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,147 @@
+// Package telemetry provides functionality for telemetry logging.
+package telemetry
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// WithService is a function that returns an OptFunc which sets the name of the service stored with every entry.
+func WithService(name string) OptFunc {
+	return func(li *Lib) (err error) {
+		li.Service = name
+		return
+	}
+}
+
+// WithEnvironment is a function that returns an OptFunc which sets the name of the environment stored with every entry.
+func WithEnvironment(name string) OptFunc {
+	return func(li *Lib) (err error) {
+		li.Environment = name
+		return
+	}
+}
+
+// WithDatabase is a function that returns an OptFunc which sets the database template entries are stored in.
+// Database and collection names may contain the placeholders {{service}}, {{env}}, {{level}}, {{yyyy}}, {{mm}},
+// {{dd}}, {{yyyy_mm}} and {{yyyy_mm_dd}}, for instance "logs_{{service}}_{{yyyy_mm}}".
+func WithDatabase(tmpl string) OptFunc {
+	return func(li *Lib) (err error) {
+		li.Database = tmpl
+		return
+	}
+}
+
+// WithCollections is a function that returns an OptFunc which sets the templates of the trace and log collections.
+func WithCollections(trace, log string) OptFunc {
+	return func(li *Lib) (err error) {
+		li.TraceCollection = trace
+		li.LogCollection = log
+		return
+	}
+}
+
+// WithCrashCollection is a function that returns an OptFunc which sets the template of the crash diagnostics collection.
+func WithCrashCollection(tmpl string) OptFunc {
+	return func(li *Lib) (err error) {
+		li.CrashCollection = tmpl
+		return
+	}
+}
+
+// ResolveNames is a method that returns the database, trace and log collection names in use at time t.
+// Templates using {{level}} are resolved for the given level, as logrus names it, e.g. "error" or "warning".
+func (li *Lib) ResolveNames(level string, t time.Time) (database, trace, log string) {
+	vars := nameVars{service: li.Service, environment: li.Environment, level: level, time: t}
+	return expandName(li.Database, vars), expandName(li.TraceCollection, vars), expandName(li.LogCollection, vars)
+}
+
+// validateNames is a method that checks the database and collection templates of a Lib instance.
+func (li *Lib) validateNames() error {
+	for _, tmpl := range []string{li.Database, li.TraceCollection, li.LogCollection, li.CrashCollection} {
+		if tmpl == "" {
+			return fmt.Errorf("database and collection names must not be empty")
+		}
+		if err := validateName(tmpl); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// placeholder matches the {{name}} placeholders of a name template.
+var placeholder = regexp.MustCompile(`{{\s*([a-z_]+)\s*}}`)
+
+// nameVars is a struct that holds the values substituted in database and collection name templates.
+type nameVars struct {
+	service     string
+	environment string
+	level       string
+	time        time.Time
+}
+
+// lookup is a method that returns the value of a placeholder and whether it is known.
+func (v nameVars) lookup(name string) (string, bool) {
+	switch name {
+	case "service":
+		return v.service, true
+	case "env", "environment":
+		return v.environment, true
+	case "level":
+		return v.level, true
+	case "yyyy":
+		return v.time.Format("2006"), true
+	case "mm":
+		return v.time.Format("01"), true
+	case "dd":
+		return v.time.Format("02"), true
+	case "yyyy_mm":
+		return v.time.Format("2006_01"), true
+	case "yyyy_mm_dd":
+		return v.time.Format("2006_01_02"), true
+	}
+	return "", false
+}
+
+// validateName is a function that checks every placeholder of a name template is known.
+func validateName(tmpl string) error {
+	for _, m := range placeholder.FindAllStringSubmatch(tmpl, -1) {
+		if _, ok := (nameVars{}).lookup(m[1]); !ok {
+			return fmt.Errorf("unknown placeholder %q in name %q", m[0], tmpl)
+		}
+	}
+
+	return nil
+}
+
+// expandName is a function that substitutes the placeholders of a name template.
+// Dates are taken in UTC so that every instance of a service rolls over at the same time.
+// Placeholders without a value, such as an unset service, are replaced by "default", and the characters of a value
+// Mongo does not allow in database or collection names, such as '.', '$', '/' or spaces, are replaced by '_'.
+func expandName(tmpl string, v nameVars) string {
+	if !strings.Contains(tmpl, "{{") {
+		return tmpl
+	}
+
+	v.time = v.time.UTC()
+	return placeholder.ReplaceAllStringFunc(tmpl, func(m string) string {
+		name := placeholder.FindStringSubmatch(m)[1]
+		if s, _ := v.lookup(name); s != "" {
+			return strings.Map(nameRune, s)
+		}
+		return "default"
+	})
+}
+
+// nameRune is a function that maps the runes of a placeholder value to the ones safe in any Mongo name:
+// letters, digits, '_' and '-'.
+func nameRune(r rune) rune {
+	switch {
+	case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == '-':
+		return r
+	}
+	return '_'
+}
@@ -0,0 +1,31 @@
+package telemetry
+
+import (
+	"testing"
+	"time"
+)
+
+func TestExpandName(t *testing.T) {
+	vars := nameVars{service: "billing", level: "error", time: time.Date(2024, 6, 14, 23, 0, 0, 0, time.FixedZone("WIB", 7*3600))}
+
+	tests := map[string]string{
+		"application_log":              "application_log",
+		"logs_{{service}}_{{yyyy_mm}}": "logs_billing_2024_06",
+		"{{ level }}_{{yyyy_mm_dd}}":   "error_2024_06_14",
+		"{{env}}_{{service}}":          "default_billing",
+	}
+
+	if got := expandName("logs_{{service}}", nameVars{service: "pay.api/v2 $x"}); got != "logs_pay_api_v2__x" {
+		t.Errorf("expandName kept characters Mongo rejects: %q", got)
+	}
+
+	for tmpl, want := range tests {
+		if got := expandName(tmpl, vars); got != want {
+			t.Errorf("expandName(%q) = %q, want %q", tmpl, got, want)
+		}
+	}
+
+	if err := validateName("logs_{{team}}"); err == nil {
+		t.Error("validateName accepted an unknown placeholder")
+	}
+}
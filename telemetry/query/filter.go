@@ -0,0 +1,111 @@
+// Package query provides a typed API to search the entries stored by the telemetry hook.
+package query
+
+import (
+	"regexp"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Filter is a struct that holds the conditions entries must match.
+// Its methods add a condition and return the Filter so that calls can be chained.
+type Filter struct {
+	conds bson.A
+}
+
+// NewFilter is a function that creates an empty Filter, matching every entry.
+func NewFilter() *Filter {
+	return &Filter{}
+}
+
+// Since is a method that matches entries logged at or after t.
+func (f *Filter) Since(t time.Time) *Filter {
+	return f.add("trace_date", bson.D{{Key: "$gte", Value: t}})
+}
+
+// Until is a method that matches entries logged before t.
+func (f *Filter) Until(t time.Time) *Filter {
+	return f.add("trace_date", bson.D{{Key: "$lt", Value: t}})
+}
+
+// Between is a method that matches entries logged at or after from and before to.
+func (f *Filter) Between(from, to time.Time) *Filter {
+	return f.add("trace_date", bson.D{{Key: "$gte", Value: from}, {Key: "$lt", Value: to}})
+}
+
+// Level is a method that matches entries logged at one of the given levels, e.g. "error" or "warning".
+func (f *Filter) Level(levels ...string) *Filter {
+	return f.add("level", bson.D{{Key: "$in", Value: levels}})
+}
+
+// Service is a method that matches entries of the given service.
+func (f *Filter) Service(name string) *Filter {
+	return f.add("service", name)
+}
+
+// Environment is a method that matches entries of the given environment.
+func (f *Filter) Environment(name string) *Filter {
+	return f.add("environment", name)
+}
+
+// Func is a method that matches entries logged from the given function, e.g. "main.main".
+func (f *Filter) Func(name string) *Filter {
+	return f.add("func", name)
+}
+
+// File is a method that matches entries logged from the given file, whatever the line.
+func (f *Filter) File(name string) *Filter {
+	return f.add("file", primitive.Regex{Pattern: "^" + regexp.QuoteMeta(name) + ":"})
+}
+
+// Field is a method that matches entries whose custom field key equals value.
+func (f *Filter) Field(key string, value interface{}) *Filter {
+	return f.add("fields."+key, value)
+}
+
+// Text is a method that matches entries whose message contains s, case-insensitively.
+func (f *Filter) Text(s string) *Filter {
+	return f.add("message", primitive.Regex{Pattern: regexp.QuoteMeta(s), Options: "i"})
+}
+
+// RequestID is a method that matches entries of the given request.
+func (f *Filter) RequestID(id string) *Filter {
+	return f.add("request_id", id)
+}
+
+// TraceID is a method that matches entries of the given trace.
+func (f *Filter) TraceID(id string) *Filter {
+	return f.add("trace_id", id)
+}
+
+// BSON is a method that returns the filter as a MongoDB query document.
+func (f *Filter) BSON() bson.D {
+	if f == nil || len(f.conds) == 0 {
+		return bson.D{}
+	}
+
+	return bson.D{{Key: "$and", Value: f.conds}}
+}
+
+// prefixed is a method that returns the filter with every key prefixed, to match change stream documents.
+func (f *Filter) prefixed(prefix string) bson.D {
+	if f == nil || len(f.conds) == 0 {
+		return bson.D{}
+	}
+
+	conds := make(bson.A, 0, len(f.conds))
+	for _, c := range f.conds {
+		d := c.(bson.D)
+		conds = append(conds, bson.D{{Key: prefix + d[0].Key, Value: d[0].Value}})
+	}
+
+	return bson.D{{Key: "$and", Value: conds}}
+}
+
+// add is a method that appends a condition on key.
+func (f *Filter) add(key string, value interface{}) *Filter {
+	f.conds = append(f.conds, bson.D{{Key: key, Value: value}})
+	return f
+}
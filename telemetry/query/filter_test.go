@@ -0,0 +1,40 @@
+package query_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dyaksa/telemetry-log/telemetry/query"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestFilterBSON(t *testing.T) {
+	from := time.Date(2024, 6, 14, 0, 0, 0, 0, time.UTC)
+
+	f := query.NewFilter().
+		Between(from, from.Add(time.Hour)).
+		Level("error").
+		Field("user_id", 42).
+		Text("time.out")
+
+	got, err := bson.MarshalExtJSON(f.BSON(), false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `{"$and":[` +
+		`{"trace_date":{"$gte":{"$date":"2024-06-14T00:00:00Z"},"$lt":{"$date":"2024-06-14T01:00:00Z"}}},` +
+		`{"level":{"$in":["error"]}},` +
+		`{"fields.user_id":42},` +
+		`{"message":{"$regularExpression":{"pattern":"time\\.out","options":"i"}}}]}`
+
+	if string(got) != want {
+		t.Fatalf("got  %s\nwant %s", got, want)
+	}
+}
+
+func TestEmptyFilter(t *testing.T) {
+	if got := query.NewFilter().BSON(); len(got) != 0 {
+		t.Fatalf("got %v, want an empty document", got)
+	}
+}
@@ -0,0 +1,318 @@
+// Package query provides a typed API to search the entries stored by the telemetry hook.
+package query
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/dyaksa/telemetry-log/err"
+	"github.com/dyaksa/telemetry-log/telemetry/mongo"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// DefaultLimit is the number of entries returned per page when no limit is given.
+const DefaultLimit = 100
+
+// Order is a type that defines the order entries are returned in.
+type Order int
+
+// These constants represent the different orders.
+const (
+	Descending Order = iota // Descending returns the newest entries first.
+	Ascending               // Ascending returns the oldest entries first.
+)
+
+// Entry is a struct that holds an entry as stored by the telemetry hook.
+type Entry struct {
+	ID          primitive.ObjectID     `bson:"_id" json:"id"`
+	Level       string                 `bson:"level" json:"level"`
+	Time        time.Time              `bson:"trace_date" json:"time"`
+	Message     string                 `bson:"message" json:"msg"`
+	Func        string                 `bson:"func" json:"func"`
+	File        string                 `bson:"file" json:"file"`
+	Service     string                 `bson:"service,omitempty" json:"service,omitempty"`
+	Environment string                 `bson:"environment,omitempty" json:"environment,omitempty"`
+	RequestID   string                 `bson:"request_id,omitempty" json:"request_id,omitempty"`
+	TraceID     string                 `bson:"trace_id,omitempty" json:"trace_id,omitempty"`
+	Fields      map[string]interface{} `bson:"fields,omitempty" json:"fields,omitempty"`
+	Trace       []err.Stack            `bson:"trace,omitempty" json:"trace,omitempty"`
+}
+
+// Page is a struct that holds the entries of a page and the cursor of the next one.
+type Page struct {
+	Entries []Entry
+	Next    string // Next is the cursor of the next page, empty on the last page.
+}
+
+// OptFunc is a type that defines a function that modifies a Client instance.
+type OptFunc func(*Client) error
+
+// Client is a struct that holds the collections entries are searched in.
+type Client struct {
+	m           *mongo.Mongo
+	database    string
+	collections []string
+}
+
+// WithDatabase is a function that returns an OptFunc which sets the database searched, the client's default if empty.
+func WithDatabase(name string) OptFunc {
+	return func(c *Client) (err error) {
+		c.database = name
+		return
+	}
+}
+
+// WithCollection is a function that returns an OptFunc which sets the collection searched.
+func WithCollection(name string) OptFunc {
+	return WithCollections(name)
+}
+
+// WithCollections is a function that returns an OptFunc which sets the collections searched together, such as the
+// log and trace collections. Their entries are merged as if they were stored in a single collection.
+func WithCollections(names ...string) OptFunc {
+	return func(c *Client) (err error) {
+		if len(names) == 0 {
+			return errors.New("at least one collection must be given")
+		}
+
+		c.collections = c.collections[:0]
+		for _, name := range names {
+			if name == "" {
+				return errors.New("collection name must not be empty")
+			}
+			if !contains(c.collections, name) {
+				c.collections = append(c.collections, name)
+			}
+		}
+		return
+	}
+}
+
+// New is a function that creates a new Client searching the "application_log" collection by default.
+// It applies the provided options to the Client instance.
+func New(m *mongo.Mongo, opts ...OptFunc) (*Client, error) {
+	if m == nil {
+		return nil, errors.New("mongo client must not be nil")
+	}
+
+	c := &Client{m: m, collections: []string{"application_log"}}
+	for _, opt := range opts {
+		if err := opt(c); err != nil {
+			return nil, fmt.Errorf("fail to apply options: %w", err)
+		}
+	}
+
+	return c, nil
+}
+
+// FindOptions is a struct that holds the pagination and sorting of a search.
+type FindOptions struct {
+	Limit  int    // Limit is the maximum number of entries per page, DefaultLimit when zero.
+	Cursor string // Cursor is the Next value of the previous page, empty for the first page.
+	Order  Order  // Order is the order entries are returned in.
+}
+
+// Find is a method that returns a page of the entries matching f.
+// Pages are cursor-based: entries logged while paginating never shift the following pages.
+func (c *Client) Find(ctx context.Context, f *Filter, fo FindOptions) (*Page, error) {
+	if fo.Limit <= 0 {
+		fo.Limit = DefaultLimit
+	}
+
+	filter := f.BSON()
+	if fo.Cursor != "" {
+		cur, err := decodeCursor(fo.Cursor)
+		if err != nil {
+			return nil, err
+		}
+
+		filter = bson.D{{Key: "$and", Value: bson.A{filter, cur.after(fo.Order)}}}
+	}
+
+	dir := -1
+	if fo.Order == Ascending {
+		dir = 1
+	}
+
+	opt := options.Find().
+		SetSort(bson.D{{Key: "trace_date", Value: dir}, {Key: "_id", Value: dir}}).
+		SetLimit(int64(fo.Limit) + 1)
+
+	page := &Page{}
+	for _, name := range c.collections {
+		cursor, err := c.m.CollectionIn(c.database, name).Find(ctx, filter, opt)
+		if err != nil {
+			return nil, fmt.Errorf("fail to find entries in %s: %w", name, err)
+		}
+
+		var entries []Entry
+		if err = cursor.All(ctx, &entries); err != nil {
+			return nil, fmt.Errorf("fail to decode entries: %w", err)
+		}
+		page.Entries = append(page.Entries, entries...)
+	}
+
+	if len(c.collections) > 1 {
+		sort.SliceStable(page.Entries, func(i, j int) bool {
+			return fo.Order.before(page.Entries[i], page.Entries[j])
+		})
+	}
+
+	if len(page.Entries) > fo.Limit {
+		page.Entries = page.Entries[:fo.Limit]
+		last := page.Entries[fo.Limit-1]
+		page.Next = CursorAfter(last)
+	}
+
+	return page, nil
+}
+
+// before is a method that reports whether entry a comes before entry b in the order.
+func (o Order) before(a, b Entry) bool {
+	cmp := a.Time.Compare(b.Time)
+	if cmp == 0 {
+		cmp = bytes.Compare(a.ID[:], b.ID[:])
+	}
+
+	if o == Ascending {
+		return cmp < 0
+	}
+	return cmp > 0
+}
+
+// Count is a method that returns the number of entries matching f.
+func (c *Client) Count(ctx context.Context, f *Filter) (total int64, err error) {
+	for _, name := range c.collections {
+		n, err := c.m.CollectionIn(c.database, name).CountDocuments(ctx, f.BSON())
+		if err != nil {
+			return 0, fmt.Errorf("fail to count entries in %s: %w", name, err)
+		}
+		total += n
+	}
+
+	return total, nil
+}
+
+// Group is a struct that holds the number of entries sharing the same value of a field.
+type Group struct {
+	Key   interface{} `bson:"_id" json:"key"`
+	Count int64       `bson:"count" json:"count"`
+}
+
+// CountBy is a method that returns the number of entries matching f for each value of field, most frequent first.
+// The field is a document key such as "level", "func", "service" or "fields.route".
+func (c *Client) CountBy(ctx context.Context, f *Filter, field string) ([]Group, error) {
+	pipeline := bson.A{
+		bson.D{{Key: "$match", Value: f.BSON()}},
+		bson.D{{Key: "$group", Value: bson.D{{Key: "_id", Value: "$" + field}, {Key: "count", Value: bson.D{{Key: "$sum", Value: 1}}}}}},
+		bson.D{{Key: "$sort", Value: bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}}},
+	}
+
+	var groups []Group
+	for _, name := range c.collections {
+		cursor, err := c.m.CollectionIn(c.database, name).Aggregate(ctx, pipeline)
+		if err != nil {
+			return nil, fmt.Errorf("fail to aggregate entries in %s: %w", name, err)
+		}
+
+		var found []Group
+		if err = cursor.All(ctx, &found); err != nil {
+			return nil, fmt.Errorf("fail to decode groups: %w", err)
+		}
+		groups = mergeGroups(groups, found)
+	}
+
+	if len(c.collections) > 1 {
+		sort.SliceStable(groups, func(i, j int) bool {
+			if groups[i].Count != groups[j].Count {
+				return groups[i].Count > groups[j].Count
+			}
+			return fmt.Sprint(groups[i].Key) < fmt.Sprint(groups[j].Key)
+		})
+	}
+
+	return groups, nil
+}
+
+// mergeGroups is a function that adds the counts of found to the groups with the same key, appending new keys.
+func mergeGroups(groups, found []Group) []Group {
+	for _, g := range found {
+		merged := false
+		for i := range groups {
+			if reflect.DeepEqual(groups[i].Key, g.Key) {
+				groups[i].Count += g.Count
+				merged = true
+				break
+			}
+		}
+
+		if !merged {
+			groups = append(groups, g)
+		}
+	}
+
+	return groups
+}
+
+// contains is a function that reports whether names holds name.
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// cursorPos is a struct that holds the position of the last entry of a page.
+type cursorPos struct {
+	Time time.Time          `json:"t"`
+	ID   primitive.ObjectID `json:"id"`
+}
+
+// after is a method that returns the condition matching the entries following the position in the given order.
+func (p cursorPos) after(o Order) bson.D {
+	op := "$lt"
+	if o == Ascending {
+		op = "$gt"
+	}
+
+	return bson.D{{Key: "$or", Value: bson.A{
+		bson.D{{Key: "trace_date", Value: bson.D{{Key: op, Value: p.Time}}}},
+		bson.D{{Key: "trace_date", Value: p.Time}, {Key: "_id", Value: bson.D{{Key: op, Value: p.ID}}}},
+	}}}
+}
+
+// CursorAfter is a function that returns the cursor of the entries following e, as used to follow new entries.
+func CursorAfter(e Entry) string {
+	return encodeCursor(cursorPos{Time: e.Time, ID: e.ID})
+}
+
+// encodeCursor is a function that returns the opaque form of a position.
+func encodeCursor(p cursorPos) string {
+	b, _ := json.Marshal(p)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// decodeCursor is a function that parses a cursor returned by encodeCursor.
+func decodeCursor(s string) (p cursorPos, err error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err == nil {
+		err = json.Unmarshal(b, &p)
+	}
+	if err != nil {
+		return p, fmt.Errorf("invalid cursor: %w", err)
+	}
+
+	return p, nil
+}
@@ -0,0 +1,390 @@
+// Package query provides a typed API to search the entries stored by the telemetry hook.
+package query
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// These constants are the server error codes the change stream mode handles.
+const (
+	commandNotSupported     = 115   // commandNotSupported is returned by deployments that do not offer change streams.
+	changeStreamFatal       = 280   // changeStreamFatal is returned by servers before 4.4 when the resume token is gone.
+	changeStreamHistoryLost = 286   // changeStreamHistoryLost is returned when the resume token left the oplog.
+	unknownStage            = 40324 // unknownStage is returned by servers before 3.6, which do not know $changeStream.
+	replicaSetOnly          = 40573 // replicaSetOnly is returned by standalone servers.
+)
+
+// ErrHistoryLost is returned by Watch when the saved change stream position is no longer in the oplog, so the
+// entries stored since then cannot be delivered. The caller decides whether to reset the token store and watch
+// from now on, or to search the gap first.
+var ErrHistoryLost = errors.New("change stream history lost")
+
+// These constants are the prefixes of the tokens saved by each watch mode.
+const (
+	tokenChangeStream = "cs:"  // tokenChangeStream prefixes a change stream resume token, as extended JSON.
+	tokenPosition     = "pos:" // tokenPosition prefixes the cursor of the last entry read by polling or tailing.
+)
+
+// TokenStore is an interface that defines methods for persisting the position of a watch across restarts.
+type TokenStore interface {
+	Load() (string, error)   // Load returns the last saved token, or an empty string if there is none.
+	Save(token string) error // Save persists the token.
+}
+
+// FileTokenStore is a struct that persists watch tokens in a file.
+type FileTokenStore struct {
+	Path string // Path is the file the token is saved to.
+}
+
+// Load is a method that reads the token from the file, returning an empty token when the file does not exist.
+func (s *FileTokenStore) Load() (string, error) {
+	b, err := os.ReadFile(s.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("fail to read token: %w", err)
+	}
+
+	return strings.TrimSpace(string(b)), nil
+}
+
+// Save is a method that writes the token atomically, through a temporary file renamed over the previous one.
+func (s *FileTokenStore) Save(token string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(s.Path), filepath.Base(s.Path)+".*")
+	if err != nil {
+		return fmt.Errorf("fail to save token: %w", err)
+	}
+
+	_, err = tmp.WriteString(token)
+	err = errors.Join(err, tmp.Close())
+	if err == nil {
+		err = os.Rename(tmp.Name(), s.Path)
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return fmt.Errorf("fail to save token: %w", err)
+	}
+
+	return nil
+}
+
+// WatchOptions is a struct that holds the settings of a watch.
+type WatchOptions struct {
+	Tokens       TokenStore    // Tokens persists the position of the watch, nothing is persisted when nil.
+	PollInterval time.Duration // PollInterval is the delay between two polls when change streams are unavailable, 2s when zero.
+	Buffer       int           // Buffer is the capacity of the entries channel.
+}
+
+// Watch is a method that delivers the entries matching f as they are stored, until ctx is done.
+// It uses a change stream when the server supports it, and otherwise tails the collection when it is capped
+// or polls it. The position is saved to the token store after every batch, so a restarted watch resumes
+// where the previous one stopped, and ErrHistoryLost is reported when it cannot. Both channels are closed
+// when the watch ends; a fatal error is sent on the error channel first.
+func (c *Client) Watch(ctx context.Context, f *Filter, wo WatchOptions) (<-chan Entry, <-chan error) {
+	if wo.PollInterval <= 0 {
+		wo.PollInterval = 2 * time.Second
+	}
+
+	entries := make(chan Entry, wo.Buffer)
+	errs := make(chan error, 1)
+
+	go func() {
+		defer close(errs)
+		defer close(entries)
+
+		w := &watcher{c: c, f: f, wo: wo, out: entries}
+		if err := w.run(ctx); err != nil && ctx.Err() == nil {
+			errs <- err
+		}
+	}()
+
+	return entries, errs
+}
+
+// errUnsupported is returned when change streams are not available on the watched collection.
+var errUnsupported = errors.New("change streams unsupported")
+
+// watcher is a struct that holds the state of a running watch.
+type watcher struct {
+	c   *Client
+	f   *Filter
+	wo  WatchOptions
+	out chan<- Entry
+}
+
+// run is a method that watches the collection with the best mode available.
+func (w *watcher) run(ctx context.Context) error {
+	token, err := w.load()
+	if err != nil {
+		return err
+	}
+
+	if token == "" || strings.HasPrefix(token, tokenChangeStream) {
+		err = w.changeStream(ctx, strings.TrimPrefix(token, tokenChangeStream))
+		if !errors.Is(err, errUnsupported) {
+			return err
+		}
+		if token != "" {
+			// The saved position belongs to a change stream that cannot be reopened, polling would skip the gap.
+			return fmt.Errorf("%w: change streams are no longer available", ErrHistoryLost)
+		}
+	}
+
+	pos := cursorPos{Time: time.Now()}
+	if token != "" {
+		if pos, err = decodeCursor(strings.TrimPrefix(token, tokenPosition)); err != nil {
+			return err
+		}
+	}
+
+	capped, err := w.capped(ctx)
+	if err != nil {
+		return err
+	}
+
+	if capped {
+		return w.tail(ctx, pos)
+	}
+	return w.poll(ctx, pos)
+}
+
+// changeStream is a method that delivers the inserted entries through a change stream.
+func (w *watcher) changeStream(ctx context.Context, token string) error {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.D{{Key: "operationType", Value: "insert"}}}},
+		{{Key: "$match", Value: w.f.prefixed("fullDocument.")}},
+	}
+
+	watch := w.collection().Watch
+	if len(w.c.collections) > 1 {
+		// Several collections are watched through one stream on the database, restricted to their namespaces.
+		pipeline = append(pipeline, bson.D{{Key: "$match", Value: bson.D{
+			{Key: "ns.coll", Value: bson.D{{Key: "$in", Value: w.c.collections}}},
+		}}})
+		watch = w.c.m.Database(w.c.database).Watch
+	}
+
+	opt := options.ChangeStream()
+	if token != "" {
+		var raw bson.Raw
+		if err := bson.UnmarshalExtJSON([]byte(token), false, &raw); err != nil {
+			return fmt.Errorf("invalid resume token: %w", err)
+		}
+		opt.SetResumeAfter(raw)
+	}
+
+	stream, err := watch(ctx, pipeline, opt)
+	if err != nil {
+		switch {
+		case historyLost(err):
+			return fmt.Errorf("%w: %w", ErrHistoryLost, err)
+		case changeStreamUnsupported(err):
+			return errUnsupported
+		}
+		return fmt.Errorf("fail to open change stream: %w", err)
+	}
+	defer func() { _ = stream.Close(context.Background()) }()
+
+	for stream.Next(ctx) {
+		var event struct {
+			FullDocument Entry `bson:"fullDocument"`
+		}
+		if err = stream.Decode(&event); err != nil {
+			return fmt.Errorf("fail to decode change event: %w", err)
+		}
+
+		if !w.send(ctx, event.FullDocument) {
+			return nil
+		}
+
+		if stream.RemainingBatchLength() == 0 {
+			if err = w.save(tokenChangeStream + stream.ResumeToken().String()); err != nil {
+				return err
+			}
+		}
+	}
+
+	if ctx.Err() != nil {
+		return nil
+	}
+	if err = stream.Err(); historyLost(err) {
+		return fmt.Errorf("%w: %w", ErrHistoryLost, err)
+	}
+	return fmt.Errorf("change stream ended: %w", err)
+}
+
+// changeStreamUnsupported is a function that reports whether err is the answer of a deployment without change
+// streams. Other errors, such as authorization ones, are returned rather than hidden by a fallback to polling.
+func changeStreamUnsupported(err error) bool {
+	var se mongo.ServerError
+	if !errors.As(err, &se) {
+		return false
+	}
+
+	return se.HasErrorCode(replicaSetOnly) || se.HasErrorCode(unknownStage) || se.HasErrorCode(commandNotSupported)
+}
+
+// historyLost is a function that reports whether err says the resume token is no longer in the oplog.
+func historyLost(err error) bool {
+	var se mongo.ServerError
+	if !errors.As(err, &se) {
+		return false
+	}
+
+	return se.HasErrorCode(changeStreamHistoryLost) || se.HasErrorCode(changeStreamFatal)
+}
+
+// tail is a method that delivers the entries following pos through a tailable cursor on a capped collection.
+func (w *watcher) tail(ctx context.Context, pos cursorPos) error {
+	for ctx.Err() == nil {
+		filter := bson.D{{Key: "$and", Value: bson.A{w.f.BSON(), pos.after(Ascending)}}}
+		opt := options.Find().SetCursorType(options.TailableAwait).SetMaxAwaitTime(w.wo.PollInterval)
+
+		cursor, err := w.collection().Find(ctx, filter, opt)
+		if err != nil {
+			return fmt.Errorf("fail to open tailable cursor: %w", err)
+		}
+
+		for cursor.Next(ctx) {
+			var e Entry
+			if err = cursor.Decode(&e); err != nil {
+				_ = cursor.Close(context.Background())
+				return fmt.Errorf("fail to decode entry: %w", err)
+			}
+
+			if !w.send(ctx, e) {
+				_ = cursor.Close(context.Background())
+				return nil
+			}
+			pos = cursorPos{Time: e.Time, ID: e.ID}
+
+			if cursor.RemainingBatchLength() == 0 {
+				if err = w.save(tokenPosition + encodeCursor(pos)); err != nil {
+					_ = cursor.Close(context.Background())
+					return err
+				}
+			}
+		}
+		_ = cursor.Close(context.Background())
+
+		// The cursor dies when the collection is empty or the position was overwritten; open a new one.
+		if !w.sleep(ctx) {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// poll is a method that delivers the entries following pos by querying the collection periodically.
+func (w *watcher) poll(ctx context.Context, pos cursorPos) error {
+	cursor := encodeCursor(pos)
+
+	for {
+		page, err := w.c.Find(ctx, w.f, FindOptions{Cursor: cursor, Order: Ascending})
+		if err != nil {
+			return err
+		}
+
+		for _, e := range page.Entries {
+			if !w.send(ctx, e) {
+				return nil
+			}
+		}
+
+		if n := len(page.Entries); n > 0 {
+			cursor = CursorAfter(page.Entries[n-1])
+			if err = w.save(tokenPosition + cursor); err != nil {
+				return err
+			}
+		}
+
+		if page.Next == "" && !w.sleep(ctx) {
+			return nil
+		}
+	}
+}
+
+// capped is a method that reports whether the watched collection is capped. Several collections are never
+// tailed, since one tailable cursor cannot follow them all.
+func (w *watcher) capped(ctx context.Context) (bool, error) {
+	if len(w.c.collections) > 1 {
+		return false, nil
+	}
+
+	db := w.c.m.Database(w.c.database)
+
+	specs, err := db.ListCollectionSpecifications(ctx, bson.D{{Key: "name", Value: w.c.collections[0]}})
+	if err != nil {
+		return false, fmt.Errorf("fail to inspect collection: %w", err)
+	}
+	if len(specs) == 0 {
+		return false, nil
+	}
+
+	var opts struct {
+		Capped bool `bson:"capped"`
+	}
+	if specs[0].Options != nil {
+		_ = bson.Unmarshal(specs[0].Options, &opts)
+	}
+
+	return opts.Capped, nil
+}
+
+// collection is a method that returns the first watched collection.
+func (w *watcher) collection() *mongo.Collection {
+	return w.c.m.CollectionIn(w.c.database, w.c.collections[0])
+}
+
+// send is a method that delivers an entry, reporting false when ctx is done.
+func (w *watcher) send(ctx context.Context, e Entry) bool {
+	select {
+	case w.out <- e:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// sleep is a method that waits for the poll interval, reporting false when ctx is done.
+func (w *watcher) sleep(ctx context.Context) bool {
+	t := time.NewTimer(w.wo.PollInterval)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// load is a method that returns the saved token, if a store is configured.
+func (w *watcher) load() (string, error) {
+	if w.wo.Tokens == nil {
+		return "", nil
+	}
+
+	return w.wo.Tokens.Load()
+}
+
+// save is a method that saves the token, if a store is configured.
+func (w *watcher) save(token string) error {
+	if w.wo.Tokens == nil {
+		return nil
+	}
+
+	return w.wo.Tokens.Save(token)
+}
@@ -0,0 +1,89 @@
+package query
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestFileTokenStore(t *testing.T) {
+	dir := t.TempDir()
+	s := &FileTokenStore{Path: filepath.Join(dir, "watch.token")}
+
+	token, err := s.Load()
+	if err != nil || token != "" {
+		t.Fatalf("Load() on a missing file = %q, %v, want an empty token", token, err)
+	}
+
+	for _, want := range []string{"cs:{\"_data\":\"8265\"}", "pos:abc"} {
+		if err = s.Save(want); err != nil {
+			t.Fatal(err)
+		}
+		if token, err = s.Load(); err != nil || token != want {
+			t.Fatalf("Load() = %q, %v, want %q", token, err, want)
+		}
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Errorf("got %d files, want the token file only", len(files))
+	}
+
+	bad := &FileTokenStore{Path: filepath.Join(dir, "missing", "watch.token")}
+	if err = bad.Save("pos:abc"); err == nil {
+		t.Error("Save() into a missing directory succeeded")
+	}
+}
+
+func TestFilterPrefixed(t *testing.T) {
+	from := time.Date(2024, 6, 14, 0, 0, 0, 0, time.UTC)
+
+	got, err := bson.MarshalExtJSON(NewFilter().Since(from).Level("error").Field("user_id", 42).prefixed("fullDocument."), false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `{"$and":[` +
+		`{"fullDocument.trace_date":{"$gte":{"$date":"2024-06-14T00:00:00Z"}}},` +
+		`{"fullDocument.level":{"$in":["error"]}},` +
+		`{"fullDocument.fields.user_id":42}]}`
+	if string(got) != want {
+		t.Errorf("prefixed() =\n%s\nwant\n%s", got, want)
+	}
+
+	if d := NewFilter().prefixed("fullDocument."); len(d) != 0 {
+		t.Errorf("prefixed() of an empty filter = %v, want an empty document", d)
+	}
+}
+
+func TestChangeStreamErrors(t *testing.T) {
+	cases := []struct {
+		err         error
+		unsupported bool
+		lost        bool
+	}{
+		{mongo.CommandError{Code: replicaSetOnly}, true, false},
+		{mongo.CommandError{Code: unknownStage}, true, false},
+		{mongo.CommandError{Code: changeStreamHistoryLost}, false, true},
+		{mongo.CommandError{Code: changeStreamFatal}, false, true},
+		{mongo.CommandError{Code: 13, Name: "Unauthorized"}, false, false},
+		{errors.New("connection refused"), false, false},
+	}
+
+	for _, c := range cases {
+		if got := changeStreamUnsupported(c.err); got != c.unsupported {
+			t.Errorf("changeStreamUnsupported(%v) = %v, want %v", c.err, got, c.unsupported)
+		}
+		if got := historyLost(c.err); got != c.lost {
+			t.Errorf("historyLost(%v) = %v, want %v", c.err, got, c.lost)
+		}
+	}
+}
@@ -0,0 +1,426 @@
+// Package redact provides a redaction pipeline that removes personal data and secrets from log fields.
+package redact
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"path"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Action is a type that defines what happens to a value matched by a rule.
+type Action int
+
+// These constants represent the different redaction actions.
+const (
+	Mask Action = iota // Mask replaces the value with the mask string.
+	Hash               // Hash replaces the value with a truncated SHA-256 of it.
+	Drop               // Drop removes the field.
+)
+
+// DefaultMask is the string a masked value is replaced with.
+const DefaultMask = "[REDACTED]"
+
+// maxDepth is the maximum nesting depth walked into maps, slices and structs.
+const maxDepth = 8
+
+// These patterns match common personal data and secrets found in field values.
+var (
+	CreditCard = regexp.MustCompile(`\b(?:\d[ -]?){12,18}\d\b`)                            // CreditCard matches card numbers of 13 to 19 digits, see Luhn.
+	JWT        = regexp.MustCompile(`\beyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`) // JWT matches JSON web tokens.
+	Email      = regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)      // Email matches email addresses.
+)
+
+// validators are the checks a match of a pattern must pass to be redacted, so that timestamps and numeric IDs
+// of the same length as a card number are left alone.
+var validators = map[*regexp.Regexp]func(string) bool{CreditCard: Luhn}
+
+// jsonMarshaler is the type of json.Marshaler, whose implementations are logged as they marshal themselves.
+var jsonMarshaler = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+
+// OptFunc is a type that defines a function that modifies a Redactor instance.
+type OptFunc func(*Redactor) error
+
+// keyRule is a struct that holds a rule matching field names.
+type keyRule struct {
+	glob   string
+	action Action
+}
+
+// valueRule is a struct that holds a rule matching field values.
+type valueRule struct {
+	pattern *regexp.Regexp
+	valid   func(string) bool
+	action  Action
+}
+
+// Redactor is a struct that holds the redaction rules applied to log fields.
+type Redactor struct {
+	keys   []keyRule
+	values []valueRule
+	mask   string
+	salt   string
+
+	walk sync.Map // walk caches whether the fields of a struct type may need redaction.
+}
+
+// WithKey is a function that returns an OptFunc which applies action to the fields whose name matches glob.
+// The glob is matched, case-insensitively, against both the field name and its dotted path.
+func WithKey(glob string, action Action) OptFunc {
+	return func(r *Redactor) (err error) {
+		glob = strings.ToLower(glob)
+		if _, err = path.Match(glob, ""); err != nil {
+			return fmt.Errorf("invalid key glob %q: %w", glob, err)
+		}
+
+		r.keys = append(r.keys, keyRule{glob: glob, action: action})
+		return
+	}
+}
+
+// WithPattern is a function that returns an OptFunc which applies action to the string values matching pattern.
+// Mask and Hash only replace the matching parts of the value, Drop removes the whole field.
+// Matches of CreditCard are only redacted when they pass the Luhn check.
+func WithPattern(pattern *regexp.Regexp, action Action) OptFunc {
+	return func(r *Redactor) (err error) {
+		if pattern == nil {
+			return fmt.Errorf("pattern must not be nil")
+		}
+
+		r.values = append(r.values, valueRule{pattern: pattern, valid: validators[pattern], action: action})
+		return
+	}
+}
+
+// WithMask is a function that returns an OptFunc which sets the string masked values are replaced with.
+func WithMask(mask string) OptFunc {
+	return func(r *Redactor) (err error) {
+		r.mask = mask
+		return
+	}
+}
+
+// WithSalt is a function that returns an OptFunc which sets the salt mixed into hashed values.
+func WithSalt(salt string) OptFunc {
+	return func(r *Redactor) (err error) {
+		r.salt = salt
+		return
+	}
+}
+
+// WithDefaults is a function that returns an OptFunc which adds rules for common secrets and personal data.
+// Passwords, secrets, tokens, API keys and authorization headers are masked by name; credit card numbers,
+// JWTs and email addresses are masked wherever they appear in a value.
+func WithDefaults() OptFunc {
+	return func(r *Redactor) (err error) {
+		for _, glob := range []string{"*password*", "*secret*", "*token*", "*api_key*", "*apikey*", "authorization"} {
+			r.keys = append(r.keys, keyRule{glob: glob, action: Mask})
+		}
+
+		for _, re := range []*regexp.Regexp{CreditCard, JWT, Email} {
+			r.values = append(r.values, valueRule{pattern: re, valid: validators[re], action: Mask})
+		}
+		return
+	}
+}
+
+// New is a function that creates a new Redactor instance.
+// It applies the provided options to the Redactor instance.
+func New(opts ...OptFunc) (*Redactor, error) {
+	r := &Redactor{mask: DefaultMask}
+	for _, opt := range opts {
+		if err := opt(r); err != nil {
+			return nil, fmt.Errorf("fail to apply options: %w", err)
+		}
+	}
+
+	return r, nil
+}
+
+// Redact is a method that applies the rules to fields in place.
+// Nested maps, slices and structs are copied rather than modified. Structs are converted to maps when one of their
+// fields may need redaction: it carries a `log:"redact"`, `log:"hash"` or `log:"drop"` tag, its name matches a key
+// rule, or its value may hold data matched by a rule.
+func (r *Redactor) Redact(fields map[string]interface{}) {
+	r.redactMap("", fields, 0)
+}
+
+// redactMap is a method that applies the rules to every entry of m in place.
+func (r *Redactor) redactMap(prefix string, m map[string]interface{}, depth int) {
+	for key, value := range m {
+		p := key
+		if prefix != "" {
+			p = prefix + "." + key
+		}
+
+		if action, ok := r.matchKey(key, p); ok {
+			if action == Drop {
+				delete(m, key)
+				continue
+			}
+			m[key] = r.apply(action, fmt.Sprint(value))
+			continue
+		}
+
+		if v, keep := r.value(p, value, depth); keep {
+			m[key] = v
+		} else {
+			delete(m, key)
+		}
+	}
+}
+
+// matchKey is a method that returns the action of the first key rule matching the field name or path.
+func (r *Redactor) matchKey(key, p string) (Action, bool) {
+	key, p = strings.ToLower(key), strings.ToLower(p)
+	for _, rule := range r.keys {
+		if ok, _ := path.Match(rule.glob, key); ok {
+			return rule.action, true
+		}
+		if ok, _ := path.Match(rule.glob, p); ok {
+			return rule.action, true
+		}
+	}
+
+	return 0, false
+}
+
+// value is a method that returns the redacted form of v and whether the field must be kept.
+func (r *Redactor) value(p string, v interface{}, depth int) (interface{}, bool) {
+	if v == nil || depth > maxDepth {
+		return v, true
+	}
+
+	switch x := v.(type) {
+	case string:
+		return r.redactString(x)
+	case []byte:
+		if s, keep := r.redactString(string(x)); s != string(x) || !keep {
+			return s, keep
+		}
+		return v, true
+	case error:
+		if s, keep := r.redactString(x.Error()); s != x.Error() || !keep {
+			return s, keep
+		}
+		return v, true
+	}
+
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Pointer {
+		if rv.IsNil() {
+			return v, true
+		}
+		rv = rv.Elem()
+	}
+
+	switch rv.Kind() {
+	case reflect.Struct:
+		if !r.needsWalk(rv.Type()) && !r.matchesFields(p, rv.Type()) {
+			return v, true
+		}
+		return r.redactStruct(p, rv, depth), true
+	case reflect.Map:
+		if rv.Type().Key().Kind() != reflect.String {
+			return v, true
+		}
+		m := make(map[string]interface{}, rv.Len())
+		iter := rv.MapRange()
+		for iter.Next() {
+			m[iter.Key().String()] = iter.Value().Interface()
+		}
+		r.redactMap(p, m, depth+1)
+		return m, true
+	case reflect.Slice, reflect.Array:
+		if !r.walkable(rv.Type().Elem()) {
+			return v, true
+		}
+		s := make([]interface{}, 0, rv.Len())
+		for i := 0; i < rv.Len(); i++ {
+			if e, keep := r.value(p, rv.Index(i).Interface(), depth+1); keep {
+				s = append(s, e)
+			}
+		}
+		return s, true
+	}
+
+	return v, true
+}
+
+// walkable is a method that reports whether values of type t, such as slice elements or struct fields, may need redaction.
+func (r *Redactor) walkable(t reflect.Type) bool {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return len(r.values) > 0
+	case reflect.Interface, reflect.Map:
+		return true
+	case reflect.Slice, reflect.Array:
+		return r.walkable(t.Elem())
+	case reflect.Struct:
+		return r.needsWalk(t)
+	}
+	return false
+}
+
+// redactStruct is a method that converts a tagged struct to a map, applying the actions of its log tags.
+func (r *Redactor) redactStruct(p string, rv reflect.Value, depth int) map[string]interface{} {
+	t := rv.Type()
+	m := make(map[string]interface{}, t.NumField())
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+
+		name := fieldName(f)
+		value := rv.Field(i).Interface()
+		switch f.Tag.Get("log") {
+		case "drop", "-":
+			continue
+		case "redact", "mask":
+			m[name] = r.mask
+			continue
+		case "hash":
+			m[name] = r.hash(fmt.Sprint(value))
+			continue
+		}
+
+		m[name] = value
+	}
+
+	r.redactMap(p, m, depth+1)
+	return m
+}
+
+// needsWalk is a method that reports whether a field of a struct type may need redaction: it has a log tag, its name
+// matches a key rule, or its type may hold data to redact. Types marshaling themselves to JSON are only walked for
+// their log tags.
+func (r *Redactor) needsWalk(t reflect.Type) bool {
+	if v, ok := r.walk.Load(t); ok {
+		return v.(bool)
+	}
+
+	// A recursive type is decided by its other fields.
+	r.walk.Store(t, false)
+
+	marshaler := t.Implements(jsonMarshaler) || reflect.PointerTo(t).Implements(jsonMarshaler)
+	needs := false
+	for i := 0; i < t.NumField() && !needs; i++ {
+		f := t.Field(i)
+		if _, ok := f.Tag.Lookup("log"); ok {
+			needs = true
+			continue
+		}
+		if marshaler || !f.IsExported() {
+			continue
+		}
+
+		_, matched := r.matchKey(fieldName(f), fieldName(f))
+		needs = matched || r.walkable(f.Type)
+	}
+
+	r.walk.Store(t, needs)
+	return needs
+}
+
+// matchesFields is a method that reports whether the path of a field of a struct type at p matches a key rule.
+func (r *Redactor) matchesFields(p string, t reflect.Type) bool {
+	if p == "" || len(r.keys) == 0 {
+		return false
+	}
+
+	for i := 0; i < t.NumField(); i++ {
+		if f := t.Field(i); f.IsExported() {
+			if _, ok := r.matchKey(fieldName(f), p+"."+fieldName(f)); ok {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// fieldName is a function that returns the name a struct field is logged under, its JSON name when it has one.
+func fieldName(f reflect.StructField) string {
+	if tag, _, _ := strings.Cut(f.Tag.Get("json"), ","); tag != "" && tag != "-" {
+		return tag
+	}
+
+	return f.Name
+}
+
+// redactString is a method that applies the value rules to s and reports whether the field must be kept.
+func (r *Redactor) redactString(s string) (string, bool) {
+	for _, rule := range r.values {
+		matched := false
+		redacted := rule.pattern.ReplaceAllStringFunc(s, func(match string) string {
+			if rule.valid != nil && !rule.valid(match) {
+				return match
+			}
+
+			matched = true
+			return r.apply(rule.action, match)
+		})
+		if !matched {
+			continue
+		}
+
+		if rule.action == Drop {
+			return "", false
+		}
+		s = redacted
+	}
+
+	return s, true
+}
+
+// Luhn is a function that reports whether the digits of s, ignoring spaces and dashes, pass the Luhn checksum
+// of card numbers.
+func Luhn(s string) bool {
+	sum, n := 0, 0
+	for i := len(s) - 1; i >= 0; i-- {
+		c := s[i]
+		if c == ' ' || c == '-' {
+			continue
+		}
+		if c < '0' || c > '9' {
+			return false
+		}
+
+		d := int(c - '0')
+		if n%2 == 1 {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		n++
+	}
+
+	return n > 0 && sum%10 == 0
+}
+
+// apply is a method that returns s masked or hashed according to action.
+func (r *Redactor) apply(action Action, s string) string {
+	if action == Hash {
+		return r.hash(s)
+	}
+
+	return r.mask
+}
+
+// hash is a method that returns a truncated, salted SHA-256 of s.
+func (r *Redactor) hash(s string) string {
+	sum := sha256.Sum256([]byte(r.salt + s))
+	return "sha256:" + hex.EncodeToString(sum[:8])
+}
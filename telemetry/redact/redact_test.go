@@ -0,0 +1,84 @@
+package redact_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/dyaksa/telemetry-log/telemetry/redact"
+)
+
+type user struct {
+	Name  string `json:"name"`
+	Email string `json:"email" log:"hash"`
+	Card  string `json:"card" log:"redact"`
+	Pin   string `log:"drop"`
+}
+
+func TestRedact(t *testing.T) {
+	r, err := redact.New(redact.WithDefaults(), redact.WithKey("*.session", redact.Drop))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fields := map[string]interface{}{
+		"db_password": "hunter2",
+		"note":        "paid with 4111 1111 1111 1111 today",
+		"user":        user{Name: "ann", Email: "ann@example.com", Card: "4111111111111111", Pin: "1234"},
+		"request":     map[string]interface{}{"session": "abc", "path": "/login"},
+	}
+
+	r.Redact(fields)
+
+	if fields["db_password"] != redact.DefaultMask {
+		t.Errorf("password not masked: %v", fields["db_password"])
+	}
+	if note := fields["note"].(string); strings.Contains(note, "4111") {
+		t.Errorf("card number not masked: %q", note)
+	}
+
+	u := fields["user"].(map[string]interface{})
+	if u["name"] != "ann" || u["card"] != redact.DefaultMask {
+		t.Errorf("unexpected user: %v", u)
+	}
+	if email := u["email"].(string); !strings.HasPrefix(email, "sha256:") {
+		t.Errorf("email not hashed: %q", email)
+	}
+	if _, ok := u["Pin"]; ok {
+		t.Errorf("pin not dropped: %v", u)
+	}
+
+	req := fields["request"].(map[string]interface{})
+	if _, ok := req["session"]; ok || req["path"] != "/login" {
+		t.Errorf("unexpected request: %v", req)
+	}
+}
+
+func TestRedactUntaggedStruct(t *testing.T) {
+	r, err := redact.New(redact.WithDefaults())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type config struct {
+		Host     string
+		Password string
+		Owner    user
+	}
+	fields := map[string]interface{}{
+		"cfg":        config{Host: "db", Password: "hunter2", Owner: user{Name: "ann", Card: "4111111111111111"}},
+		"started_ns": "1718359200123456789",
+	}
+
+	r.Redact(fields)
+
+	cfg := fields["cfg"].(map[string]interface{})
+	if cfg["Host"] != "db" || cfg["Password"] != redact.DefaultMask {
+		t.Errorf("unexpected config: %v", cfg)
+	}
+	if owner := cfg["Owner"].(map[string]interface{}); owner["card"] != redact.DefaultMask {
+		t.Errorf("nested tagged struct not redacted: %v", owner)
+	}
+	if fields["started_ns"] != "1718359200123456789" {
+		t.Errorf("timestamp masked as a card number: %v", fields["started_ns"])
+	}
+}
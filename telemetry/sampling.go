@@ -0,0 +1,55 @@
+// Package telemetry provides functionality for telemetry logging.
+package telemetry
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/dyaksa/telemetry-log/cmd"
+	"github.com/sirupsen/logrus"
+)
+
+// WithSampling is a function that returns an OptFunc which samples the entries logged at the given level.
+// Within every interval the first entries with the same message and caller are kept, then every thereafter-th one.
+func WithSampling(level string, first, thereafter int, interval time.Duration) OptFunc {
+	return func(li *Lib) (err error) {
+		lvl := cmd.ParseLevel(level)
+		if lvl < cmd.LevelDebug {
+			return fmt.Errorf("invalid sampling level: %s", level)
+		}
+
+		li.logOpt = append(li.logOpt, cmd.WithSampling(lvl, first, thereafter, interval))
+		return
+	}
+}
+
+// WithRateLimit is a function that returns an OptFunc which limits every sink to perSecond entries per second.
+// Bursts of up to burst entries are let through; entries over the limit are dropped and reported in a summary entry.
+func WithRateLimit(perSecond float64, burst int) OptFunc {
+	return func(li *Lib) (err error) {
+		if perSecond <= 0 || burst <= 0 {
+			return fmt.Errorf("invalid rate limit: %v/s burst %d", perSecond, burst)
+		}
+
+		li.rateLimit = perSecond
+		li.rateBurst = burst
+		return
+	}
+}
+
+// WithDropSummary is a function that returns an OptFunc which sets the interval between two summaries of dropped entries.
+func WithDropSummary(interval time.Duration) OptFunc {
+	return func(li *Lib) (err error) {
+		li.logOpt = append(li.logOpt, cmd.WithDropSummary(interval))
+		return
+	}
+}
+
+// sinkName is a function that returns the name of a sink, falling back to its type.
+func sinkName(h logrus.Hook) string {
+	if n, ok := h.(interface{ Name() string }); ok {
+		return n.Name()
+	}
+
+	return fmt.Sprintf("%T", h)
+}
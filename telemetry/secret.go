@@ -0,0 +1,52 @@
+// Package telemetry provides functionality for telemetry logging.
+package telemetry
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
+)
+
+// secretMask is the string a Secret is printed as.
+const secretMask = "******"
+
+// Secret is a type that holds a credential and redacts itself when printed, logged or serialized.
+type Secret string
+
+// Reveal is a method that returns the actual value of the secret.
+func (s Secret) Reveal() string {
+	return string(s)
+}
+
+// String is a method that returns the masked secret.
+func (s Secret) String() string {
+	return secretMask
+}
+
+// GoString is a method that returns the masked secret for the %#v verb.
+func (s Secret) GoString() string {
+	return secretMask
+}
+
+// Format is a method that writes the masked secret whatever the fmt verb.
+func (s Secret) Format(f fmt.State, _ rune) {
+	_, _ = io.WriteString(f, secretMask)
+}
+
+// AsLog is a method that returns the masked secret when it is passed to log.Any.
+func (s Secret) AsLog() any {
+	return secretMask
+}
+
+// MarshalJSON is a method that encodes the masked secret as a JSON string.
+func (s Secret) MarshalJSON() ([]byte, error) {
+	return json.Marshal(secretMask)
+}
+
+// MarshalBSONValue is a method that encodes the masked secret as a BSON string.
+func (s Secret) MarshalBSONValue() (bsontype.Type, []byte, error) {
+	return bsontype.String, bsoncore.AppendString(nil, secretMask), nil
+}
@@ -0,0 +1,71 @@
+package telemetry
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dyaksa/telemetry-log/telemetry/log"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestSecretIsMasked(t *testing.T) {
+	s := Secret("hunter2")
+
+	b, err := json.Marshal(struct{ Password Secret }{s})
+	if err != nil || string(b) != `{"Password":"******"}` {
+		t.Errorf("json: got %s, %v", b, err)
+	}
+
+	if got := fmt.Sprintf("%v %s %q %#v %d %+v", s, s, s, s, s, struct{ P Secret }{s}); strings.Contains(got, "hunter2") {
+		t.Errorf("fmt leaked the secret: %s", got)
+	}
+
+	var doc bson.M
+	if b, err = bson.Marshal(bson.M{"password": s}); err == nil {
+		err = bson.Unmarshal(b, &doc)
+	}
+	if err != nil || doc["password"] != secretMask {
+		t.Errorf("bson: got %v, %v", doc, err)
+	}
+
+	if l, ok := interface{}(s).(log.Loggable); !ok || l.AsLog() != secretMask {
+		t.Error("log.Any would log the secret")
+	}
+
+	if s.Reveal() != "hunter2" {
+		t.Errorf("Reveal returned %q", s.Reveal())
+	}
+}
+
+func TestLoadPassword(t *testing.T) {
+	t.Setenv("TELEMETRY_PASSWORD", "from-env")
+
+	li := &Lib{}
+	if err := LoadEnv(li); err != nil {
+		t.Fatal(err)
+	}
+	if li.Password.Reveal() != "from-env" {
+		t.Fatalf("got password %q from the environment", li.Password.Reveal())
+	}
+
+	li.PasswordFile = filepath.Join(t.TempDir(), "password")
+	if err := os.WriteFile(li.PasswordFile, []byte("from-file\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := li.loadPassword(); err != nil {
+		t.Fatal(err)
+	}
+	if li.Password.Reveal() != "from-file" {
+		t.Fatalf("got password %q from the file", li.Password.Reveal())
+	}
+
+	li.PasswordFile = filepath.Join(t.TempDir(), "missing")
+	if err := li.loadPassword(); err == nil {
+		t.Fatal("a missing password file was accepted")
+	}
+}
@@ -0,0 +1,199 @@
+// Package telemetry provides functionality for telemetry logging.
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/dyaksa/telemetry-log/telemetry/spool"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/topology"
+)
+
+// These constants bound the delay between two replay attempts.
+const (
+	replayMinBackoff = time.Second
+	replayMaxBackoff = 30 * time.Second
+)
+
+// spooled is a struct that holds a document kept in the spool and where it is stored.
+type spooled struct {
+	Database   string      `bson:"database"`
+	Collection string      `bson:"collection"`
+	Document   interface{} `bson:"document"`
+}
+
+// WithSpool is a function that returns an OptFunc which keeps on disk, in dir, the entries Mongo failed to store.
+// Spooled entries are replayed in order in the background once Mongo is reachable again; entries logged meanwhile
+// are spooled behind them. The spool is bounded to maxBytes, spool.DefaultMaxBytes when zero.
+func WithSpool(dir string, maxBytes int64) OptFunc {
+	return func(li *Lib) (err error) {
+		if dir == "" {
+			return errors.New("spool directory must not be empty")
+		}
+		if maxBytes < 0 {
+			return fmt.Errorf("invalid spool size: %d", maxBytes)
+		}
+
+		li.spoolDir, li.spoolSize = dir, maxBytes
+		return
+	}
+}
+
+// SpoolStats is a method that returns the depth of the spool, zero when WithSpool is not used.
+func (li *Lib) SpoolStats() spool.Stats {
+	if li.hook == nil || li.hook.Spool == nil {
+		return spool.Stats{}
+	}
+
+	return li.hook.Spool.Stats()
+}
+
+// initSpool is a method that opens the spool of the Mongo sink and starts replaying it until the Lib shuts down.
+func (li *Lib) initSpool() error {
+	var opts []spool.OptFunc
+	if li.spoolSize > 0 {
+		opts = append(opts, spool.WithMaxBytes(li.spoolSize))
+	}
+
+	sp, err := spool.Open(li.spoolDir, opts...)
+	if err != nil {
+		return fmt.Errorf("fail to open spool: %w", err)
+	}
+
+	hook := li.MongoHook()
+	hook.Spool = sp
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		hook.ReplaySpool(ctx)
+	}()
+
+	li.RegisterExitHandler(func(context.Context) error {
+		cancel()
+		<-done
+		return sp.Close()
+	})
+
+	return nil
+}
+
+// fallback is a method that spools the documents of a route when err is transient and a spool is set.
+// It returns err otherwise, or if the documents could not be spooled.
+func (m *MongoHook) fallback(r *entryRoute, docs []interface{}, err error) error {
+	if m.Metrics != nil {
+		kind := "permanent"
+		if transient(err) {
+			kind = "transient"
+		}
+		m.Metrics.Counter("telemetry_mongo_errors_total", "Mongo writes that failed, by kind of error.", "kind").Inc(kind)
+	}
+
+	if m.Spool == nil || !transient(err) {
+		return err
+	}
+
+	for _, doc := range docs {
+		if spoolErr := m.spool(r, doc); spoolErr != nil {
+			return errors.Join(err, spoolErr)
+		}
+	}
+
+	return nil
+}
+
+// spool is a method that appends a document to the spool.
+func (m *MongoHook) spool(r *entryRoute, doc interface{}) error {
+	b, err := bson.Marshal(spooled{Database: r.database, Collection: r.collection, Document: doc})
+	if err != nil {
+		return fmt.Errorf("fail to encode spooled entry: %w", err)
+	}
+
+	if err = m.Spool.Append(b); err != nil {
+		return fmt.Errorf("fail to spool entry: %w", err)
+	}
+
+	return nil
+}
+
+// spooling is a method that reports whether entries are waiting in the spool, in which case new entries are spooled
+// behind them to keep the order.
+func (m *MongoHook) spooling() bool {
+	return m.Spool != nil && m.Spool.Len() > 0
+}
+
+// ReplaySpool is a method that stores the spooled entries in order until ctx is done.
+// It waits with a growing backoff while Mongo is unreachable; entries rejected for another reason are dropped.
+func (m *MongoHook) ReplaySpool(ctx context.Context) {
+	backoff := replayMinBackoff
+	for {
+		err := m.replayOne(ctx)
+		if err == nil {
+			backoff = replayMinBackoff
+			continue
+		}
+
+		if !errors.Is(err, spool.ErrEmpty) {
+			if backoff *= 2; backoff > replayMaxBackoff {
+				backoff = replayMaxBackoff
+			}
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// replayOne is a method that stores the oldest spooled entry and removes it from the spool.
+func (m *MongoHook) replayOne(ctx context.Context) error {
+	b, err := m.Spool.Peek()
+	if err != nil {
+		return err
+	}
+
+	var doc struct {
+		Database   string   `bson:"database"`
+		Collection string   `bson:"collection"`
+		Document   bson.Raw `bson:"document"`
+	}
+	if err = bson.Unmarshal(b, &doc); err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "telemetry: dropping unreadable spooled entry: %v\n", err)
+		return m.Spool.Ack()
+	}
+
+	if m.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, m.Timeout)
+		defer cancel()
+	}
+
+	if m.AutoIndex || m.TimeSeries != nil {
+		if _, err = m.prepare(ctx, doc.Database, doc.Collection); err != nil {
+			return err
+		}
+	}
+
+	if _, err = m.Client.CollectionIn(doc.Database, doc.Collection).InsertOne(ctx, doc.Document); err != nil {
+		if transient(err) {
+			return err
+		}
+		_, _ = fmt.Fprintf(os.Stderr, "telemetry: dropping spooled entry rejected by mongo: %v\n", err)
+	}
+
+	return m.Spool.Ack()
+}
+
+// transient is a function that reports whether a Mongo error is worth retrying later: a network failure,
+// a timeout or no server available.
+func transient(err error) bool {
+	return mongo.IsNetworkError(err) || mongo.IsTimeout(err) || errors.As(err, &topology.ServerSelectionError{})
+}
@@ -0,0 +1,546 @@
+// Package spool provides a disk-backed FIFO queue of records, used to keep the entries a sink failed to deliver.
+//
+// Records are appended to segment files, each record framed by its length and a CRC-32 checksum. The position of
+// the oldest record not yet acknowledged is saved in a cursor file, so the queue survives a restart; segments whose
+// records are all acknowledged are removed. A record failing its checksum is skipped together with the rest of its
+// segment, and a record cut short by a crash at the end of the last segment is discarded when the spool is opened.
+//
+// Segments are synced to disk when they are rotated, when the spool is closed, and at most a sync interval after
+// a record is appended. A record survives a process crash once Append returns, and a machine crash once the
+// segment holding it is synced: at most the records of the last sync interval are lost to a power failure.
+package spool
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// These constants are the defaults of a Spool.
+const (
+	DefaultMaxBytes     = 256 << 20
+	DefaultSegmentSize  = 8 << 20
+	DefaultSyncInterval = time.Second
+)
+
+// headerSize is the size of a record header: the payload length and its checksum.
+const headerSize = 8
+
+// maxRecordSize is the maximum size of a record payload.
+const maxRecordSize = 64 << 20
+
+// These constants are the names of the files of a spool directory.
+const (
+	segmentExt = ".seg"
+	cursorFile = "cursor"
+)
+
+// These variables are the errors returned by a Spool.
+var (
+	ErrFull   = errors.New("spool is full")
+	ErrEmpty  = errors.New("spool is empty")
+	ErrClosed = errors.New("spool is closed")
+
+	errCorrupt = errors.New("corrupt record")
+)
+
+// OptFunc is a type that defines a function that modifies a Spool instance.
+type OptFunc func(*Spool) error
+
+// Stats is a struct that holds the depth of a spool.
+type Stats struct {
+	Records   int64  `json:"records"`   // Records is the number of records waiting.
+	Bytes     int64  `json:"bytes"`     // Bytes is the disk space used by the records waiting.
+	Segments  int    `json:"segments"`  // Segments is the number of segment files.
+	Corrupted uint64 `json:"corrupted"` // Corrupted is the number of segments cut short by a checksum failure since opened.
+}
+
+// Spool is a struct that holds an open spool directory.
+type Spool struct {
+	dir          string
+	maxBytes     int64
+	segmentSize  int64
+	syncInterval time.Duration
+
+	mu       sync.Mutex
+	closed   bool
+	segments []uint64
+	size     map[uint64]int64 // size is the size of each segment.
+	count    map[uint64]int64 // count is the number of records waiting in each segment.
+
+	w     *os.File
+	wID   uint64
+	dirty bool        // dirty reports whether records were appended since the segment was last synced.
+	sync  *time.Timer // sync is the pending sync of the segment being written.
+
+	r    *os.File
+	rID  uint64
+	rOff int64
+
+	next      []byte
+	corrupted uint64
+}
+
+// cursor is a struct that holds the position of the oldest record waiting, as saved in the cursor file.
+type cursor struct {
+	Segment uint64 `json:"segment"`
+	Offset  int64  `json:"offset"`
+}
+
+// WithMaxBytes is a function that returns an OptFunc which bounds the disk space of the spool; Append fails beyond it.
+func WithMaxBytes(n int64) OptFunc {
+	return func(s *Spool) (err error) {
+		if n <= 0 {
+			return fmt.Errorf("invalid max bytes: %d", n)
+		}
+
+		s.maxBytes = n
+		return
+	}
+}
+
+// WithSegmentSize is a function that returns an OptFunc which sets the size after which a new segment file is started.
+func WithSegmentSize(n int64) OptFunc {
+	return func(s *Spool) (err error) {
+		if n <= headerSize {
+			return fmt.Errorf("invalid segment size: %d", n)
+		}
+
+		s.segmentSize = n
+		return
+	}
+}
+
+// WithSyncInterval is a function that returns an OptFunc which sets the delay after which appended records are
+// synced to disk. A zero interval syncs every record before Append returns, at the cost of one fsync per record.
+func WithSyncInterval(d time.Duration) OptFunc {
+	return func(s *Spool) (err error) {
+		if d < 0 {
+			return fmt.Errorf("invalid sync interval: %s", d)
+		}
+
+		s.syncInterval = d
+		return
+	}
+}
+
+// Open is a function that opens the spool stored in dir, creating the directory if needed.
+// It applies the provided options to the Spool instance and counts the records left by a previous run.
+func Open(dir string, opts ...OptFunc) (*Spool, error) {
+	s := &Spool{
+		dir:          dir,
+		maxBytes:     DefaultMaxBytes,
+		segmentSize:  DefaultSegmentSize,
+		syncInterval: DefaultSyncInterval,
+		size:         map[uint64]int64{},
+		count:        map[uint64]int64{},
+	}
+
+	for _, opt := range opts {
+		if err := opt(s); err != nil {
+			return nil, fmt.Errorf("fail to apply options: %w", err)
+		}
+	}
+
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("fail to create spool directory: %w", err)
+	}
+
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// load is a method that reads the cursor, scans the segments and opens the last one for writing.
+func (s *Spool) load() error {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return fmt.Errorf("fail to read spool directory: %w", err)
+	}
+
+	for _, e := range entries {
+		if id, err := strconv.ParseUint(strings.TrimSuffix(e.Name(), segmentExt), 10, 64); err == nil && strings.HasSuffix(e.Name(), segmentExt) {
+			s.segments = append(s.segments, id)
+		}
+	}
+	sort.Slice(s.segments, func(i, j int) bool { return s.segments[i] < s.segments[j] })
+
+	var cur cursor
+	if b, err := os.ReadFile(filepath.Join(s.dir, cursorFile)); err == nil {
+		_ = json.Unmarshal(b, &cur)
+	}
+
+	for len(s.segments) > 0 && s.segments[0] < cur.Segment {
+		_ = os.Remove(s.path(s.segments[0]))
+		s.segments = s.segments[1:]
+	}
+
+	if len(s.segments) == 0 {
+		s.segments = []uint64{cur.Segment + 1}
+		cur = cursor{Segment: cur.Segment + 1}
+	}
+	if s.segments[0] != cur.Segment {
+		cur = cursor{Segment: s.segments[0]}
+	}
+	s.rID, s.rOff = cur.Segment, cur.Offset
+
+	for i, id := range s.segments {
+		from := int64(0)
+		if id == s.rID {
+			from = s.rOff
+		}
+
+		valid, n, err := scan(s.path(id), from)
+		if err != nil {
+			return err
+		}
+
+		last := i == len(s.segments)-1
+		if last {
+			if err = os.Truncate(s.path(id), valid); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return fmt.Errorf("fail to truncate segment: %w", err)
+			}
+		}
+
+		s.size[id], s.count[id] = valid, n
+		if !last {
+			if info, err := os.Stat(s.path(id)); err == nil {
+				s.size[id] = info.Size()
+			}
+		}
+	}
+
+	s.wID = s.segments[len(s.segments)-1]
+	s.w, err = os.OpenFile(s.path(s.wID), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("fail to open segment: %w", err)
+	}
+
+	return nil
+}
+
+// scan is a function that returns the offset after the last valid record of a segment and the number of records
+// from offset from.
+func scan(path string, from int64) (valid, n int64, err error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return from, 0, nil
+	}
+	if err != nil {
+		return 0, 0, fmt.Errorf("fail to open segment: %w", err)
+	}
+	defer f.Close()
+
+	valid = from
+	for {
+		_, size, err := readRecord(f, valid)
+		if err != nil {
+			return valid, n, nil
+		}
+		valid += size
+		n++
+	}
+}
+
+// readRecord is a function that reads the record at offset off and returns its payload and its size on disk.
+func readRecord(f *os.File, off int64) ([]byte, int64, error) {
+	var h [headerSize]byte
+	if _, err := f.ReadAt(h[:], off); err != nil {
+		return nil, 0, err
+	}
+
+	n := binary.BigEndian.Uint32(h[:4])
+	if n > maxRecordSize {
+		return nil, 0, errCorrupt
+	}
+
+	payload := make([]byte, n)
+	if _, err := f.ReadAt(payload, off+headerSize); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, 0, io.ErrUnexpectedEOF
+		}
+		return nil, 0, err
+	}
+
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(h[4:]) {
+		return nil, 0, errCorrupt
+	}
+
+	return payload, headerSize + int64(n), nil
+}
+
+// Append is a method that adds a record at the end of the spool, synced to disk within the sync interval.
+// It returns ErrFull when the record would make the spool exceed its maximum size.
+func (s *Spool) Append(payload []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrClosed
+	}
+
+	if len(payload) > maxRecordSize {
+		return fmt.Errorf("record of %d bytes exceeds the maximum of %d", len(payload), maxRecordSize)
+	}
+
+	size := headerSize + int64(len(payload))
+	if s.bytes()+size > s.maxBytes {
+		return ErrFull
+	}
+
+	if s.size[s.wID] > 0 && s.size[s.wID]+size > s.segmentSize {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+
+	buf := make([]byte, size)
+	binary.BigEndian.PutUint32(buf, uint32(len(payload)))
+	binary.BigEndian.PutUint32(buf[4:], crc32.ChecksumIEEE(payload))
+	copy(buf[headerSize:], payload)
+
+	if _, err := s.w.Write(buf); err != nil {
+		return fmt.Errorf("fail to write record: %w", err)
+	}
+
+	s.size[s.wID] += size
+	s.count[s.wID]++
+
+	if s.syncInterval == 0 {
+		s.dirty = true
+		return s.syncSegment()
+	}
+	if !s.dirty {
+		s.dirty = true
+		s.sync = time.AfterFunc(s.syncInterval, func() { _ = s.Sync() })
+	}
+
+	return nil
+}
+
+// Sync is a method that syncs the records appended so far to disk.
+func (s *Spool) Sync() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil
+	}
+
+	return s.syncSegment()
+}
+
+// syncSegment is a method that syncs the segment being written, if records were appended since the last sync.
+func (s *Spool) syncSegment() error {
+	if s.sync != nil {
+		s.sync.Stop()
+		s.sync = nil
+	}
+
+	if !s.dirty {
+		return nil
+	}
+
+	if err := s.w.Sync(); err != nil {
+		return fmt.Errorf("fail to sync segment: %w", err)
+	}
+
+	s.dirty = false
+	return nil
+}
+
+// rotate is a method that syncs and closes the segment being written and starts a new one.
+func (s *Spool) rotate() error {
+	if err := s.syncSegment(); err != nil {
+		return err
+	}
+
+	if err := s.w.Close(); err != nil {
+		return fmt.Errorf("fail to close segment: %w", err)
+	}
+
+	id := s.wID + 1
+	w, err := os.OpenFile(s.path(id), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("fail to create segment: %w", err)
+	}
+
+	s.w, s.wID = w, id
+	s.segments = append(s.segments, id)
+	return nil
+}
+
+// Peek is a method that returns the oldest record, or ErrEmpty when there is none.
+// The same record is returned until it is acknowledged with Ack.
+func (s *Spool) Peek() ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil, ErrClosed
+	}
+
+	for s.next == nil {
+		if s.r == nil {
+			r, err := os.Open(s.path(s.rID))
+			if err != nil {
+				return nil, fmt.Errorf("fail to open segment: %w", err)
+			}
+			s.r = r
+		}
+
+		payload, _, err := readRecord(s.r, s.rOff)
+		if err == nil {
+			s.next = payload
+			break
+		}
+
+		if s.rID == s.wID {
+			if errors.Is(err, io.EOF) {
+				return nil, ErrEmpty
+			}
+			if !errors.Is(err, errCorrupt) && !errors.Is(err, io.ErrUnexpectedEOF) {
+				return nil, fmt.Errorf("fail to read record: %w", err)
+			}
+		}
+
+		if !errors.Is(err, io.EOF) {
+			s.corrupted++
+		}
+		if err = s.advance(); err != nil {
+			return nil, err
+		}
+	}
+
+	return s.next, nil
+}
+
+// Ack is a method that removes the record returned by Peek.
+func (s *Spool) Ack() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.next == nil {
+		return errors.New("no record to ack")
+	}
+
+	s.rOff += headerSize + int64(len(s.next))
+	s.count[s.rID]--
+	s.next = nil
+
+	if s.rOff >= s.size[s.rID] && s.rID != s.wID {
+		if err := s.advance(); err != nil {
+			return err
+		}
+	}
+
+	return s.saveCursor()
+}
+
+// advance is a method that removes the segment being read and moves to the next one.
+// When the segment being read is also the one being written, it is emptied instead.
+func (s *Spool) advance() error {
+	if s.r != nil {
+		_ = s.r.Close()
+		s.r = nil
+	}
+
+	if s.rID == s.wID {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+
+	_ = os.Remove(s.path(s.rID))
+	delete(s.size, s.rID)
+	delete(s.count, s.rID)
+	s.segments = s.segments[1:]
+
+	s.rID, s.rOff = s.segments[0], 0
+	return s.saveCursor()
+}
+
+// saveCursor is a method that writes the read position to the cursor file, through a rename.
+func (s *Spool) saveCursor() error {
+	b, _ := json.Marshal(cursor{Segment: s.rID, Offset: s.rOff})
+
+	tmp := filepath.Join(s.dir, cursorFile+".tmp")
+	if err := os.WriteFile(tmp, b, 0o600); err != nil {
+		return fmt.Errorf("fail to save cursor: %w", err)
+	}
+
+	if err := os.Rename(tmp, filepath.Join(s.dir, cursorFile)); err != nil {
+		return fmt.Errorf("fail to save cursor: %w", err)
+	}
+
+	return nil
+}
+
+// Len is a method that returns the number of records waiting.
+func (s *Spool) Len() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var n int64
+	for _, c := range s.count {
+		n += c
+	}
+
+	return n
+}
+
+// Stats is a method that returns the depth of the spool.
+func (s *Spool) Stats() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st := Stats{Bytes: s.bytes(), Segments: len(s.segments), Corrupted: s.corrupted}
+	for _, c := range s.count {
+		st.Records += c
+	}
+
+	return st
+}
+
+// bytes is a method that returns the disk space used by the records waiting.
+func (s *Spool) bytes() (n int64) {
+	for _, size := range s.size {
+		n += size
+	}
+
+	return n - s.rOff
+}
+
+// Close is a method that syncs the records appended, saves the read position and closes the segment files.
+func (s *Spool) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+
+	if s.r != nil {
+		_ = s.r.Close()
+	}
+
+	return errors.Join(s.syncSegment(), s.w.Close(), s.saveCursor())
+}
+
+// path is a method that returns the path of a segment file.
+func (s *Spool) path(id uint64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%020d%s", id, segmentExt))
+}
@@ -0,0 +1,141 @@
+package spool_test
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dyaksa/telemetry-log/telemetry/spool"
+)
+
+func TestSpoolOrderAcrossRestarts(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := spool.Open(dir, spool.WithSegmentSize(64))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		if err = s.Append([]byte(fmt.Sprintf("record-%d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err = s.Peek(); err != nil {
+			t.Fatal(err)
+		}
+		if err = s.Ack(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if s, err = spool.Open(dir, spool.WithSegmentSize(64)); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if st := s.Stats(); st.Records != 7 || st.Segments < 2 {
+		t.Fatalf("unexpected stats after reopening: %+v", st)
+	}
+
+	for i := 3; i < 10; i++ {
+		b, err := s.Peek()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := fmt.Sprintf("record-%d", i); string(b) != want {
+			t.Fatalf("got %q, want %q", b, want)
+		}
+		if err = s.Ack(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err = s.Peek(); !errors.Is(err, spool.ErrEmpty) {
+		t.Fatalf("got %v, want ErrEmpty", err)
+	}
+	if st := s.Stats(); st.Records != 0 || st.Bytes != 0 || st.Segments != 1 {
+		t.Fatalf("unexpected stats when empty: %+v", st)
+	}
+}
+
+func TestSpoolLimitsAndTornWrites(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := spool.Open(dir, spool.WithMaxBytes(40))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Append([]byte("first record")); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Append([]byte("a record too many")); !errors.Is(err, spool.ErrFull) {
+		t.Fatalf("got %v, want ErrFull", err)
+	}
+	_ = s.Close()
+
+	segments, _ := filepath.Glob(filepath.Join(dir, "*.seg"))
+	f, err := os.OpenFile(segments[len(segments)-1], os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = f.Write([]byte{0, 0, 0, 9, 1, 2})
+	_ = f.Close()
+
+	if s, err = spool.Open(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if n := s.Len(); n != 1 {
+		t.Fatalf("got %d records after a torn write, want 1", n)
+	}
+	if err = s.Append([]byte("after restart")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{"first record", "after restart"} {
+		b, err := s.Peek()
+		if err != nil || string(b) != want {
+			t.Fatalf("got %q, %v, want %q", b, err, want)
+		}
+		_ = s.Ack()
+	}
+}
+
+func TestSpoolSync(t *testing.T) {
+	if _, err := spool.Open(t.TempDir(), spool.WithSyncInterval(-time.Second)); err == nil {
+		t.Fatal("negative sync interval accepted")
+	}
+
+	for _, d := range []time.Duration{0, time.Millisecond} {
+		s, err := spool.Open(t.TempDir(), spool.WithSyncInterval(d), spool.WithSegmentSize(32))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 0; i < 5; i++ {
+			if err = s.Append([]byte(fmt.Sprintf("record-%d", i))); err != nil {
+				t.Fatal(err)
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+
+		if err = s.Sync(); err != nil {
+			t.Fatal(err)
+		}
+		if err = s.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err = s.Sync(); err != nil {
+			t.Fatalf("Sync() after Close() = %v", err)
+		}
+	}
+}
@@ -0,0 +1,523 @@
+// Package telemetry provides functionality for telemetry logging.
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dyaksa/telemetry-log/cmd"
+	"github.com/dyaksa/telemetry-log/telemetry/derive"
+	"github.com/dyaksa/telemetry-log/telemetry/mongo"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// These constants are the defaults of the stats rollups.
+const (
+	DefaultStatsLateness     = time.Minute
+	DefaultStatsMinuteExpire = 7 * 24 * time.Hour
+	DefaultStatsHourExpire   = 90 * 24 * time.Hour
+)
+
+// These constants tune the stats aggregator.
+const (
+	statsFlushInterval = 10 * time.Second
+	statsGamma         = 1.02 // statsGamma is the ratio between two histogram bins, about 1% of relative error.
+	statsZeroBin       = "zero"
+	statsNegativeBin   = "n" // statsNegativeBin prefixes the bins of negative values, mirroring those of positive ones.
+)
+
+// statsPeriods are the periods rolled up, each entry being counted once in each.
+var statsPeriods = []struct {
+	granularity string
+	length      time.Duration
+}{
+	{granularity: "minute", length: time.Minute},
+	{granularity: "hour", length: time.Hour},
+}
+
+// Stats is a struct that holds the settings of the rollups written to the stats collection.
+type Stats struct {
+	Fields       []string      // Fields are the numeric fields, negative values included, whose count, sum, min, max, p50 and p95 are computed.
+	CodeField    string        // CodeField is the field holding the error code, "code" when empty.
+	Lateness     time.Duration // Lateness is how long a period stays open for late entries after it ends.
+	MinuteExpire time.Duration // MinuteExpire is how long the minute rollups are kept.
+	HourExpire   time.Duration // HourExpire is how long the hour rollups are kept.
+}
+
+// WithStats is a function that returns an OptFunc which rolls the entries up by minute and by hour into the stats
+// collection, so that dashboards don't scan the log collections. Each rollup document counts the entries of a period
+// for a service, environment, level, function and error code, and summarizes the given numeric fields.
+//
+// A period is written once it has ended and the lateness has elapsed; entries arriving later are added to the stored
+// document. Zero durations take the defaults.
+func WithStats(s Stats) OptFunc {
+	return func(li *Lib) (err error) {
+		for _, f := range s.Fields {
+			if f == "" || strings.ContainsAny(f, ".$") {
+				return fmt.Errorf("invalid stats field: %q", f)
+			}
+		}
+
+		if s.Lateness < 0 || s.MinuteExpire < 0 || s.HourExpire < 0 {
+			return errors.New("stats durations must not be negative")
+		}
+
+		if s.CodeField == "" {
+			s.CodeField = "code"
+		}
+		if s.Lateness == 0 {
+			s.Lateness = DefaultStatsLateness
+		}
+		if s.MinuteExpire == 0 {
+			s.MinuteExpire = DefaultStatsMinuteExpire
+		}
+		if s.HourExpire == 0 {
+			s.HourExpire = DefaultStatsHourExpire
+		}
+
+		li.stats = &s
+		return
+	}
+}
+
+// StatsAggregator is a struct that rolls entries up in memory and writes the rollups to a MongoDB collection.
+type StatsAggregator struct {
+	Client      *mongo.Mongo  // Client is a pointer to a Mongo instance.
+	Database    string        // Database is the name template of the database, the client's default when empty.
+	Collection  string        // Collection is the name template of the collection the rollups are written to.
+	Service     string        // Service is the service of entries without a service field.
+	Environment string        // Environment is the environment of entries without an environment field.
+	Stats       Stats         // Stats holds the settings of the rollups.
+	Timeout     time.Duration // Timeout is the duration before a flush times out.
+
+	mu      sync.Mutex
+	buckets map[statsKey]*statsBucket
+	indexed map[string]bool
+	now     func() time.Time
+}
+
+// statsKey is a struct that identifies a rollup.
+type statsKey struct {
+	granularity string
+	start       int64
+	length      time.Duration
+	service     string
+	environment string
+	level       string
+	fn          string
+	code        string
+}
+
+// statsBucket is a struct that holds the rollup of a period kept in memory.
+type statsBucket struct {
+	count  int64
+	fields map[string]*statsField
+}
+
+// statsField is a struct that holds the summary of a numeric field.
+type statsField struct {
+	count    int64
+	sum      float64
+	min, max float64
+	bins     map[string]int64
+}
+
+// Fire is a method that adds the entry to the rollups of its minute and hour.
+func (a *StatsAggregator) Fire(e *logrus.Entry) error {
+	now := a.clock()
+	t := e.Time
+	if t.IsZero() {
+		t = now
+	}
+
+	k := statsKey{
+		service:     stringField(e, "service", a.Service),
+		environment: stringField(e, "environment", a.Environment),
+		level:       e.Level.String(),
+		fn:          stringField(e, "func", ""),
+		code:        stringField(e, a.Stats.CodeField, ""),
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.buckets == nil {
+		a.buckets = map[statsKey]*statsBucket{}
+	}
+
+	for _, p := range statsPeriods {
+		start := t.Truncate(p.length)
+		if now.Sub(start) > a.expire(p.granularity) {
+			continue
+		}
+
+		k.granularity, k.start, k.length = p.granularity, start.Unix(), p.length
+		b, ok := a.buckets[k]
+		if !ok {
+			b = &statsBucket{fields: map[string]*statsField{}}
+			a.buckets[k] = b
+		}
+
+		b.count++
+		for _, name := range a.Stats.Fields {
+			v, ok := derive.Number(e.Data[name])
+			if !ok {
+				continue
+			}
+
+			f, ok := b.fields[name]
+			if !ok {
+				f = &statsField{min: v, max: v, bins: map[string]int64{}}
+				b.fields[name] = f
+			}
+			f.add(v)
+		}
+	}
+
+	return nil
+}
+
+// Levels is a method that returns all logrus levels.
+func (a *StatsAggregator) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Run is a method that writes the rollups of the periods that are over, lateness included, until ctx is done.
+func (a *StatsAggregator) Run(ctx context.Context) {
+	ticker := time.NewTicker(statsFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := a.flush(ctx, false); err != nil {
+				_, _ = fmt.Fprintf(os.Stderr, "telemetry: fail to write stats, retrying: %v\n", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Flush is a method that writes every rollup kept in memory, including those of periods still open.
+func (a *StatsAggregator) Flush(ctx context.Context) error {
+	return a.flush(ctx, true)
+}
+
+// flush is a method that writes the rollups of the periods that are over, or all of them.
+// Rollups that could not be written are kept to be written again.
+func (a *StatsAggregator) flush(ctx context.Context, all bool) (err error) {
+	now := a.clock()
+
+	a.mu.Lock()
+	due := map[statsKey]*statsBucket{}
+	for k, b := range a.buckets {
+		if all || now.Sub(time.Unix(k.start, 0).Add(k.length)) >= a.Stats.Lateness {
+			due[k] = b
+			delete(a.buckets, k)
+		}
+	}
+	a.mu.Unlock()
+
+	if len(due) == 0 {
+		return nil
+	}
+
+	if a.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, a.Timeout)
+		defer cancel()
+	}
+
+	for k, b := range due {
+		if writeErr := a.write(ctx, k, b); writeErr != nil {
+			a.restore(map[statsKey]*statsBucket{k: b})
+			err = errors.Join(err, writeErr)
+		}
+	}
+
+	return err
+}
+
+// write is a method that adds a rollup to its stored document, then updates the percentiles of the document
+// from its merged histograms.
+func (a *StatsAggregator) write(ctx context.Context, k statsKey, b *statsBucket) error {
+	start := time.Unix(k.start, 0).UTC()
+	id := bson.D{
+		{Key: "granularity", Value: k.granularity},
+		{Key: "start", Value: start},
+		{Key: "service", Value: k.service},
+		{Key: "environment", Value: k.environment},
+		{Key: "level", Value: k.level},
+		{Key: "func", Value: k.fn},
+		{Key: "code", Value: k.code},
+	}
+
+	onInsert := append(bson.D{}, id...)
+	onInsert = append(onInsert, bson.E{Key: "expire_at", Value: start.Add(k.length + a.expire(k.granularity))})
+
+	inc := bson.D{{Key: "count", Value: b.count}}
+	lower, upper := bson.D{}, bson.D{}
+	for name, f := range b.fields {
+		prefix := "fields." + name + "."
+		inc = append(inc, bson.E{Key: prefix + "count", Value: f.count}, bson.E{Key: prefix + "sum", Value: f.sum})
+		for bin, n := range f.bins {
+			inc = append(inc, bson.E{Key: prefix + "bins." + bin, Value: n})
+		}
+		lower = append(lower, bson.E{Key: prefix + "min", Value: f.min})
+		upper = append(upper, bson.E{Key: prefix + "max", Value: f.max})
+	}
+
+	update := bson.D{{Key: "$inc", Value: inc}, {Key: "$setOnInsert", Value: onInsert}}
+	if len(b.fields) > 0 {
+		update = append(update, bson.E{Key: "$min", Value: lower}, bson.E{Key: "$max", Value: upper})
+	}
+
+	// Names are expanded for the period of the rollup, so that date placeholders roll over with it.
+	vars := nameVars{service: k.service, environment: k.environment, time: start}
+	database, collection := expandName(a.Database, vars), expandName(a.Collection, vars)
+	if err := a.ensureIndexes(ctx, database, collection); err != nil {
+		return err
+	}
+
+	coll := a.Client.CollectionIn(database, collection)
+	filter := bson.D{{Key: "_id", Value: id}}
+
+	if len(b.fields) == 0 {
+		if _, err := coll.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true)); err != nil {
+			return fmt.Errorf("fail to write %s stats: %w", k.granularity, err)
+		}
+		return nil
+	}
+
+	var stored struct {
+		Fields map[string]struct {
+			Bins map[string]int64 `bson:"bins"`
+		} `bson:"fields"`
+	}
+	opt := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After).SetProjection(bson.M{"fields": 1})
+	if err := coll.FindOneAndUpdate(ctx, filter, update, opt).Decode(&stored); err != nil {
+		return fmt.Errorf("fail to write %s stats: %w", k.granularity, err)
+	}
+
+	set := bson.D{}
+	for name := range b.fields {
+		bins := stored.Fields[name].Bins
+		set = append(set,
+			bson.E{Key: "fields." + name + ".p50", Value: percentile(bins, 0.5)},
+			bson.E{Key: "fields." + name + ".p95", Value: percentile(bins, 0.95)},
+		)
+	}
+
+	// The counts are stored at this point; a failure only leaves the percentiles stale until the next write.
+	if _, err := coll.UpdateOne(ctx, filter, bson.D{{Key: "$set", Value: set}}); err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "telemetry: fail to update %s stats percentiles: %v\n", k.granularity, err)
+	}
+
+	return nil
+}
+
+// restore is a method that merges rollups that could not be written back into the ones kept in memory.
+func (a *StatsAggregator) restore(buckets map[statsKey]*statsBucket) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for k, b := range buckets {
+		cur, ok := a.buckets[k]
+		if !ok {
+			a.buckets[k] = b
+			continue
+		}
+
+		cur.count += b.count
+		for name, f := range b.fields {
+			if c, ok := cur.fields[name]; ok {
+				c.merge(f)
+				continue
+			}
+			cur.fields[name] = f
+		}
+	}
+}
+
+// ensureIndexes is a method that creates the indexes of a stats collection once.
+func (a *StatsAggregator) ensureIndexes(ctx context.Context, database, collection string) error {
+	key := database + "." + collection
+
+	a.mu.Lock()
+	indexed := a.indexed[key]
+	a.mu.Unlock()
+
+	if indexed {
+		return nil
+	}
+
+	if _, err := a.Client.CollectionIn(database, collection).Indexes().CreateMany(ctx, statsIndexes); err != nil {
+		return fmt.Errorf("fail to create stats indexes: %w", err)
+	}
+
+	a.mu.Lock()
+	if a.indexed == nil {
+		a.indexed = map[string]bool{}
+	}
+	a.indexed[key] = true
+	a.mu.Unlock()
+	return nil
+}
+
+// expire is a method that returns how long the rollups of a granularity are kept.
+func (a *StatsAggregator) expire(granularity string) time.Duration {
+	if granularity == "hour" {
+		return a.Stats.HourExpire
+	}
+
+	return a.Stats.MinuteExpire
+}
+
+// clock is a method that returns the current time.
+func (a *StatsAggregator) clock() time.Time {
+	if a.now != nil {
+		return a.now()
+	}
+
+	return time.Now()
+}
+
+// add is a method that records a value.
+func (f *statsField) add(v float64) {
+	f.count++
+	f.sum += v
+	f.min = math.Min(f.min, v)
+	f.max = math.Max(f.max, v)
+	f.bins[statsBin(v)]++
+}
+
+// merge is a method that adds the values recorded by o.
+func (f *statsField) merge(o *statsField) {
+	f.count += o.count
+	f.sum += o.sum
+	f.min = math.Min(f.min, o.min)
+	f.max = math.Max(f.max, o.max)
+	for bin, n := range o.bins {
+		f.bins[bin] += n
+	}
+}
+
+// statsBin is a function that returns the histogram bin of a value. Bins grow geometrically by statsGamma,
+// so that histograms written at different times merge exactly. Negative values go to the mirrored bins of their
+// absolute value, and zero has a bin of its own.
+func statsBin(v float64) string {
+	switch {
+	case v == 0:
+		return statsZeroBin
+	case v < 0:
+		return statsNegativeBin + statsBin(-v)
+	}
+
+	return strconv.Itoa(int(math.Ceil(math.Log(v) / math.Log(statsGamma))))
+}
+
+// statsBinValue is a function that returns the value a bin stands for. Bin i holds the values in (γ^(i-1), γ^i],
+// and 2γ^i/(γ+1) is off by the same relative error, (γ-1)/(γ+1), from both bounds.
+func statsBinValue(bin string) float64 {
+	if rest, ok := strings.CutPrefix(bin, statsNegativeBin); ok {
+		return -statsBinValue(rest)
+	}
+
+	i, err := strconv.Atoi(bin)
+	if err != nil {
+		return 0
+	}
+
+	return 2 * math.Pow(statsGamma, float64(i)) / (statsGamma + 1)
+}
+
+// percentile is a function that returns the q-quantile of the values of a histogram, zero when it is empty.
+func percentile(bins map[string]int64, q float64) float64 {
+	values := make([]float64, 0, len(bins))
+	counts := map[float64]int64{}
+
+	var total int64
+	for bin, n := range bins {
+		v := statsBinValue(bin)
+		if _, ok := counts[v]; !ok {
+			values = append(values, v)
+		}
+		counts[v] += n
+		total += n
+	}
+
+	if total == 0 {
+		return 0
+	}
+	sort.Float64s(values)
+
+	rank := int64(math.Ceil(q * float64(total)))
+	var cumulative int64
+	for _, v := range values {
+		if cumulative += counts[v]; cumulative >= rank {
+			return v
+		}
+	}
+
+	return values[len(values)-1]
+}
+
+// stringField is a function that returns a string field of an entry, def when it is missing or empty.
+func stringField(e *logrus.Entry, key, def string) string {
+	switch v := e.Data[key].(type) {
+	case nil:
+		return def
+	case string:
+		if v == "" {
+			return def
+		}
+		return v
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// initStats is a method that adds the stats aggregator to the logger, writes its rollups in the background,
+// and writes the remaining ones when the Lib shuts down.
+func (li *Lib) initStats() error {
+	if li.mc == nil {
+		return errors.New("stats require a Mongo connection")
+	}
+
+	agg := &StatsAggregator{
+		Client:      li.mc,
+		Database:    li.Database,
+		Collection:  li.StatsCollection,
+		Service:     li.Service,
+		Environment: li.Environment,
+		Stats:       *li.stats,
+		Timeout:     10 * time.Second,
+	}
+	li.logOpt = append(li.logOpt, cmd.WithHook(agg))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		agg.Run(ctx)
+	}()
+
+	li.RegisterExitHandler(func(ctx context.Context) error {
+		cancel()
+		<-done
+		return agg.Flush(ctx)
+	})
+
+	return nil
+}
@@ -0,0 +1,132 @@
+package telemetry
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestStatsAggregatorBuckets(t *testing.T) {
+	now := time.Date(2024, 6, 14, 10, 30, 30, 0, time.UTC)
+	a := &StatsAggregator{
+		Service: "billing",
+		Stats:   Stats{Fields: []string{"latency_ms"}, CodeField: "code", MinuteExpire: time.Hour, HourExpire: 24 * time.Hour},
+		now:     func() time.Time { return now },
+	}
+
+	entries := []*logrus.Entry{
+		{Time: now, Level: logrus.ErrorLevel, Data: logrus.Fields{"func": "Pay", "code": 502, "latency_ms": 100}},
+		{Time: now.Add(-10 * time.Second), Level: logrus.ErrorLevel, Data: logrus.Fields{"func": "Pay", "code": 502, "latency_ms": 300}},
+		{Time: now.Add(-2 * time.Hour), Level: logrus.ErrorLevel, Data: logrus.Fields{"func": "Pay", "code": 502}},
+	}
+	for _, e := range entries {
+		_ = a.Fire(e)
+	}
+
+	minute := statsKey{granularity: "minute", start: now.Truncate(time.Minute).Unix(), length: time.Minute,
+		service: "billing", level: "error", fn: "Pay", code: "502"}
+	b, ok := a.buckets[minute]
+	if !ok || b.count != 2 {
+		t.Fatalf("unexpected minute bucket: %+v", a.buckets)
+	}
+	if f := b.fields["latency_ms"]; f.count != 2 || f.sum != 400 || f.min != 100 || f.max != 300 {
+		t.Fatalf("unexpected field summary: %+v", f)
+	}
+
+	// The late entry is past the minute retention but still within the hour one.
+	if len(a.buckets) != 3 {
+		t.Fatalf("expected 3 buckets, got %d", len(a.buckets))
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	bins := map[string]int64{}
+	for v := 1; v <= 100; v++ {
+		bins[statsBin(float64(v))]++
+	}
+	bins[statsBin(0)] += 10
+
+	for q, want := range map[float64]float64{0.5: 45, 0.95: 94} {
+		if got := percentile(bins, q); math.Abs(got-want)/want > 0.02 {
+			t.Errorf("p%v = %v, want about %v", q*100, got, want)
+		}
+	}
+
+	negative := map[string]int64{}
+	for v := -100; v <= -1; v++ {
+		negative[statsBin(float64(v))]++
+	}
+	for q, want := range map[float64]float64{0.5: -51, 0.95: -6} {
+		if got := percentile(negative, q); math.Abs(got-want)/-want > 0.02 {
+			t.Errorf("p%v of negative values = %v, want about %v", q*100, got, want)
+		}
+	}
+
+	if got := percentile(nil, 0.5); got != 0 {
+		t.Errorf("percentile of an empty histogram = %v", got)
+	}
+}
+
+func TestStatsAggregatorWrite(t *testing.T) {
+	li := connectTest(t)
+	now := time.Date(2024, 6, 14, 10, 30, 30, 0, time.UTC)
+	a := &StatsAggregator{
+		Client:     li.Mongo(),
+		Database:   li.Database,
+		Collection: li.StatsCollection,
+		Service:    "billing",
+		Stats:      Stats{Fields: []string{"latency_ms"}, CodeField: "code", Lateness: time.Minute, MinuteExpire: time.Hour, HourExpire: 24 * time.Hour},
+		now:        func() time.Time { return now },
+	}
+
+	for _, v := range []int{100, 300} {
+		_ = a.Fire(&logrus.Entry{Time: now, Level: logrus.InfoLevel, Data: logrus.Fields{"func": "Pay", "latency_ms": v}})
+	}
+
+	// A failed write keeps the rollups in memory, so that they are written with the next ones.
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := a.Flush(canceled); err == nil {
+		t.Fatal("Flush() succeeded with a canceled context")
+	}
+	if len(a.buckets) != 2 {
+		t.Fatalf("got %d buckets after a failed write, want the minute and hour ones restored", len(a.buckets))
+	}
+
+	_ = a.Fire(&logrus.Entry{Time: now, Level: logrus.InfoLevel, Data: logrus.Fields{"func": "Pay", "latency_ms": -50}})
+	ctx := context.Background()
+	if err := a.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	var got struct {
+		Count    int64     `bson:"count"`
+		ExpireAt time.Time `bson:"expire_at"`
+		Fields   map[string]struct {
+			Count int64   `bson:"count"`
+			Sum   float64 `bson:"sum"`
+			Min   float64 `bson:"min"`
+			Max   float64 `bson:"max"`
+			P50   float64 `bson:"p50"`
+		} `bson:"fields"`
+	}
+	filter := bson.D{{Key: "_id.granularity", Value: "minute"}}
+	if err := li.Mongo().CollectionIn(li.Database, li.StatsCollection).FindOne(ctx, filter).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+
+	f := got.Fields["latency_ms"]
+	if got.Count != 3 || f.Count != 3 || f.Sum != 350 || f.Min != -50 || f.Max != 300 {
+		t.Errorf("unexpected rollup: %+v", got)
+	}
+	if math.Abs(f.P50-100)/100 > 0.02 {
+		t.Errorf("p50 = %v, want about 100", f.P50)
+	}
+	if want := now.Truncate(time.Minute).Add(time.Minute + time.Hour); !got.ExpireAt.Equal(want) {
+		t.Errorf("expire_at = %v, want %v", got.ExpireAt, want)
+	}
+}
@@ -0,0 +1,100 @@
+// Package telemetry provides functionality for telemetry logging.
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// These constants are the server error codes createTimeSeries handles.
+const (
+	namespaceExists = 48    // namespaceExists is returned when creating a collection that already exists.
+	commandNotFound = 59    // commandNotFound is returned by servers that do not know the command.
+	invalidOptions  = 72    // invalidOptions is returned by servers that reject the time-series options.
+	unknownField    = 40415 // unknownField is returned by servers before 5.0, which do not know the timeseries field.
+)
+
+// TimeSeries is a struct that holds the settings of the time-series collections entries are written to.
+type TimeSeries struct {
+	Granularity string        // Granularity is "seconds", "minutes" or "hours".
+	Expire      time.Duration // Expire is how long entries are kept, forever when zero.
+}
+
+// WithTimeSeries is a function that returns an OptFunc which stores entries in MongoDB time-series collections.
+// Collections are created on first use with trace_date as time field and a meta field holding the service,
+// level and host. Servers without time-series support, and collections that already exist as regular ones,
+// keep working as regular collections. The per-level retention does not apply to time-series collections.
+func WithTimeSeries(granularity string, expire time.Duration) OptFunc {
+	return func(li *Lib) (err error) {
+		switch granularity {
+		case "seconds", "minutes", "hours":
+		default:
+			return fmt.Errorf("invalid time-series granularity: %s", granularity)
+		}
+
+		if expire < 0 {
+			return fmt.Errorf("invalid time-series expiry: %s", expire)
+		}
+
+		li.timeSeries = &TimeSeries{Granularity: granularity, Expire: expire}
+		return
+	}
+}
+
+// timeSeriesIndexes are the secondary indexes of time-series collections, on the meta field and time.
+var timeSeriesIndexes = []mongo.IndexModel{
+	{Keys: bson.D{{Key: "meta.level", Value: 1}, {Key: "trace_date", Value: -1}}},
+	{Keys: bson.D{{Key: "meta.service", Value: 1}, {Key: "trace_date", Value: -1}}},
+}
+
+// createTimeSeries is a method that creates a time-series collection and reports whether the collection is one.
+// It falls back to a regular collection when the server does not support time series.
+func (m *MongoHook) createTimeSeries(ctx context.Context, database, collection string) (bool, error) {
+	db := m.Client.Database(database)
+
+	tso := options.TimeSeries().SetTimeField("trace_date").SetMetaField("meta").SetGranularity(m.TimeSeries.Granularity)
+	opt := options.CreateCollection().SetTimeSeriesOptions(tso)
+	if m.TimeSeries.Expire > 0 {
+		opt.SetExpireAfterSeconds(int64(m.TimeSeries.Expire.Seconds()))
+	}
+
+	err := db.CreateCollection(ctx, collection, opt)
+	if err == nil {
+		return true, nil
+	}
+
+	var se mongo.ServerError
+	switch {
+	case errors.As(err, &se) && se.HasErrorCode(namespaceExists):
+	case timeSeriesUnsupported(err):
+		_, _ = fmt.Fprintf(os.Stderr, "time-series collections unsupported, %s is a regular collection: %v\n", collection, err)
+		return false, nil
+	default:
+		return false, fmt.Errorf("fail to create time-series collection %s: %w", collection, err)
+	}
+
+	specs, err := db.ListCollectionSpecifications(ctx, bson.D{{Key: "name", Value: collection}})
+	if err != nil {
+		return false, fmt.Errorf("fail to inspect collection %s: %w", collection, err)
+	}
+
+	return len(specs) == 1 && specs[0].Type == "timeseries", nil
+}
+
+// timeSeriesUnsupported is a function that reports whether err is the answer of a server without time-series support.
+// Other errors, such as authorization or invalid name ones, are not hidden by a fallback to a regular collection.
+func timeSeriesUnsupported(err error) bool {
+	var se mongo.ServerError
+	if !errors.As(err, &se) {
+		return false
+	}
+
+	return se.HasErrorCode(commandNotFound) || se.HasErrorCode(invalidOptions) || se.HasErrorCode(unknownField)
+}
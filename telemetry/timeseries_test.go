@@ -0,0 +1,28 @@
+package telemetry
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestTimeSeriesUnsupported(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{mongo.CommandError{Code: unknownField, Message: "BSON field 'create.timeseries' is an unknown field."}, true},
+		{fmt.Errorf("create: %w", mongo.CommandError{Code: invalidOptions}), true},
+		{mongo.CommandError{Code: 13, Name: "Unauthorized"}, false},
+		{mongo.CommandError{Code: 73, Name: "InvalidNamespace"}, false},
+		{errors.New("connection refused"), false},
+	}
+
+	for _, tt := range tests {
+		if got := timeSeriesUnsupported(tt.err); got != tt.want {
+			t.Errorf("timeSeriesUnsupported(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+// Package wire provides the entry encoding and the framing protocol shared by the forwarding sink and the collector.
+//
+// Every message is a frame made of a 4-byte big-endian length followed by a JSON document of that length.
+// A client first sends a hello message carrying its API key, then batches of entries numbered by a sequence;
+// the server answers each message with an ack carrying the same sequence, once the batch has been stored.
+// A client resends the batches left unacked when the connection drops, and those acked with Retry set.
+package wire
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// MaxFrameSize is the maximum length of a frame payload.
+const MaxFrameSize = 16 << 20
+
+// These constants represent the different message types.
+const (
+	TypeHello = "hello" // TypeHello opens a session, carrying the API key.
+	TypeBatch = "batch" // TypeBatch carries entries.
+	TypeAck   = "ack"   // TypeAck acknowledges a hello or a batch, with an error if it was rejected.
+)
+
+// ErrFrameTooLarge is returned when a frame exceeds MaxFrameSize.
+var ErrFrameTooLarge = errors.New("frame too large")
+
+// Entry is a struct that holds a log entry as transmitted to the collector.
+// It is encoded like the logrus JSON formatter output: time, level and msg next to the fields.
+type Entry struct {
+	Time    time.Time
+	Level   string
+	Message string
+	Fields  map[string]interface{}
+}
+
+// MarshalJSON is a method that encodes the entry as a flat JSON object.
+func (e Entry) MarshalJSON() ([]byte, error) {
+	m := make(map[string]interface{}, len(e.Fields)+3)
+	for k, v := range e.Fields {
+		if err, ok := v.(error); ok {
+			v = err.Error()
+		}
+		m[k] = v
+	}
+
+	m["time"] = e.Time.Format(time.RFC3339Nano)
+	m["level"] = e.Level
+	m["msg"] = e.Message
+	return json.Marshal(m)
+}
+
+// UnmarshalJSON is a method that decodes a flat JSON object, the keys other than time, level and msg being fields.
+func (e *Entry) UnmarshalJSON(b []byte) error {
+	var m map[string]interface{}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return err
+	}
+
+	if s, ok := m["time"].(string); ok {
+		t, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			return fmt.Errorf("invalid time: %w", err)
+		}
+		e.Time = t
+	}
+	e.Level, _ = m["level"].(string)
+	e.Message, _ = m["msg"].(string)
+
+	delete(m, "time")
+	delete(m, "level")
+	delete(m, "msg")
+	e.Fields = m
+	return nil
+}
+
+// Message is a struct that holds a message exchanged over a connection.
+type Message struct {
+	Type    string  `json:"type"`
+	Seq     uint64  `json:"seq"`
+	Key     string  `json:"key,omitempty"`
+	Entries []Entry `json:"entries,omitempty"`
+	Error   string  `json:"error,omitempty"`
+	Retry   bool    `json:"retry,omitempty"` // Retry tells the client a rejected batch may be sent again later.
+}
+
+// WriteFrame is a function that writes a message as a length-prefixed frame.
+func WriteFrame(w io.Writer, m *Message) error {
+	payload, err := json.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("fail to encode message: %w", err)
+	}
+
+	if len(payload) > MaxFrameSize {
+		return ErrFrameTooLarge
+	}
+
+	buf := make([]byte, 4+len(payload))
+	binary.BigEndian.PutUint32(buf, uint32(len(payload)))
+	copy(buf[4:], payload)
+
+	_, err = w.Write(buf)
+	return err
+}
+
+// ReadFrame is a function that reads a length-prefixed frame and decodes its message.
+func ReadFrame(r *bufio.Reader) (*Message, error) {
+	var size [4]byte
+	if _, err := io.ReadFull(r, size[:]); err != nil {
+		return nil, err
+	}
+
+	n := binary.BigEndian.Uint32(size[:])
+	if n > MaxFrameSize {
+		return nil, ErrFrameTooLarge
+	}
+
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+
+	m := &Message{}
+	if err := json.Unmarshal(payload, m); err != nil {
+		return nil, fmt.Errorf("fail to decode message: %w", err)
+	}
+
+	return m, nil
+}